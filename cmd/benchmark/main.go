@@ -33,6 +33,14 @@ type BenchmarkStats struct {
 	P95Latency        time.Duration
 	P99Latency        time.Duration
 	QPS               float64
+
+	// CacheHits, CacheMisses, and CachePrefetches mirror
+	// dns.Handler.CacheStats, left at zero here: this tool only ever speaks
+	// the wire DNS protocol to the target server, which has no way to
+	// report its in-process cache counters back over that protocol.
+	CacheHits       int64
+	CacheMisses     int64
+	CachePrefetches int64
 }
 
 func main() {
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"math"
+	"math/bits"
+	"time"
+)
+
+// histSubBuckets is how many linear sub-buckets each power-of-two octave is
+// split into. Higher values trade memory for percentile precision; 32 keeps
+// relative error within ~3% per octave, plenty for p50/p90/p99/p999
+// reporting on DNS query latencies.
+const histSubBuckets = 32
+
+// histOctaves covers latencies from 1ns up to 2^48ns (~3.25 days), far past
+// anything a DNS query could plausibly take.
+const histOctaves = 48
+
+// histogram is a log-linear latency histogram in the style of HDR
+// histogram: each power-of-two range of nanoseconds ("octave") is divided
+// into histSubBuckets equal-width buckets. Observing into a bounded set of
+// counters, rather than keeping every raw sample, is what lets a worker run
+// indefinitely without its memory footprint growing — the same goal a
+// fixed-size ring buffer would serve, but without a ring buffer's bias
+// toward whichever samples happen to still be in the window.
+//
+// A histogram is only ever touched by the worker that owns it, so it needs
+// no locking; mergeInto combines worker histograms into one final report.
+type histogram struct {
+	buckets [histOctaves * histSubBuckets]int64
+	under   int64 // zero or negative durations (clock anomalies), bucketed separately
+	count   int64
+}
+
+// observe records one latency sample.
+func (h *histogram) observe(d time.Duration) {
+	h.count++
+
+	ns := d.Nanoseconds()
+	if ns <= 0 {
+		h.under++
+		return
+	}
+	h.buckets[bucketIndex(ns)]++
+}
+
+// bucketIndex returns the bucket nsec falls into: its octave is
+// floor(log2(nsec)), and within that octave it's split linearly into
+// histSubBuckets.
+func bucketIndex(nsec int64) int {
+	octave := bits.Len64(uint64(nsec)) - 1
+	if octave >= histOctaves {
+		octave = histOctaves - 1
+	}
+
+	base := int64(1) << uint(octave)
+	frac := float64(nsec-base) / float64(base)
+	sub := int(frac * histSubBuckets)
+	if sub >= histSubBuckets {
+		sub = histSubBuckets - 1
+	}
+	return octave*histSubBuckets + sub
+}
+
+// bucketUpperBound returns the upper edge of the nanosecond range bucket i
+// covers, used as the reported value for any sample that landed in it.
+func bucketUpperBound(i int) time.Duration {
+	octave := i / histSubBuckets
+	sub := i % histSubBuckets
+	base := int64(1) << uint(octave)
+	upper := base + int64(float64(base)*float64(sub+1)/histSubBuckets)
+	return time.Duration(upper)
+}
+
+// mergeInto adds h's counts into dst, combining per-worker histograms into
+// one distribution covering the whole run.
+func (h *histogram) mergeInto(dst *histogram) {
+	dst.count += h.count
+	dst.under += h.under
+	for i, c := range h.buckets {
+		dst.buckets[i] += c
+	}
+}
+
+// percentile returns the smallest observed-bucket value at or above the pth
+// percentile (0 < p <= 100) of samples recorded so far.
+func (h *histogram) percentile(p float64) time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+
+	target := int64(math.Ceil(p / 100 * float64(h.count)))
+	if target < 1 {
+		target = 1
+	}
+
+	cum := h.under
+	if cum >= target {
+		return 0
+	}
+	for i, c := range h.buckets {
+		cum += c
+		if cum >= target {
+			return bucketUpperBound(i)
+		}
+	}
+	return bucketUpperBound(len(h.buckets) - 1)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHistogramPercentileOrdering(t *testing.T) {
+	var h histogram
+	for i := 1; i <= 1000; i++ {
+		h.observe(time.Duration(i) * time.Millisecond)
+	}
+
+	p50 := h.percentile(50)
+	p90 := h.percentile(90)
+	p99 := h.percentile(99)
+	p999 := h.percentile(99.9)
+
+	if !(p50 <= p90 && p90 <= p99 && p99 <= p999) {
+		t.Errorf("expected percentiles to be non-decreasing, got p50=%v p90=%v p99=%v p999=%v", p50, p90, p99, p999)
+	}
+
+	// 1000 uniformly-spaced 1ms..1000ms samples: p50 should land near 500ms
+	// and p99 near 990ms, within the log-linear bucket's expected precision.
+	if p50 < 480*time.Millisecond || p50 > 520*time.Millisecond {
+		t.Errorf("expected p50 near 500ms, got %v", p50)
+	}
+	if p99 < 960*time.Millisecond || p99 > 1030*time.Millisecond {
+		t.Errorf("expected p99 near 990ms, got %v", p99)
+	}
+}
+
+func TestHistogramEmpty(t *testing.T) {
+	var h histogram
+	if p := h.percentile(50); p != 0 {
+		t.Errorf("expected percentile(50) of an empty histogram to be 0, got %v", p)
+	}
+}
+
+func TestHistogramMergeInto(t *testing.T) {
+	var a, b, merged histogram
+	for i := 1; i <= 100; i++ {
+		a.observe(time.Duration(i) * time.Millisecond)
+	}
+	for i := 101; i <= 200; i++ {
+		b.observe(time.Duration(i) * time.Millisecond)
+	}
+
+	a.mergeInto(&merged)
+	b.mergeInto(&merged)
+
+	if merged.count != 200 {
+		t.Errorf("expected merged count 200, got %d", merged.count)
+	}
+	if p50 := merged.percentile(50); p50 < 90*time.Millisecond || p50 > 110*time.Millisecond {
+		t.Errorf("expected merged p50 near 100ms, got %v", p50)
+	}
+}
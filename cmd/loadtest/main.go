@@ -1,120 +1,373 @@
 package main
 
 import (
+	"bufio"
+	"encoding/json"
+	"flag"
 	"fmt"
 	"os"
-	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/miekg/dns"
 )
 
+// queryRecord is one entry in a load test's query mix: a name to query and
+// the record type to query it for.
+type queryRecord struct {
+	Name  string
+	Qtype uint16
+}
+
+// defaultQueryMix is used when -queryfile isn't given, mirroring the mix of
+// local and upstream lookups cmd/benchmark's "mixed" test type exercises.
+var defaultQueryMix = []queryRecord{
+	{"ris-desktop.home", dns.TypeA},
+	{"google.com", dns.TypeA},
+	{"ris-laptop.home", dns.TypeA},
+	{"github.com", dns.TypeA},
+	{"homeserver.home", dns.TypeA},
+	{"stackoverflow.com", dns.TypeA},
+}
+
+// workerStats accumulates one worker's query outcomes. Each worker owns
+// exactly one workerStats and only that worker ever writes to it, so the
+// hot loop needs no locking; runLoadTest merges every worker's stats into
+// one Summary after all of them have stopped.
+type workerStats struct {
+	total   int64
+	success int64
+	latency histogram
+	byRcode map[string]int64
+	byQtype map[string]int64
+}
+
+func newWorkerStats() *workerStats {
+	return &workerStats{
+		byRcode: make(map[string]int64),
+		byQtype: make(map[string]int64),
+	}
+}
+
+// record folds one query's outcome into w.
+func (w *workerStats) record(qtype uint16, resp *dns.Msg, err error, latency time.Duration) {
+	w.total++
+	w.latency.observe(latency)
+	w.byQtype[dns.TypeToString[qtype]]++
+
+	if err != nil {
+		w.byRcode["ERROR"]++
+		return
+	}
+	w.success++
+	if name, ok := dns.RcodeToString[resp.Rcode]; ok {
+		w.byRcode[name]++
+	} else {
+		w.byRcode["UNKNOWN"]++
+	}
+}
+
+// LatencyPercentiles reports tail latencies in milliseconds, computed from
+// a log-linear histogram rather than a sorted sample slice so reporting
+// cost stays flat regardless of how many queries ran.
+type LatencyPercentiles struct {
+	P50Ms  float64 `json:"p50_ms"`
+	P90Ms  float64 `json:"p90_ms"`
+	P99Ms  float64 `json:"p99_ms"`
+	P999Ms float64 `json:"p999_ms"`
+}
+
+// Summary is the load test's machine-readable result, written as JSON
+// alongside the human-readable report when -json is given.
+type Summary struct {
+	Server            string             `json:"server"`
+	Duration          string             `json:"duration"`
+	Concurrency       int                `json:"concurrency"`
+	RateLimitQPS      float64            `json:"rate_limit_qps,omitempty"`
+	TotalQueries      int64              `json:"total_queries"`
+	SuccessfulQueries int64              `json:"successful_queries"`
+	QPS               float64            `json:"qps"`
+	Latency           LatencyPercentiles `json:"latency"`
+	ByRcode           map[string]int64   `json:"by_rcode"`
+	ByQtype           map[string]int64   `json:"by_qtype"`
+}
+
 func main() {
-	if len(os.Args) < 4 {
-		fmt.Println("Usage: go run cmd/loadtest/main.go <server:port> <duration-seconds> <concurrent>")
-		fmt.Println("Example: go run cmd/loadtest/main.go 127.0.0.1:8053 30 50")
+	server := flag.String("server", "", "DNS server to load test, as host:port")
+	duration := flag.Duration("duration", 30*time.Second, "how long to run the load test")
+	concurrency := flag.Int("concurrency", 50, "number of concurrent worker goroutines")
+	rate := flag.Float64("rate", 0, "target total queries/sec across all workers, paced by a token bucket (0 = unlimited, bounded only by -concurrency)")
+	queryFile := flag.String("queryfile", "", `path to a file of "name qtype" lines (e.g. "google.com A") to use as the query mix; defaults to a small built-in mix`)
+	jsonOut := flag.String("json", "", "path to write a machine-readable JSON summary to, alongside the human-readable report")
+	flag.Parse()
+
+	if *server == "" {
+		fmt.Println("Usage: go run ./cmd/loadtest -server <host:port> [flags]")
+		flag.PrintDefaults()
 		os.Exit(1)
 	}
 
-	server := os.Args[1]
-	duration, _ := strconv.Atoi(os.Args[2])
-	concurrent, _ := strconv.Atoi(os.Args[3])
+	queries := defaultQueryMix
+	if *queryFile != "" {
+		loaded, err := loadQueryMix(*queryFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "❌ failed to load query file: %v\n", err)
+			os.Exit(1)
+		}
+		queries = loaded
+	}
 
-	fmt.Printf("🔥 DNS Load Test\n")
-	fmt.Printf("📊 Server: %s\n", server)
-	fmt.Printf("📊 Duration: %d seconds\n", duration)
-	fmt.Printf("📊 Concurrent Workers: %d\n", concurrent)
+	fmt.Println("🚀 Pocket-Concierge Load Test")
+	fmt.Printf("📊 Server: %s\n", *server)
+	fmt.Printf("📊 Duration: %s\n", *duration)
+	fmt.Printf("📊 Concurrency: %d\n", *concurrency)
+	if *rate > 0 {
+		fmt.Printf("📊 Target rate: %.1f qps\n", *rate)
+	}
 	fmt.Println("==========================================")
 
-	runLoadTest(server, time.Duration(duration)*time.Second, concurrent)
+	summary := runLoadTest(*server, *duration, *concurrency, *rate, queries)
+	displaySummary(summary)
+
+	if *jsonOut != "" {
+		if err := writeJSONSummary(*jsonOut, summary); err != nil {
+			fmt.Fprintf(os.Stderr, "❌ failed to write JSON summary: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("📄 JSON summary written to %s\n", *jsonOut)
+	}
 }
 
-func runLoadTest(server string, duration time.Duration, concurrent int) {
-	var wg sync.WaitGroup
-	var mu sync.Mutex
+// loadQueryMix reads a query mix file: one "name qtype" pair per line,
+// blank lines and lines starting with "#" ignored.
+func loadQueryMix(path string) ([]queryRecord, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
 
-	totalQueries := 0
-	successfulQueries := 0
-	totalLatency := time.Duration(0)
+	var queries []queryRecord
+	scanner := bufio.NewScanner(f)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
 
-	stopChan := make(chan bool)
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: expected \"name qtype\", got %q", lineNo, line)
+		}
 
-	// Start timer
-	go func() {
-		time.Sleep(duration)
-		close(stopChan)
-	}()
+		qtype, ok := dns.StringToType[strings.ToUpper(fields[1])]
+		if !ok {
+			return nil, fmt.Errorf("line %d: unknown query type %q", lineNo, fields[1])
+		}
+		queries = append(queries, queryRecord{Name: fields[0], Qtype: qtype})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(queries) == 0 {
+		return nil, fmt.Errorf("%s: no queries found", path)
+	}
+	return queries, nil
+}
 
-	// Progress reporter
-	ticker := time.NewTicker(time.Second)
-	defer ticker.Stop()
+// tokenBucket paces callers to a target rate by handing out one token per
+// take() call, refilled at a steady rate in the background. It replaces
+// the flat-out, unthrottled concurrency the original load tester used.
+type tokenBucket struct {
+	tokens chan struct{}
+}
+
+// newTokenBucket starts a token bucket refilling at rate tokens/sec, with a
+// small burst capacity so workers don't stall waiting on the exact tick.
+func newTokenBucket(rate float64) *tokenBucket {
+	capacity := int(rate / 10)
+	if capacity < 1 {
+		capacity = 1
+	}
+	tb := &tokenBucket{tokens: make(chan struct{}, capacity)}
+	for i := 0; i < capacity; i++ {
+		tb.tokens <- struct{}{}
+	}
 
 	go func() {
-		for {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / rate))
+		defer ticker.Stop()
+		for range ticker.C {
 			select {
-			case <-stopChan:
-				return
-			case <-ticker.C:
-				mu.Lock()
-				qps := float64(totalQueries) / time.Since(time.Now().Add(-duration)).Seconds()
-				successRate := float64(successfulQueries) / float64(totalQueries) * 100
-				avgLatency := totalLatency / time.Duration(totalQueries)
-				fmt.Printf("\r🔄 Queries: %d | QPS: %.1f | Success: %.1f%% | Avg Latency: %v",
-					totalQueries, qps, successRate, avgLatency)
-				mu.Unlock()
+			case tb.tokens <- struct{}{}:
+			default:
 			}
 		}
 	}()
+	return tb
+}
 
-	// Launch workers
-	for i := 0; i < concurrent; i++ {
+func (tb *tokenBucket) take() {
+	<-tb.tokens
+}
+
+// runLoadTest dispatches queries for duration across concurrency worker
+// goroutines, each accumulating its own workerStats, and merges them into
+// one Summary once every worker has stopped.
+func runLoadTest(server string, duration time.Duration, concurrency int, rate float64, queries []queryRecord) Summary {
+	var limiter *tokenBucket
+	if rate > 0 {
+		limiter = newTokenBucket(rate)
+	}
+
+	stopChan := make(chan struct{})
+	time.AfterFunc(duration, func() { close(stopChan) })
+
+	workers := make([]*workerStats, concurrency)
+	var liveTotal int64 // lock-free counter for the progress line only; per-worker detail is merged after wg.Wait()
+
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		ws := newWorkerStats()
+		workers[i] = ws
 		wg.Add(1)
-		go func(workerID int) {
+		go func(ws *workerStats) {
 			defer wg.Done()
 			client := &dns.Client{Timeout: 5 * time.Second}
-
-			queries := []string{
-				"ris-desktop.home", "google.com",
-				"ris-laptop.home", "github.com",
-				"homeserver.home", "stackoverflow.com",
-			}
-
 			queryIndex := 0
-
 			for {
 				select {
 				case <-stopChan:
 					return
 				default:
-					query := queries[queryIndex%len(queries)]
-					queryIndex++
-
-					msg := &dns.Msg{}
-					msg.SetQuestion(dns.Fqdn(query), dns.TypeA)
-
-					start := time.Now()
-					_, _, err := client.Exchange(msg, server)
-					latency := time.Since(start)
-
-					mu.Lock()
-					totalQueries++
-					totalLatency += latency
-					if err == nil {
-						successfulQueries++
-					}
-					mu.Unlock()
 				}
+
+				if limiter != nil {
+					limiter.take()
+				}
+
+				q := queries[queryIndex%len(queries)]
+				queryIndex++
+
+				msg := &dns.Msg{}
+				msg.SetQuestion(dns.Fqdn(q.Name), q.Qtype)
+
+				start := time.Now()
+				resp, _, err := client.Exchange(msg, server)
+				latency := time.Since(start)
+
+				ws.record(q.Qtype, resp, err, latency)
+				atomic.AddInt64(&liveTotal, 1)
 			}
-		}(i)
+		}(ws)
 	}
 
-	wg.Wait()
+	start := time.Now()
+	go reportProgress(stopChan, start, &liveTotal)
 
+	wg.Wait()
+	elapsed := time.Since(start)
 	fmt.Printf("\n✅ Load test completed!\n")
-	fmt.Printf("📊 Total Queries: %d\n", totalQueries)
-	fmt.Printf("📊 Successful: %d (%.1f%%)\n", successfulQueries,
-		float64(successfulQueries)/float64(totalQueries)*100)
-	fmt.Printf("📊 Average QPS: %.2f\n", float64(totalQueries)/duration.Seconds())
-	fmt.Printf("📊 Average Latency: %v\n", totalLatency/time.Duration(totalQueries))
+
+	return mergeStats(server, duration, concurrency, rate, workers, elapsed)
+}
+
+// reportProgress prints a live one-line progress update until stopChan
+// closes.
+func reportProgress(stopChan <-chan struct{}, start time.Time, liveTotal *int64) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stopChan:
+			return
+		case <-ticker.C:
+			total := atomic.LoadInt64(liveTotal)
+			elapsed := time.Since(start).Seconds()
+			fmt.Printf("\r🔄 Queries: %d | QPS: %.1f | Elapsed: %.0fs", total, float64(total)/elapsed, elapsed)
+		}
+	}
+}
+
+// mergeStats combines every worker's stats into the final Summary.
+func mergeStats(server string, duration time.Duration, concurrency int, rate float64, workers []*workerStats, elapsed time.Duration) Summary {
+	var total, success int64
+	var merged histogram
+	byRcode := make(map[string]int64)
+	byQtype := make(map[string]int64)
+
+	for _, ws := range workers {
+		total += ws.total
+		success += ws.success
+		ws.latency.mergeInto(&merged)
+		for k, v := range ws.byRcode {
+			byRcode[k] += v
+		}
+		for k, v := range ws.byQtype {
+			byQtype[k] += v
+		}
+	}
+
+	summary := Summary{
+		Server:            server,
+		Duration:          duration.String(),
+		Concurrency:       concurrency,
+		RateLimitQPS:      rate,
+		TotalQueries:      total,
+		SuccessfulQueries: success,
+		ByRcode:           byRcode,
+		ByQtype:           byQtype,
+		Latency: LatencyPercentiles{
+			P50Ms:  merged.percentile(50).Seconds() * 1000,
+			P90Ms:  merged.percentile(90).Seconds() * 1000,
+			P99Ms:  merged.percentile(99).Seconds() * 1000,
+			P999Ms: merged.percentile(99.9).Seconds() * 1000,
+		},
+	}
+	if elapsed > 0 {
+		summary.QPS = float64(total) / elapsed.Seconds()
+	}
+	return summary
+}
+
+// displaySummary prints the human-readable load test report.
+func displaySummary(s Summary) {
+	fmt.Println("\n📊 LOAD TEST RESULTS")
+	fmt.Println("==========================================")
+	fmt.Printf("🎯 Server: %s\n", s.Server)
+	fmt.Printf("📈 Total Queries: %d\n", s.TotalQueries)
+	if s.TotalQueries > 0 {
+		fmt.Printf("✅ Successful: %d (%.1f%%)\n", s.SuccessfulQueries, float64(s.SuccessfulQueries)/float64(s.TotalQueries)*100)
+	}
+	fmt.Printf("🚀 Average QPS: %.2f\n", s.QPS)
+
+	fmt.Println("\n⏱️  LATENCY PERCENTILES")
+	fmt.Printf("├─ p50:  %.2fms\n", s.Latency.P50Ms)
+	fmt.Printf("├─ p90:  %.2fms\n", s.Latency.P90Ms)
+	fmt.Printf("├─ p99:  %.2fms\n", s.Latency.P99Ms)
+	fmt.Printf("└─ p999: %.2fms\n", s.Latency.P999Ms)
+
+	fmt.Println("\n📋 BY RESPONSE CODE")
+	for rcode, count := range s.ByRcode {
+		fmt.Printf("├─ %s: %d\n", rcode, count)
+	}
+
+	fmt.Println("\n📋 BY QUERY TYPE")
+	for qtype, count := range s.ByQtype {
+		fmt.Printf("├─ %s: %d\n", qtype, count)
+	}
+	fmt.Println("==========================================")
+}
+
+// writeJSONSummary writes s as indented JSON to path.
+func writeJSONSummary(path string, s Summary) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
 }
@@ -0,0 +1,396 @@
+// Package blocking implements the blocking resolver stage: it loads
+// hosts-format and plain-domain blocklist/allowlist sources into a
+// compiled in-memory set and answers whether a query name should be
+// blocked, and with what kind of response.
+package blocking
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/risadams/Pocket-Concierge/internal/config"
+)
+
+// Action describes how a blocked query should be answered.
+type Action int
+
+const (
+	// ActionNXDOMAIN answers blocked queries with NXDOMAIN.
+	ActionNXDOMAIN Action = iota
+	// ActionZeroIP answers A/AAAA queries with 0.0.0.0 / ::.
+	ActionZeroIP
+	// ActionSinkhole answers A/AAAA queries with a configured sinkhole IP.
+	ActionSinkhole
+)
+
+// compiledSet is the result of loading and parsing all configured sources.
+// It is swapped into Blocker atomically so lookups never see a partially
+// built set.
+type compiledSet struct {
+	exact    map[string]struct{}
+	wildcard []string // suffixes for "*.domain" entries, without the "*."
+}
+
+// compiledBundle is the result of compiling cfg.Groups: a merged set across
+// every group (used by IsBlocked) alongside each group's own set (used by
+// IsBlockedForGroup), built from a single pass over the fetched sources.
+type compiledBundle struct {
+	merged  *compiledSet
+	byGroup map[string]*compiledSet
+}
+
+// Blocker is the blocking resolver stage. It is safe for concurrent use;
+// Reload swaps the compiled set atomically so lookups never block on it.
+type Blocker struct {
+	cfg    config.BlockingConfig
+	set    atomic.Pointer[compiledBundle]
+	allow  atomic.Pointer[compiledSet]
+	client *http.Client
+
+	blockedTotal int64
+	listEntries  int64
+
+	stopOnce sync.Once
+	stopCh   chan struct{}
+}
+
+// NewBlocker creates a Blocker and performs an initial load of all
+// configured groups and allowlist entries.
+func NewBlocker(cfg config.BlockingConfig) (*Blocker, error) {
+	b := &Blocker{
+		cfg:    cfg,
+		client: &http.Client{Timeout: 10 * time.Second},
+		stopCh: make(chan struct{}),
+	}
+
+	if err := b.Reload(); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// Action returns the configured response type for a block.
+func (b *Blocker) Action() Action {
+	switch b.cfg.BlockType {
+	case "zero_ip":
+		return ActionZeroIP
+	case "sinkhole":
+		return ActionSinkhole
+	default:
+		return ActionNXDOMAIN
+	}
+}
+
+// SinkholeIPv4 returns the configured sinkhole IPv4 address, if any.
+func (b *Blocker) SinkholeIPv4() string { return b.cfg.SinkholeIPv4 }
+
+// SinkholeIPv6 returns the configured sinkhole IPv6 address, if any.
+func (b *Blocker) SinkholeIPv6() string { return b.cfg.SinkholeIPv6 }
+
+// IsBlocked reports whether name (an exact or wildcard match against the
+// merged blocklist, i.e. every configured group) should be blocked. The
+// allowlist always takes precedence over the blocklist. Callers that need
+// to honor BlockingConfig.GroupsBlock for a specific client should use
+// IsBlockedForGroup instead.
+func (b *Blocker) IsBlocked(name string) bool {
+	normalized := strings.ToLower(strings.TrimSuffix(name, "."))
+	if normalized == "" {
+		return false
+	}
+
+	if allow := b.allow.Load(); allow != nil && matches(allow, normalized) {
+		return false
+	}
+
+	bundle := b.set.Load()
+	if bundle == nil {
+		return false
+	}
+
+	if matches(bundle.merged, normalized) {
+		atomic.AddInt64(&b.blockedTotal, 1)
+		return true
+	}
+
+	return false
+}
+
+// IsBlockedForGroup reports whether name should be blocked for a client in
+// clientGroup, consulting only the blocklist Groups that applicableGroups
+// resolves for it. The allowlist always takes precedence, as in IsBlocked.
+func (b *Blocker) IsBlockedForGroup(name, clientGroup string) bool {
+	normalized := strings.ToLower(strings.TrimSuffix(name, "."))
+	if normalized == "" {
+		return false
+	}
+
+	if allow := b.allow.Load(); allow != nil && matches(allow, normalized) {
+		return false
+	}
+
+	bundle := b.set.Load()
+	if bundle == nil {
+		return false
+	}
+
+	for _, groupName := range b.applicableGroups(clientGroup) {
+		if set, ok := bundle.byGroup[groupName]; ok && matches(set, normalized) {
+			atomic.AddInt64(&b.blockedTotal, 1)
+			return true
+		}
+	}
+
+	return false
+}
+
+// applicableGroups returns the blocklist Group names enforced for
+// clientGroup: cfg.GroupsBlock[clientGroup] if present, otherwise
+// cfg.GroupsBlock["default"], otherwise (when GroupsBlock is empty
+// entirely) every configured group, so callers that don't use client
+// groups at all see the same behavior as IsBlocked.
+func (b *Blocker) applicableGroups(clientGroup string) []string {
+	if len(b.cfg.GroupsBlock) == 0 {
+		names := make([]string, len(b.cfg.Groups))
+		for i, group := range b.cfg.Groups {
+			names[i] = group.Name
+		}
+		return names
+	}
+
+	if names, ok := b.cfg.GroupsBlock[clientGroup]; ok {
+		return names
+	}
+	return b.cfg.GroupsBlock["default"]
+}
+
+// ClientGroupFor returns the client group addr belongs to, per
+// cfg.ClientGroups: an exact IP match, then a CIDR match, falling back to
+// "default" when addr matches nothing (or is nil/unparsable).
+func (b *Blocker) ClientGroupFor(addr net.Addr) string {
+	if addr == nil {
+		return "default"
+	}
+
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		host = addr.String()
+	}
+
+	if group, ok := b.cfg.ClientGroups[host]; ok {
+		return group
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return "default"
+	}
+
+	for cidr, group := range b.cfg.ClientGroups {
+		if _, network, err := net.ParseCIDR(cidr); err == nil && network.Contains(ip) {
+			return group
+		}
+	}
+
+	return "default"
+}
+
+// matches reports whether name is an exact or wildcard-suffix match within set.
+func matches(set *compiledSet, name string) bool {
+	if _, ok := set.exact[name]; ok {
+		return true
+	}
+	for _, suffix := range set.wildcard {
+		if name == suffix || strings.HasSuffix(name, "."+suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// BlockedTotal returns the running count of blocked lookups.
+func (b *Blocker) BlockedTotal() int64 { return atomic.LoadInt64(&b.blockedTotal) }
+
+// ListEntries returns the number of entries currently compiled into the
+// blocklist (not counting the allowlist).
+func (b *Blocker) ListEntries() int64 { return atomic.LoadInt64(&b.listEntries) }
+
+// Reload fetches and parses every configured group and atomically swaps in
+// the newly compiled blocklist and allowlist.
+func (b *Blocker) Reload() error {
+	bundle, entries, err := b.compileBundle(b.cfg.Groups)
+	if err != nil {
+		return fmt.Errorf("failed to load blocking groups: %w", err)
+	}
+
+	allowSet, _, err := b.compile(b.cfg.Allowlist)
+	if err != nil {
+		return fmt.Errorf("failed to load allowlist groups: %w", err)
+	}
+
+	b.set.Store(bundle)
+	b.allow.Store(allowSet)
+	atomic.StoreInt64(&b.listEntries, int64(entries))
+
+	return nil
+}
+
+func (b *Blocker) compile(groups []config.BlockListGroup) (*compiledSet, int, error) {
+	set := &compiledSet{exact: make(map[string]struct{})}
+	entries := 0
+
+	for _, group := range groups {
+		for _, source := range group.Sources {
+			data, err := b.fetch(source)
+			if err != nil {
+				return nil, 0, fmt.Errorf("group %q: %w", group.Name, err)
+			}
+
+			names := parseList(data, group.Format)
+			for _, name := range names {
+				entries++
+				if strings.HasPrefix(name, "*.") {
+					set.wildcard = append(set.wildcard, strings.TrimPrefix(name, "*."))
+				} else {
+					set.exact[name] = struct{}{}
+				}
+			}
+		}
+	}
+
+	return set, entries, nil
+}
+
+// compileBundle fetches and parses groups exactly like compile, but keeps
+// each group's own compiledSet alongside the merged one, so IsBlockedForGroup
+// can consult a single group without re-fetching its sources.
+func (b *Blocker) compileBundle(groups []config.BlockListGroup) (*compiledBundle, int, error) {
+	bundle := &compiledBundle{
+		merged:  &compiledSet{exact: make(map[string]struct{})},
+		byGroup: make(map[string]*compiledSet, len(groups)),
+	}
+	entries := 0
+
+	for _, group := range groups {
+		groupSet := &compiledSet{exact: make(map[string]struct{})}
+
+		for _, source := range group.Sources {
+			data, err := b.fetch(source)
+			if err != nil {
+				return nil, 0, fmt.Errorf("group %q: %w", group.Name, err)
+			}
+
+			for _, name := range parseList(data, group.Format) {
+				entries++
+				if strings.HasPrefix(name, "*.") {
+					suffix := strings.TrimPrefix(name, "*.")
+					groupSet.wildcard = append(groupSet.wildcard, suffix)
+					bundle.merged.wildcard = append(bundle.merged.wildcard, suffix)
+				} else {
+					groupSet.exact[name] = struct{}{}
+					bundle.merged.exact[name] = struct{}{}
+				}
+			}
+		}
+
+		bundle.byGroup[group.Name] = groupSet
+	}
+
+	return bundle, entries, nil
+}
+
+// fetch reads a source, which may be an http(s) URL or a local file path.
+func (b *Blocker) fetch(source string) ([]byte, error) {
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		resp, err := b.client.Get(source)
+		if err != nil {
+			return nil, fmt.Errorf("fetch %s: %w", source, err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("fetch %s: HTTP %d", source, resp.StatusCode)
+		}
+
+		return io.ReadAll(resp.Body)
+	}
+
+	return os.ReadFile(source)
+}
+
+// parseList parses raw blocklist data in either "hosts" (e.g.
+// "0.0.0.0 domain" / "127.0.0.1 domain") or "domains" (one domain per line)
+// format, skipping blank lines and "#" comments.
+func parseList(data []byte, format string) []string {
+	var names []string
+
+	scanner := bufio.NewScanner(strings.NewReader(string(data)))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if idx := strings.Index(line, "#"); idx >= 0 {
+			line = strings.TrimSpace(line[:idx])
+		}
+
+		name := line
+		if format == "hosts" {
+			fields := strings.Fields(line)
+			if len(fields) < 2 {
+				continue
+			}
+			ip := fields[0]
+			if ip != "0.0.0.0" && ip != "127.0.0.1" && ip != "::" && ip != "::1" {
+				continue
+			}
+			name = fields[1]
+		}
+
+		name = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(name), "."))
+		if name == "" || name == "localhost" {
+			continue
+		}
+
+		names = append(names, name)
+	}
+
+	return names
+}
+
+// StartRefresh begins a background goroutine that calls Reload on the
+// configured interval. It is a no-op if RefreshInterval is 0. Stop must be
+// called to release the goroutine.
+func (b *Blocker) StartRefresh() {
+	if b.cfg.RefreshInterval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(time.Duration(b.cfg.RefreshInterval) * time.Second)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				_ = b.Reload() // best-effort; keep serving the last good set on error
+			case <-b.stopCh:
+				return
+			}
+		}
+	}()
+}
+
+// Stop terminates the background refresh goroutine, if running.
+func (b *Blocker) Stop() {
+	b.stopOnce.Do(func() { close(b.stopCh) })
+}
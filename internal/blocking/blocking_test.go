@@ -0,0 +1,234 @@
+package blocking
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/risadams/Pocket-Concierge/internal/config"
+)
+
+func writeList(t *testing.T, dir, name, contents string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatalf("failed to write list %s: %v", name, err)
+	}
+	return path
+}
+
+func TestBlockerHostsFormatParsing(t *testing.T) {
+	dir := t.TempDir()
+	path := writeList(t, dir, "hosts.txt", `# comment line
+0.0.0.0 ads.example.com
+127.0.0.1 tracker.example.com
+0.0.0.0 localhost
+
+not-a-valid-line
+1.2.3.4 ignored.example.com
+`)
+
+	b, err := NewBlocker(config.BlockingConfig{
+		Groups: []config.BlockListGroup{
+			{Name: "test", Sources: []string{path}, Format: "hosts"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewBlocker failed: %v", err)
+	}
+
+	if !b.IsBlocked("ads.example.com") {
+		t.Error("expected ads.example.com to be blocked")
+	}
+	if !b.IsBlocked("tracker.example.com") {
+		t.Error("expected tracker.example.com to be blocked")
+	}
+	if b.IsBlocked("ignored.example.com") {
+		t.Error("did not expect ignored.example.com (non-block IP) to be blocked")
+	}
+	if b.IsBlocked("localhost") {
+		t.Error("did not expect localhost to be blocked")
+	}
+	if b.ListEntries() != 2 {
+		t.Errorf("expected 2 list entries, got %d", b.ListEntries())
+	}
+}
+
+func TestBlockerDomainFormatAndWildcard(t *testing.T) {
+	dir := t.TempDir()
+	path := writeList(t, dir, "domains.txt", `evil.net
+*.doubleclick.net
+`)
+
+	b, err := NewBlocker(config.BlockingConfig{
+		Groups: []config.BlockListGroup{
+			{Name: "test", Sources: []string{path}, Format: "domains"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewBlocker failed: %v", err)
+	}
+
+	if !b.IsBlocked("evil.net") {
+		t.Error("expected exact domain match to be blocked")
+	}
+	if !b.IsBlocked("sub.doubleclick.net") {
+		t.Error("expected wildcard subdomain to be blocked")
+	}
+	if !b.IsBlocked("doubleclick.net") {
+		t.Error("expected wildcard base domain to be blocked")
+	}
+	if b.IsBlocked("notdoubleclick.net") {
+		t.Error("did not expect lookalike domain to be blocked")
+	}
+}
+
+func TestBlockerAllowlistPrecedence(t *testing.T) {
+	dir := t.TempDir()
+	blockPath := writeList(t, dir, "block.txt", "*.ads.example.com\n")
+	allowPath := writeList(t, dir, "allow.txt", "safe.ads.example.com\n")
+
+	b, err := NewBlocker(config.BlockingConfig{
+		Groups:    []config.BlockListGroup{{Name: "block", Sources: []string{blockPath}, Format: "domains"}},
+		Allowlist: []config.BlockListGroup{{Name: "allow", Sources: []string{allowPath}, Format: "domains"}},
+	})
+	if err != nil {
+		t.Fatalf("NewBlocker failed: %v", err)
+	}
+
+	if b.IsBlocked("safe.ads.example.com") {
+		t.Error("expected allowlisted subdomain to take precedence over blocklist")
+	}
+	if !b.IsBlocked("other.ads.example.com") {
+		t.Error("expected non-allowlisted subdomain to still be blocked")
+	}
+}
+
+func TestBlockerReload(t *testing.T) {
+	dir := t.TempDir()
+	path := writeList(t, dir, "domains.txt", "first.example.com\n")
+
+	b, err := NewBlocker(config.BlockingConfig{
+		Groups: []config.BlockListGroup{{Name: "test", Sources: []string{path}, Format: "domains"}},
+	})
+	if err != nil {
+		t.Fatalf("NewBlocker failed: %v", err)
+	}
+
+	if b.IsBlocked("second.example.com") {
+		t.Fatal("second.example.com should not be blocked before reload")
+	}
+
+	writeList(t, dir, "domains.txt", "first.example.com\nsecond.example.com\n")
+
+	if err := b.Reload(); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+
+	if !b.IsBlocked("second.example.com") {
+		t.Error("expected second.example.com to be blocked after reload")
+	}
+}
+
+func TestBlockerActionsAndCounters(t *testing.T) {
+	b, err := NewBlocker(config.BlockingConfig{BlockType: "sinkhole", SinkholeIPv4: "10.10.10.10"})
+	if err != nil {
+		t.Fatalf("NewBlocker failed: %v", err)
+	}
+
+	if b.Action() != ActionSinkhole {
+		t.Errorf("expected ActionSinkhole, got %v", b.Action())
+	}
+	if b.SinkholeIPv4() != "10.10.10.10" {
+		t.Errorf("expected configured sinkhole IP, got %s", b.SinkholeIPv4())
+	}
+	if b.BlockedTotal() != 0 {
+		t.Errorf("expected zero blocked count for empty blocker, got %d", b.BlockedTotal())
+	}
+}
+
+func TestBlockerIsBlockedForGroupRestrictsToEnforcedGroups(t *testing.T) {
+	dir := t.TempDir()
+	adsPath := writeList(t, dir, "ads.txt", "ads.example.com\n")
+	socialPath := writeList(t, dir, "social.txt", "social.example.com\n")
+
+	b, err := NewBlocker(config.BlockingConfig{
+		Groups: []config.BlockListGroup{
+			{Name: "ads", Sources: []string{adsPath}, Format: "domains"},
+			{Name: "social", Sources: []string{socialPath}, Format: "domains"},
+		},
+		GroupsBlock: map[string][]string{
+			"kids":    {"ads", "social"},
+			"default": {"ads"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewBlocker failed: %v", err)
+	}
+
+	if !b.IsBlockedForGroup("ads.example.com", "default") {
+		t.Error("expected ads domain to be blocked for the default group")
+	}
+	if b.IsBlockedForGroup("social.example.com", "default") {
+		t.Error("did not expect social domain to be blocked for the default group")
+	}
+	if !b.IsBlockedForGroup("social.example.com", "kids") {
+		t.Error("expected social domain to be blocked for the kids group")
+	}
+
+	// IsBlocked ignores GroupsBlock entirely and still sees every group.
+	if !b.IsBlocked("social.example.com") {
+		t.Error("expected IsBlocked to match across all groups regardless of GroupsBlock")
+	}
+}
+
+func TestBlockerIsBlockedForGroupWithoutGroupsBlockAppliesEveryGroup(t *testing.T) {
+	dir := t.TempDir()
+	path := writeList(t, dir, "domains.txt", "ads.example.com\n")
+
+	b, err := NewBlocker(config.BlockingConfig{
+		Groups: []config.BlockListGroup{{Name: "ads", Sources: []string{path}, Format: "domains"}},
+	})
+	if err != nil {
+		t.Fatalf("NewBlocker failed: %v", err)
+	}
+
+	if !b.IsBlockedForGroup("ads.example.com", "anything") {
+		t.Error("expected every group to apply when GroupsBlock is unconfigured")
+	}
+}
+
+func TestBlockerClientGroupFor(t *testing.T) {
+	b, err := NewBlocker(config.BlockingConfig{
+		ClientGroups: map[string]string{
+			"192.168.1.50": "kids",
+			"10.0.0.0/8":   "trusted",
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewBlocker failed: %v", err)
+	}
+
+	cases := []struct {
+		addr string
+		want string
+	}{
+		{"192.168.1.50:12345", "kids"},
+		{"10.1.2.3:53", "trusted"},
+		{"203.0.113.9:53", "default"},
+	}
+	for _, tc := range cases {
+		addr, err := net.ResolveUDPAddr("udp", tc.addr)
+		if err != nil {
+			t.Fatalf("ResolveUDPAddr(%s) failed: %v", tc.addr, err)
+		}
+		if got := b.ClientGroupFor(addr); got != tc.want {
+			t.Errorf("ClientGroupFor(%s) = %q, want %q", tc.addr, got, tc.want)
+		}
+	}
+
+	if got := b.ClientGroupFor(nil); got != "default" {
+		t.Errorf("ClientGroupFor(nil) = %q, want default", got)
+	}
+}
@@ -0,0 +1,202 @@
+// Package bootstrap resolves hostname-form upstream addresses (e.g. DoH/DoT
+// endpoints configured as names rather than IPs) using a small set of
+// plain-DNS resolvers, so the secure upstream client never has to depend on
+// the system resolver or on itself to get started.
+package bootstrap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/risadams/Pocket-Concierge/internal/config"
+)
+
+const defaultCacheTTL = 300 * time.Second
+
+// defaultBootstrapDNS is consulted when no BootstrapConfig.Resolvers are
+// configured, so a fresh install never falls back to the system resolver to
+// resolve a hostname-form upstream — on a machine using Pocket-Concierge
+// itself for DNS, that resolver may well be Pocket-Concierge, creating a
+// chicken-and-egg loop.
+const defaultBootstrapDNS = "8.8.8.8:53"
+
+// ErrResolution identifies a failure to bootstrap-resolve an upstream
+// hostname, as distinct from a failure querying the upstream itself once
+// resolved. Callers use errors.Is against this to avoid counting a
+// resolution failure against an upstream's health score.
+var ErrResolution = errors.New("bootstrap: resolution failed")
+
+// cacheEntry holds a resolved IP and the time it should be considered stale.
+type cacheEntry struct {
+	ip        string
+	expiresAt time.Time
+}
+
+// Resolver resolves upstream hostnames to IPs using a plain DNS resolver,
+// with an optional static hosts override and a TTL cache so repeated
+// connects don't re-resolve on every query. Only the first entry of
+// cfg.Resolvers (or defaultBootstrapDNS when it is empty) is queried; later
+// entries are not consulted.
+type Resolver struct {
+	cfg           config.BootstrapConfig
+	ttl           time.Duration
+	bootstrapAddr string
+	resolver      *net.Resolver
+
+	mu    sync.Mutex
+	cache map[string]cacheEntry
+
+	stopOnce sync.Once
+	stopCh   chan struct{}
+}
+
+// NewResolver creates a bootstrap Resolver from cfg. It is safe to call
+// with a zero-value BootstrapConfig: Resolve will then consult the static
+// hosts map (if any), then defaultBootstrapDNS, never the system resolver.
+func NewResolver(cfg config.BootstrapConfig) *Resolver {
+	ttl := defaultCacheTTL
+	if cfg.CacheTTL > 0 {
+		ttl = time.Duration(cfg.CacheTTL) * time.Second
+	}
+
+	r := &Resolver{
+		cfg:           cfg,
+		ttl:           ttl,
+		bootstrapAddr: defaultBootstrapDNS,
+		cache:         make(map[string]cacheEntry),
+		stopCh:        make(chan struct{}),
+	}
+	if len(cfg.Resolvers) > 0 {
+		r.bootstrapAddr = cfg.Resolvers[0]
+	}
+
+	r.resolver = &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			d := net.Dialer{Timeout: 3 * time.Second}
+			return d.DialContext(ctx, network, r.bootstrapAddr)
+		},
+	}
+
+	return r
+}
+
+// Resolve returns an IP address for hostname: a static override if
+// configured, otherwise the cached or freshly bootstrap-resolved IP. If
+// hostname is already an IP it is returned unchanged.
+func (r *Resolver) Resolve(ctx context.Context, hostname string) (string, error) {
+	if ip := net.ParseIP(hostname); ip != nil {
+		return hostname, nil
+	}
+
+	if ip, ok := r.cfg.StaticHosts[hostname]; ok {
+		return ip, nil
+	}
+
+	r.mu.Lock()
+	if entry, ok := r.cache[hostname]; ok && time.Now().Before(entry.expiresAt) {
+		r.mu.Unlock()
+		return entry.ip, nil
+	}
+	r.mu.Unlock()
+
+	addrs, err := r.resolver.LookupHost(ctx, hostname)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s: %v", ErrResolution, hostname, err)
+	}
+	if len(addrs) == 0 {
+		return "", fmt.Errorf("%w: no addresses found for %s", ErrResolution, hostname)
+	}
+
+	r.mu.Lock()
+	r.cache[hostname] = cacheEntry{ip: addrs[0], expiresAt: time.Now().Add(r.ttl)}
+	r.mu.Unlock()
+
+	return addrs[0], nil
+}
+
+// Invalidate purges any cached resolution for hostname, forcing the next
+// Resolve call to bootstrap again rather than wait out the TTL. Callers use
+// this after a connection to the cached IP fails, on the chance it went
+// stale before expiring naturally.
+func (r *Resolver) Invalidate(hostname string) {
+	r.mu.Lock()
+	delete(r.cache, hostname)
+	r.mu.Unlock()
+}
+
+// StartRefresh begins a background goroutine that re-resolves every
+// currently cached hostname on the configured interval, so a cache entry is
+// refreshed before it goes stale rather than forcing the next query to
+// bootstrap-resolve inline. It is a no-op if RefreshInterval is 0. Stop
+// must be called to release the goroutine.
+func (r *Resolver) StartRefresh() {
+	if r.cfg.RefreshInterval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(time.Duration(r.cfg.RefreshInterval) * time.Second)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				r.refreshCached()
+			case <-r.stopCh:
+				return
+			}
+		}
+	}()
+}
+
+// refreshCached re-resolves every hostname currently in the cache,
+// replacing its entry on success and leaving it untouched (to expire
+// naturally) on failure.
+func (r *Resolver) refreshCached() {
+	r.mu.Lock()
+	hostnames := make([]string, 0, len(r.cache))
+	for hostname := range r.cache {
+		hostnames = append(hostnames, hostname)
+	}
+	r.mu.Unlock()
+
+	for _, hostname := range hostnames {
+		addrs, err := r.resolver.LookupHost(context.Background(), hostname)
+		if err != nil || len(addrs) == 0 {
+			continue // best-effort; keep serving the last good entry until it expires
+		}
+
+		r.mu.Lock()
+		r.cache[hostname] = cacheEntry{ip: addrs[0], expiresAt: time.Now().Add(r.ttl)}
+		r.mu.Unlock()
+	}
+}
+
+// Stop terminates the background refresh goroutine, if running.
+func (r *Resolver) Stop() {
+	r.stopOnce.Do(func() { close(r.stopCh) })
+}
+
+// DialContext dials address ("host:port"), resolving a hostname host
+// through Resolve first. It is suitable for use as http.Transport.DialContext
+// or a dns.Client.Dialer so upstream connections never depend on the
+// system resolver for hostname-form upstreams.
+func (r *Resolver) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+
+	ip, err := r.Resolve(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	d := net.Dialer{Timeout: 5 * time.Second}
+	return d.DialContext(ctx, network, net.JoinHostPort(ip, port))
+}
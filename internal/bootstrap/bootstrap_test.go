@@ -0,0 +1,144 @@
+package bootstrap
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/risadams/Pocket-Concierge/internal/config"
+)
+
+func TestResolverReturnsIPUnchanged(t *testing.T) {
+	r := NewResolver(config.BootstrapConfig{})
+
+	ip, err := r.Resolve(context.Background(), "1.1.1.1")
+	if err != nil {
+		t.Fatalf("Resolve failed: %v", err)
+	}
+	if ip != "1.1.1.1" {
+		t.Errorf("expected unchanged IP, got %s", ip)
+	}
+}
+
+func TestResolverUsesStaticHosts(t *testing.T) {
+	r := NewResolver(config.BootstrapConfig{
+		StaticHosts: map[string]string{"dns.example.com": "10.0.0.1"},
+	})
+
+	ip, err := r.Resolve(context.Background(), "dns.example.com")
+	if err != nil {
+		t.Fatalf("Resolve failed: %v", err)
+	}
+	if ip != "10.0.0.1" {
+		t.Errorf("expected static host IP 10.0.0.1, got %s", ip)
+	}
+}
+
+func TestResolverCachesResultWithTTL(t *testing.T) {
+	r := NewResolver(config.BootstrapConfig{
+		StaticHosts: map[string]string{"dns.example.com": "10.0.0.1"},
+		CacheTTL:    1,
+	})
+
+	if _, err := r.Resolve(context.Background(), "dns.example.com"); err != nil {
+		t.Fatalf("Resolve failed: %v", err)
+	}
+
+	// Static hosts are consulted before the cache, so seed the cache
+	// directly to verify its TTL independent of the static hosts path.
+	r.mu.Lock()
+	r.cache["cached.example.com"] = cacheEntry{ip: "10.0.0.2", expiresAt: time.Now().Add(time.Hour)}
+	r.mu.Unlock()
+
+	ip, err := r.Resolve(context.Background(), "cached.example.com")
+	if err != nil {
+		t.Fatalf("Resolve failed: %v", err)
+	}
+	if ip != "10.0.0.2" {
+		t.Errorf("expected cached IP 10.0.0.2, got %s", ip)
+	}
+
+	r.mu.Lock()
+	r.cache["expired.example.com"] = cacheEntry{ip: "10.0.0.3", expiresAt: time.Now().Add(-time.Second)}
+	r.mu.Unlock()
+
+	// An expired entry with no resolvers configured falls through to
+	// defaultBootstrapDNS, which will fail to resolve this made-up name.
+	if _, err := r.Resolve(context.Background(), "expired.example.com"); err == nil {
+		t.Error("expected expired cache entry to trigger a fresh lookup (and fail for a fake hostname)")
+	}
+}
+
+func TestResolverInvalidatePurgesCacheEntry(t *testing.T) {
+	r := NewResolver(config.BootstrapConfig{CacheTTL: 3600})
+
+	r.mu.Lock()
+	r.cache["stale.example.com"] = cacheEntry{ip: "10.0.0.9", expiresAt: time.Now().Add(time.Hour)}
+	r.mu.Unlock()
+
+	r.Invalidate("stale.example.com")
+
+	r.mu.Lock()
+	_, found := r.cache["stale.example.com"]
+	r.mu.Unlock()
+	if found {
+		t.Error("expected Invalidate to purge the cache entry despite it not being expired")
+	}
+}
+
+func TestStartRefreshIsNoOpWithoutInterval(t *testing.T) {
+	r := NewResolver(config.BootstrapConfig{})
+	r.StartRefresh() // should not start a goroutine, and must not panic
+	r.Stop()
+}
+
+func TestRefreshCachedKeepsEntryOnFailedLookup(t *testing.T) {
+	r := NewResolver(config.BootstrapConfig{CacheTTL: 3600, RefreshInterval: 1})
+
+	r.mu.Lock()
+	r.cache["1.1.1.1.example.invalid"] = cacheEntry{ip: "1.1.1.1", expiresAt: time.Now().Add(-time.Second)}
+	r.mu.Unlock()
+
+	r.refreshCached()
+
+	// The hostname itself doesn't resolve (it's made up), so the stale
+	// entry should be left untouched rather than replaced or removed.
+	r.mu.Lock()
+	entry, ok := r.cache["1.1.1.1.example.invalid"]
+	r.mu.Unlock()
+	if !ok {
+		t.Fatal("expected refreshCached to leave a failed lookup's entry in place")
+	}
+	if entry.ip != "1.1.1.1" {
+		t.Errorf("expected unchanged cached IP, got %s", entry.ip)
+	}
+}
+
+func TestNewResolverDefaultsToPublicBootstrapDNS(t *testing.T) {
+	r := NewResolver(config.BootstrapConfig{})
+
+	if r.bootstrapAddr != defaultBootstrapDNS {
+		t.Errorf("expected default bootstrap address %s, got %s", defaultBootstrapDNS, r.bootstrapAddr)
+	}
+}
+
+func TestNewResolverUsesConfiguredResolverOverDefault(t *testing.T) {
+	r := NewResolver(config.BootstrapConfig{Resolvers: []string{"10.0.0.53:53"}})
+
+	if r.bootstrapAddr != "10.0.0.53:53" {
+		t.Errorf("expected configured resolver to take precedence over the default, got %s", r.bootstrapAddr)
+	}
+}
+
+func TestResolverLookupFailureWrapsErrResolution(t *testing.T) {
+	r := NewResolver(config.BootstrapConfig{})
+
+	_, err := r.Resolve(context.Background(), "this-hostname-should-not-resolve.invalid")
+	if err == nil {
+		t.Fatal("expected an error resolving a made-up hostname")
+	}
+	if !errors.Is(err, ErrResolution) {
+		t.Errorf("expected error to wrap ErrResolution, got %v", err)
+	}
+}
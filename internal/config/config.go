@@ -1,226 +1,865 @@
-package config
-
-import (
-	"fmt"
-	"net"
-	"os"
-	"path/filepath"
-
-	"gopkg.in/yaml.v3"
-)
-
-// Config represents the complete PocketConcierge configuration
-type Config struct {
-	Server   ServerConfig     `yaml:"server"`
-	DNS      DNSConfig        `yaml:"dns"`
-	Hosts    []HostEntry      `yaml:"hosts"`
-	Upstream []UpstreamServer `yaml:"upstream"`
-	LogLevel string           `yaml:"log_level"`
-}
-
-// ServerConfig defines server-specific settings
-type ServerConfig struct {
-	Port    int    `yaml:"port"`
-	Address string `yaml:"address"`
-}
-
-// DNSConfig defines DNS-specific settings
-type DNSConfig struct {
-	TTL             int  `yaml:"ttl"`
-	EnableRecursion bool `yaml:"enable_recursion"`
-	CacheSize       int  `yaml:"cache_size"`
-}
-
-type UpstreamServer struct {
-	Name     string `yaml:"name,omitempty"` // Optional friendly name
-	Address  string `yaml:"address"`        // Server address
-	Protocol string `yaml:"protocol"`       // "udp", "tcp", "tls", "https", "quic"
-	Port     int    `yaml:"port,omitempty"` // Optional custom port
-	Path     string `yaml:"path,omitempty"` // For DoH: /dns-query
-	Verify   bool   `yaml:"verify"`         // TLS certificate verification
-}
-
-// HostEntry represents a hostname to IP mapping
-type HostEntry struct {
-	Hostname string   `yaml:"hostname"`
-	IPv4     []string `yaml:"ipv4,omitempty"`
-	IPv6     []string `yaml:"ipv6,omitempty"`
-}
-
-// DefaultConfig returns a sensible default configuration
-func DefaultConfig() *Config {
-	return &Config{
-		Server: ServerConfig{
-			Port:    53,
-			Address: "0.0.0.0",
-		},
-		DNS: DNSConfig{
-			TTL:             300, // 5 minutes
-			EnableRecursion: true,
-			CacheSize:       1000,
-		},
-		Upstream: []UpstreamServer{
-			{
-				Name:     "Cloudflare DoH",
-				Address:  "1.1.1.1",
-				Protocol: "https",
-				Path:     "/dns-query",
-				Verify:   true,
-			},
-			{
-				Name:     "Google DoT",
-				Address:  "8.8.8.8",
-				Protocol: "tls",
-				Port:     853,
-				Verify:   true,
-			},
-		},
-		LogLevel: "info",
-		Hosts:    []HostEntry{},
-	}
-}
-
-// LoadConfig reads configuration from a YAML file
-func LoadConfig(filename string) (*Config, error) {
-	// Start with defaults
-	config := DefaultConfig()
-
-	// Check if file exists
-	if _, err := os.Stat(filename); os.IsNotExist(err) {
-		return config, fmt.Errorf("config file not found: %s", filename)
-	}
-
-	// Read file
-	data, err := os.ReadFile(filename)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read config file: %w", err)
-	}
-
-	// Parse YAML
-	if err := yaml.Unmarshal(data, config); err != nil {
-		return nil, fmt.Errorf("failed to parse config file: %w", err)
-	}
-
-	// Validate configuration
-	if err := config.Validate(); err != nil {
-		return nil, fmt.Errorf("invalid configuration: %w", err)
-	}
-
-	return config, nil
-}
-
-// SaveConfig writes configuration to a YAML file
-func (c *Config) SaveConfig(filename string) error {
-	// Create directory if it doesn't exist
-	dir := filepath.Dir(filename)
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		return fmt.Errorf("failed to create config directory: %w", err)
-	}
-
-	// Marshal to YAML
-	data, err := yaml.Marshal(c)
-	if err != nil {
-		return fmt.Errorf("failed to marshal config: %w", err)
-	}
-
-	// Write file
-	if err := os.WriteFile(filename, data, 0644); err != nil {
-		return fmt.Errorf("failed to write config file: %w", err)
-	}
-
-	return nil
-}
-
-// Validate checks if the configuration is valid
-func (c *Config) Validate() error {
-	// Validate server settings
-	if c.Server.Port < 1 || c.Server.Port > 65535 {
-		return fmt.Errorf("invalid port: %d (must be 1-65535)", c.Server.Port)
-	}
-
-	if net.ParseIP(c.Server.Address) == nil && c.Server.Address != "0.0.0.0" {
-		return fmt.Errorf("invalid server address: %s", c.Server.Address)
-	}
-
-	// Validate upstream servers
-	for i := range c.Upstream { // Use range with index to modify in place
-		upstream := &c.Upstream[i] // Get pointer to modify original
-
-		if upstream.Address == "" {
-			return fmt.Errorf("upstream server %d: address cannot be empty", i)
-		}
-
-		// Validate protocol
-		validProtocols := map[string]bool{
-			"udp": true, "tcp": true, "tls": true, "https": true, "quic": true,
-		}
-		if !validProtocols[upstream.Protocol] {
-			return fmt.Errorf("upstream server %d: invalid protocol '%s' (must be udp, tcp, tls, https, or quic)", i, upstream.Protocol)
-		}
-
-		// Set default ports if not specified
-		if upstream.Port == 0 {
-			switch upstream.Protocol {
-			case "udp", "tcp":
-				upstream.Port = 53
-			case "tls":
-				upstream.Port = 853
-			case "https":
-				upstream.Port = 443 // This is the key fix!
-			case "quic":
-				upstream.Port = 853
-			}
-		}
-
-		// Set default HTTPS path
-		if upstream.Protocol == "https" && upstream.Path == "" {
-			upstream.Path = "/dns-query"
-		}
-	}
-
-	// Validate host entries
-	for i, host := range c.Hosts {
-		if host.Hostname == "" {
-			return fmt.Errorf("host entry %d: hostname cannot be empty", i)
-		}
-
-		// Validate IPv4 addresses
-		for _, ip := range host.IPv4 {
-			if net.ParseIP(ip) == nil {
-				return fmt.Errorf("host entry %s: invalid IPv4 address: %s", host.Hostname, ip)
-			}
-		}
-
-		// Validate IPv6 addresses
-		for _, ip := range host.IPv6 {
-			if net.ParseIP(ip) == nil {
-				return fmt.Errorf("host entry %s: invalid IPv6 address: %s", host.Hostname, ip)
-			}
-		}
-
-		// Must have at least one IP
-		if len(host.IPv4) == 0 && len(host.IPv6) == 0 {
-			return fmt.Errorf("host entry %s: must have at least one IP address", host.Hostname)
-		}
-	}
-
-	// Validate log level
-	validLevels := map[string]bool{
-		"debug": true, "info": true, "warn": true, "error": true,
-	}
-	if !validLevels[c.LogLevel] {
-		return fmt.Errorf("invalid log level: %s (must be debug, info, warn, or error)", c.LogLevel)
-	}
-
-	return nil
-}
-
-// GetHostByName returns the HostEntry for a given hostname
-func (c *Config) GetHostByName(hostname string) (*HostEntry, bool) {
-	for _, host := range c.Hosts {
-		if host.Hostname == hostname {
-			return &host, true
-		}
-	}
-	return nil, false
-}
+package config
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config represents the complete PocketConcierge configuration
+type Config struct {
+	Server               ServerConfig                `yaml:"server"`
+	DNS                  DNSConfig                   `yaml:"dns"`
+	Hosts                []HostEntry                 `yaml:"hosts"`
+	HostsFiles           []string                    `yaml:"hosts_files,omitempty"`            // hosts(5)-format files merged into the host cache
+	HostsURLs            []string                    `yaml:"hosts_urls,omitempty"`             // hosts(5)-format URLs merged into the host cache, refetched every HostsRefreshInterval
+	HostsRefreshInterval int                         `yaml:"hosts_refresh_interval,omitempty"` // seconds between HostsURLs refetches, defaults to 3600
+	Upstream             []UpstreamServer            `yaml:"upstream"`
+	Conditional          map[string][]UpstreamServer `yaml:"conditional,omitempty"`
+	Blocking             BlockingConfig              `yaml:"blocking,omitempty"`
+	Caching              CachingConfig               `yaml:"caching,omitempty"`
+	Encrypted            EncryptedListeners          `yaml:"encrypted,omitempty"`
+	DDR                  DDRConfig                   `yaml:"ddr,omitempty"`
+	Bootstrap            BootstrapConfig             `yaml:"bootstrap,omitempty"` // plain IP:port resolvers for bootstrap-resolving hostname-form DoT/DoH/DoQ upstreams
+	UpstreamHealthcheck  UpstreamHealthcheckConfig   `yaml:"upstream_healthcheck,omitempty"`
+	QueryLog             QueryLogConfig              `yaml:"query_log,omitempty"`
+	Filter               FilterConfig                `yaml:"filter,omitempty"`
+	LogLevel             string                      `yaml:"log_level"`
+	HomeDNSDomain        string                      `yaml:"home_dns_domain"`
+	ReverseZones         []string                    `yaml:"reverse_zones,omitempty"` // in-addr.arpa./ip6.arpa. zones this server is authoritative for
+}
+
+// FilterConfig configures the response-filtering stage: rule lists to load
+// and how a match is answered. Unlike BlockingConfig, filter rules are also
+// checked against the answer section of upstream responses, so CNAME chains
+// to blocked domains are caught, not just the question name.
+type FilterConfig struct {
+	Lists        []FilterListSource `yaml:"lists,omitempty"`
+	Action       string             `yaml:"action,omitempty"` // "nxdomain" or "sinkhole"
+	SinkholeIPv4 string             `yaml:"sinkhole_ipv4,omitempty"`
+	SinkholeIPv6 string             `yaml:"sinkhole_ipv6,omitempty"`
+}
+
+// FilterListSource is a named, individually toggleable rule list.
+type FilterListSource struct {
+	Name    string `yaml:"name"`
+	URL     string `yaml:"url"` // file path or http(s) URL
+	Enabled bool   `yaml:"enabled"`
+}
+
+// QueryLogConfig configures the query log subsystem: which sinks record
+// completed queries and how each is tuned.
+type QueryLogConfig struct {
+	Sinks                []string `yaml:"sinks,omitempty"` // any of "file", "memory", "prometheus"
+	FilePath             string   `yaml:"file_path,omitempty"`
+	MaxSizeMB            int      `yaml:"max_size_mb,omitempty"`            // rotate the file sink at this size, default 100
+	MaxAgeHours          int      `yaml:"max_age_hours,omitempty"`          // rotate the file sink once its current file is this old, 0 disables
+	RetentionDays        int      `yaml:"retention_days,omitempty"`         // prune rotated files older than this, 0 disables
+	RingBufferSize       int      `yaml:"ring_buffer_size,omitempty"`       // memory sink capacity, default 1000
+	AnonymizeClients     bool     `yaml:"anonymize_clients,omitempty"`      // drop last octet (IPv4) / 80 bits (IPv6) of client IPs
+	MetricsPort          int      `yaml:"metrics_port,omitempty"`           // serve the "prometheus" sink at /metrics on this port, 0 disables
+	StatsPort            int      `yaml:"stats_port,omitempty"`             // serve the "memory" sink's top-N stats at /stats on this port, 0 disables
+	HealthzWindowSeconds int      `yaml:"healthz_window_seconds,omitempty"` // an upstream must have answered within this many seconds for /healthz (served alongside /metrics) to report healthy, defaults to 60
+}
+
+// BootstrapConfig configures the bootstrap resolver used exclusively to
+// resolve hostname-form UpstreamServer.Address values (e.g. DoH/DoT
+// endpoints given as names rather than IPs) so there's no chicken-and-egg
+// problem resolving an upstream before any upstream is available.
+type BootstrapConfig struct {
+	Resolvers       []string          `yaml:"resolvers,omitempty"`        // plain DNS resolvers, "ip:port"; defaults to 8.8.8.8:53 if empty
+	StaticHosts     map[string]string `yaml:"static_hosts,omitempty"`     // hostname -> IP overrides
+	CacheTTL        int               `yaml:"cache_ttl,omitempty"`        // seconds, defaults to 300
+	RefreshInterval int               `yaml:"refresh_interval,omitempty"` // seconds; background-refresh cached entries this often, 0 disables
+}
+
+// Configured reports whether any bootstrap resolver or static host is set.
+func (b BootstrapConfig) Configured() bool {
+	return len(b.Resolvers) > 0 || len(b.StaticHosts) > 0
+}
+
+// UpstreamHealthcheckConfig configures the active background prober that
+// probes every configured upstream on an interval, independent of real
+// client traffic, so "failover" upstream_strategy can route around a dead
+// upstream before it ever fails a real query.
+type UpstreamHealthcheckConfig struct {
+	Enabled          bool   `yaml:"enabled,omitempty"`
+	Interval         int    `yaml:"interval,omitempty"`          // seconds between probes, defaults to 30
+	ProbeDomain      string `yaml:"probe_domain,omitempty"`      // queried (A) against each upstream, defaults to "example.com."
+	FailureThreshold int    `yaml:"failure_threshold,omitempty"` // consecutive failed probes before quarantine, defaults to 3
+	BackoffMax       int    `yaml:"backoff_max,omitempty"`       // seconds, caps the exponential quarantine backoff, defaults to 300
+}
+
+// EncryptedListeners configures the encrypted DNS transports (DoT/DoH/DoQ)
+// Pocket-Concierge itself serves, so DDR has something to advertise.
+type EncryptedListeners struct {
+	CertFile  string   `yaml:"cert_file,omitempty"`
+	KeyFile   string   `yaml:"key_file,omitempty"`
+	Addresses []string `yaml:"addresses,omitempty"` // listen addresses, e.g. "0.0.0.0"
+	Protocols []string `yaml:"protocols,omitempty"` // any of "dot", "doh", "doq"
+	DoHPath   string   `yaml:"doh_path,omitempty"`  // default "/dns-query"
+}
+
+// DDRConfig overrides Discovery of Designated Resolvers (RFC 9462)
+// advertisement: the target name clients should pin and the priority of the
+// first advertised protocol; each later protocol gets the next value.
+type DDRConfig struct {
+	TargetName string `yaml:"target_name,omitempty"` // defaults to server address
+	Priority   uint16 `yaml:"priority,omitempty"`    // defaults to 1
+}
+
+// ServerConfig defines server-specific settings
+type ServerConfig struct {
+	Port       int    `yaml:"port"`
+	Address    string `yaml:"address"`
+	DisableUDP bool   `yaml:"disable_udp,omitempty"` // disable the plain UDP listener, leaving TCP as the only plain transport
+	DisableTCP bool   `yaml:"disable_tcp,omitempty"` // disable the plain TCP listener, leaving UDP as the only plain transport
+}
+
+// DNSConfig defines DNS-specific settings
+type DNSConfig struct {
+	TTL                 int       `yaml:"ttl"`
+	EnableRecursion     bool      `yaml:"enable_recursion"`
+	CacheSize           int       `yaml:"cache_size"`
+	BlockList           []string  `yaml:"block_list,omitempty"`            // Simple exact/subdomain blocklist
+	UpstreamStrategy    string    `yaml:"upstream_strategy,omitempty"`     // "sequential"/"strict" (default), "parallel_best", "fastest", or "failover"
+	Shuffle             string    `yaml:"shuffle,omitempty"`               // "off" (default), "random", or "round-robin"
+	ECS                 ECSConfig `yaml:"ecs,omitempty"`                   // default EDNS Client Subnet policy, overridable per upstream
+	StartVerifyUpstream bool      `yaml:"start_verify_upstream,omitempty"` // on Server.Start, canary-query every upstream group and fail startup if any group has none reachable
+}
+
+// ECSConfig configures the default EDNS Client Subnet (ECS, RFC 7871)
+// policy applied to queries forwarded upstream. UpstreamServer.ECS
+// overrides this on a per-upstream basis.
+type ECSConfig struct {
+	Policy   string `yaml:"policy,omitempty"`    // "strip" (default), "forward", or "add"
+	PrefixV4 int    `yaml:"prefix_v4,omitempty"` // subnet mask bits sent under "add", default 24
+	PrefixV6 int    `yaml:"prefix_v6,omitempty"` // subnet mask bits sent under "add", default 56
+}
+
+// validECSPolicies enumerates the values accepted by dns.ecs.policy and
+// upstream.ecs.
+var validECSPolicies = map[string]bool{"strip": true, "forward": true, "add": true}
+
+// validate checks e against the ECS policy values, reporting errors prefixed
+// with field (the dotted config path, e.g. "dns.ecs").
+func (e ECSConfig) validate(field string) error {
+	if e.Policy != "" && !validECSPolicies[e.Policy] {
+		return fmt.Errorf("invalid %s.policy: %s (must be strip, forward, or add)", field, e.Policy)
+	}
+	if e.PrefixV4 < 0 || e.PrefixV4 > 32 {
+		return fmt.Errorf("invalid %s.prefix_v4: %d (must be 0-32)", field, e.PrefixV4)
+	}
+	if e.PrefixV6 < 0 || e.PrefixV6 > 128 {
+		return fmt.Errorf("invalid %s.prefix_v6: %d (must be 0-128)", field, e.PrefixV6)
+	}
+	return nil
+}
+
+// CachingConfig configures the response-caching resolver stage: how long
+// answers are kept, how negative (NXDOMAIN/NODATA) results are cached per
+// RFC 2308, and whether hot entries are refreshed in the background before
+// they expire.
+type CachingConfig struct {
+	Enabled           bool `yaml:"enabled"`
+	MaxEntries        int  `yaml:"max_entries,omitempty"`        // LRU capacity, default 10000, 0 means unbounded
+	MinTTL            int  `yaml:"min_ttl,omitempty"`            // seconds, clamps cached RRset TTLs below this
+	MaxTTL            int  `yaml:"max_ttl,omitempty"`            // seconds, clamps cached RRset TTLs above this, 0 disables
+	NegativeTTL       int  `yaml:"negative_ttl,omitempty"`       // seconds a NXDOMAIN/NODATA result is cached for, 0 disables negative caching
+	Prefetch          bool `yaml:"prefetch,omitempty"`           // refresh hot entries in the background before they expire
+	PrefetchThreshold int  `yaml:"prefetch_threshold,omitempty"` // minimum hits before an entry is eligible for prefetch
+	PrefetchWindow    int  `yaml:"prefetch_window,omitempty"`    // seconds remaining before expiry that triggers a prefetch
+}
+
+// BlockingConfig configures the blocking resolver stage: named groups of
+// blocklist/allowlist sources (file paths or URLs, hosts-file or
+// plain-domain format) and how a match is answered.
+type BlockingConfig struct {
+	Groups          []BlockListGroup    `yaml:"groups,omitempty"`
+	Allowlist       []BlockListGroup    `yaml:"allowlist,omitempty"`
+	BlockType       string              `yaml:"block_type,omitempty"` // "nxdomain", "zero_ip", or "sinkhole"
+	SinkholeIPv4    string              `yaml:"sinkhole_ipv4,omitempty"`
+	SinkholeIPv6    string              `yaml:"sinkhole_ipv6,omitempty"`
+	RefreshInterval int                 `yaml:"refresh_interval,omitempty"` // seconds, 0 disables periodic refresh
+	ClientGroups    map[string]string   `yaml:"client_groups,omitempty"`    // client IP or CIDR -> group name, e.g. "kids", "adults"
+	GroupsBlock     map[string][]string `yaml:"groups_block,omitempty"`     // client group name -> enforced Groups (by Name); unlisted clients use "default"; empty disables per-client-group filtering (every group applies to everyone)
+}
+
+// BlockListGroup is a named set of blocklist/allowlist sources.
+type BlockListGroup struct {
+	Name    string   `yaml:"name"`
+	Sources []string `yaml:"sources"` // file paths or URLs
+	Format  string   `yaml:"format"`  // "hosts" or "domains"
+}
+
+type UpstreamServer struct {
+	Name     string `yaml:"name,omitempty"` // Optional friendly name
+	Address  string `yaml:"address"`        // Server address
+	Protocol string `yaml:"protocol"`       // "udp", "tcp", "tls", "https", "quic"
+	Port     int    `yaml:"port,omitempty"` // Optional custom port
+	Path     string `yaml:"path,omitempty"` // For DoH: /dns-query
+	Verify   bool   `yaml:"verify"`         // TLS certificate verification
+	ECS      string `yaml:"ecs,omitempty"`  // overrides dns.ecs.policy for this upstream: "strip", "forward", or "add"
+}
+
+// HostEntry represents a hostname to IP mapping
+type HostEntry struct {
+	Hostname string   `yaml:"hostname"`
+	IPv4     []string `yaml:"ipv4,omitempty"`
+	IPv6     []string `yaml:"ipv6,omitempty"`
+}
+
+// DefaultConfig returns a sensible default configuration
+func DefaultConfig() *Config {
+	return &Config{
+		Server: ServerConfig{
+			Port:    53,
+			Address: "0.0.0.0",
+		},
+		DNS: DNSConfig{
+			TTL:             300, // 5 minutes
+			EnableRecursion: true,
+			CacheSize:       1000,
+		},
+		Caching: CachingConfig{
+			Enabled:           true,
+			MaxEntries:        10000,
+			NegativeTTL:       60,
+			PrefetchThreshold: 3,
+			PrefetchWindow:    10,
+		},
+		Upstream: []UpstreamServer{
+			{
+				Name:     "Cloudflare DoH",
+				Address:  "1.1.1.1",
+				Protocol: "https",
+				Path:     "/dns-query",
+				Verify:   true,
+			},
+			{
+				Name:     "Google DoT",
+				Address:  "8.8.8.8",
+				Protocol: "tls",
+				Port:     853,
+				Verify:   true,
+			},
+		},
+		LogLevel:      "info",
+		Hosts:         []HostEntry{},
+		HomeDNSDomain: "home",
+	}
+}
+
+// LoadConfig reads configuration from a YAML file, or, if path is a
+// directory, from every *.yaml/*.yml fragment within it merged in
+// lexicographic order.
+func LoadConfig(path string) (*Config, error) {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return DefaultConfig(), fmt.Errorf("config file not found: %s", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat config path: %w", err)
+	}
+
+	var cfg *Config
+	if info.IsDir() {
+		cfg, err = loadConfigDir(path)
+	} else {
+		cfg, err = loadConfigFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Validate configuration
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	return cfg, nil
+}
+
+// loadConfigFile reads and parses a single YAML config file on top of the
+// defaults.
+func loadConfigFile(filename string) (*Config, error) {
+	config := DefaultConfig()
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	if err := yaml.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("failed to parse config file: %w", err)
+	}
+
+	return config, nil
+}
+
+// loadConfigDir reads every *.yaml/*.yml fragment in dir, in lexicographic
+// order, and deep-merges them on top of the defaults.
+func loadConfigDir(dir string) (*Config, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config directory: %w", err)
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		ext := strings.ToLower(filepath.Ext(entry.Name()))
+		if ext != ".yaml" && ext != ".yml" {
+			continue
+		}
+		names = append(names, entry.Name())
+	}
+	sort.Strings(names)
+
+	// Merge fragments into a blank config first so a fragment's own
+	// Hosts/Upstream lists aren't appended on top of DefaultConfig's
+	// built-in entries; defaults are back-filled afterwards for whatever
+	// no fragment specified.
+	merged := &Config{}
+	for _, name := range names {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config fragment %s: %w", name, err)
+		}
+
+		fragment := &Config{}
+		if err := yaml.Unmarshal(data, fragment); err != nil {
+			return nil, fmt.Errorf("failed to parse config fragment %s: %w", name, err)
+		}
+
+		mergeConfig(merged, fragment)
+	}
+
+	applyDefaults(merged)
+
+	return merged, nil
+}
+
+// applyDefaults back-fills any field left at its zero value after merging
+// fragments with DefaultConfig's values.
+func applyDefaults(cfg *Config) {
+	def := DefaultConfig()
+
+	if cfg.Server.Port == 0 {
+		cfg.Server.Port = def.Server.Port
+	}
+	if cfg.Server.Address == "" {
+		cfg.Server.Address = def.Server.Address
+	}
+	if cfg.DNS.TTL == 0 {
+		cfg.DNS.TTL = def.DNS.TTL
+	}
+	if cfg.DNS.CacheSize == 0 {
+		cfg.DNS.CacheSize = def.DNS.CacheSize
+	}
+	if cfg.Caching.MaxEntries == 0 {
+		cfg.Caching.MaxEntries = def.Caching.MaxEntries
+	}
+	if cfg.Caching.NegativeTTL == 0 {
+		cfg.Caching.NegativeTTL = def.Caching.NegativeTTL
+	}
+	if cfg.Caching.PrefetchThreshold == 0 {
+		cfg.Caching.PrefetchThreshold = def.Caching.PrefetchThreshold
+	}
+	if cfg.Caching.PrefetchWindow == 0 {
+		cfg.Caching.PrefetchWindow = def.Caching.PrefetchWindow
+	}
+	if len(cfg.Upstream) == 0 {
+		cfg.Upstream = def.Upstream
+	}
+	if cfg.Hosts == nil {
+		cfg.Hosts = def.Hosts
+	}
+	if cfg.LogLevel == "" {
+		cfg.LogLevel = def.LogLevel
+	}
+	if cfg.HomeDNSDomain == "" {
+		cfg.HomeDNSDomain = def.HomeDNSDomain
+	}
+}
+
+// mergeConfig deep-merges src into dst: scalars in src that are non-zero
+// override dst, while Hosts and Upstream are appended and de-duplicated by
+// hostname/address (later entries win on conflict).
+func mergeConfig(dst, src *Config) {
+	if src.Server.Port != 0 {
+		dst.Server.Port = src.Server.Port
+	}
+	if src.Server.Address != "" {
+		dst.Server.Address = src.Server.Address
+	}
+
+	if src.DNS.TTL != 0 {
+		dst.DNS.TTL = src.DNS.TTL
+	}
+	if src.DNS.CacheSize != 0 {
+		dst.DNS.CacheSize = src.DNS.CacheSize
+	}
+	if src.DNS.UpstreamStrategy != "" {
+		dst.DNS.UpstreamStrategy = src.DNS.UpstreamStrategy
+	}
+	if src.DNS.Shuffle != "" {
+		dst.DNS.Shuffle = src.DNS.Shuffle
+	}
+	if src.DNS.ECS.Policy != "" {
+		dst.DNS.ECS.Policy = src.DNS.ECS.Policy
+	}
+	if src.DNS.ECS.PrefixV4 != 0 {
+		dst.DNS.ECS.PrefixV4 = src.DNS.ECS.PrefixV4
+	}
+	if src.DNS.ECS.PrefixV6 != 0 {
+		dst.DNS.ECS.PrefixV6 = src.DNS.ECS.PrefixV6
+	}
+	dst.DNS.EnableRecursion = dst.DNS.EnableRecursion || src.DNS.EnableRecursion
+	dst.DNS.StartVerifyUpstream = dst.DNS.StartVerifyUpstream || src.DNS.StartVerifyUpstream
+	dst.DNS.BlockList = mergeDeduped(dst.DNS.BlockList, src.DNS.BlockList)
+	dst.ReverseZones = mergeDeduped(dst.ReverseZones, src.ReverseZones)
+
+	dst.Caching.Enabled = dst.Caching.Enabled || src.Caching.Enabled
+	dst.Caching.Prefetch = dst.Caching.Prefetch || src.Caching.Prefetch
+	if src.Caching.MaxEntries != 0 {
+		dst.Caching.MaxEntries = src.Caching.MaxEntries
+	}
+	if src.Caching.MinTTL != 0 {
+		dst.Caching.MinTTL = src.Caching.MinTTL
+	}
+	if src.Caching.MaxTTL != 0 {
+		dst.Caching.MaxTTL = src.Caching.MaxTTL
+	}
+	if src.Caching.NegativeTTL != 0 {
+		dst.Caching.NegativeTTL = src.Caching.NegativeTTL
+	}
+	if src.Caching.PrefetchThreshold != 0 {
+		dst.Caching.PrefetchThreshold = src.Caching.PrefetchThreshold
+	}
+	if src.Caching.PrefetchWindow != 0 {
+		dst.Caching.PrefetchWindow = src.Caching.PrefetchWindow
+	}
+
+	dst.Hosts = mergeHosts(dst.Hosts, src.Hosts)
+	dst.HostsFiles = mergeDeduped(dst.HostsFiles, src.HostsFiles)
+	dst.HostsURLs = mergeDeduped(dst.HostsURLs, src.HostsURLs)
+	if src.HostsRefreshInterval != 0 {
+		dst.HostsRefreshInterval = src.HostsRefreshInterval
+	}
+	dst.Upstream = mergeUpstreams(dst.Upstream, src.Upstream)
+
+	if src.Conditional != nil {
+		if dst.Conditional == nil {
+			dst.Conditional = make(map[string][]UpstreamServer)
+		}
+		for suffix, upstreams := range src.Conditional {
+			dst.Conditional[suffix] = upstreams
+		}
+	}
+
+	if len(src.Blocking.Groups) > 0 || len(src.Blocking.Allowlist) > 0 {
+		dst.Blocking = src.Blocking
+	}
+
+	if len(src.QueryLog.Sinks) > 0 {
+		dst.QueryLog = src.QueryLog
+	}
+
+	if src.UpstreamHealthcheck.Enabled {
+		dst.UpstreamHealthcheck = src.UpstreamHealthcheck
+	}
+
+	if len(src.Filter.Lists) > 0 {
+		dst.Filter = src.Filter
+	}
+
+	if src.LogLevel != "" {
+		dst.LogLevel = src.LogLevel
+	}
+	if src.HomeDNSDomain != "" {
+		dst.HomeDNSDomain = src.HomeDNSDomain
+	}
+}
+
+// mergeHosts appends src hosts onto dst, with later entries overriding
+// earlier ones that share a hostname.
+func mergeHosts(dst, src []HostEntry) []HostEntry {
+	index := make(map[string]int, len(dst))
+	for i, h := range dst {
+		index[h.Hostname] = i
+	}
+
+	for _, h := range src {
+		if i, ok := index[h.Hostname]; ok {
+			dst[i] = h
+			continue
+		}
+		index[h.Hostname] = len(dst)
+		dst = append(dst, h)
+	}
+
+	return dst
+}
+
+// mergeUpstreams appends src upstreams onto dst, with later entries
+// overriding earlier ones that share an address.
+func mergeUpstreams(dst, src []UpstreamServer) []UpstreamServer {
+	index := make(map[string]int, len(dst))
+	for i, u := range dst {
+		index[u.Address] = i
+	}
+
+	for _, u := range src {
+		if i, ok := index[u.Address]; ok {
+			dst[i] = u
+			continue
+		}
+		index[u.Address] = len(dst)
+		dst = append(dst, u)
+	}
+
+	return dst
+}
+
+// mergeDeduped appends src onto dst, skipping values already present.
+func mergeDeduped(dst, src []string) []string {
+	seen := make(map[string]bool, len(dst))
+	for _, v := range dst {
+		seen[v] = true
+	}
+	for _, v := range src {
+		if !seen[v] {
+			dst = append(dst, v)
+			seen[v] = true
+		}
+	}
+	return dst
+}
+
+// SaveConfig writes configuration to a YAML file
+func (c *Config) SaveConfig(filename string) error {
+	// Create directory if it doesn't exist
+	dir := filepath.Dir(filename)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create config directory: %w", err)
+	}
+
+	// Marshal to YAML
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return fmt.Errorf("failed to marshal config: %w", err)
+	}
+
+	// Write file
+	if err := os.WriteFile(filename, data, 0644); err != nil {
+		return fmt.Errorf("failed to write config file: %w", err)
+	}
+
+	return nil
+}
+
+// Validate checks if the configuration is valid
+func (c *Config) Validate() error {
+	// Validate server settings
+	if c.Server.Port < 1 || c.Server.Port > 65535 {
+		return fmt.Errorf("invalid port: %d (must be 1-65535)", c.Server.Port)
+	}
+
+	if net.ParseIP(c.Server.Address) == nil && c.Server.Address != "0.0.0.0" {
+		return fmt.Errorf("invalid server address: %s", c.Server.Address)
+	}
+
+	if c.Server.DisableUDP && c.Server.DisableTCP {
+		return fmt.Errorf("invalid server config: disable_udp and disable_tcp cannot both be set, no plain listener would remain")
+	}
+
+	// Validate upstream servers
+	if err := validateUpstreams(c.Upstream, c.Bootstrap.Configured()); err != nil {
+		return err
+	}
+
+	// Validate conditional (per-domain) upstream groups. Suffix uniqueness
+	// doesn't need its own check here: c.Conditional is a Go map, so it can
+	// never hold two entries for the same suffix once YAML is unmarshaled.
+	for suffix, upstreams := range c.Conditional {
+		if suffix == "" {
+			return fmt.Errorf("conditional upstream: domain suffix cannot be empty")
+		}
+		if len(upstreams) == 0 {
+			return fmt.Errorf("conditional upstream %q: must have at least one upstream", suffix)
+		}
+		if err := validateUpstreams(upstreams, c.Bootstrap.Configured()); err != nil {
+			return fmt.Errorf("conditional upstream %q: %w", suffix, err)
+		}
+	}
+
+	// Validate host entries
+	for i, host := range c.Hosts {
+		if host.Hostname == "" {
+			return fmt.Errorf("host entry %d: hostname cannot be empty", i)
+		}
+
+		// Validate IPv4 addresses
+		for _, ip := range host.IPv4 {
+			if net.ParseIP(ip) == nil {
+				return fmt.Errorf("host entry %s: invalid IPv4 address: %s", host.Hostname, ip)
+			}
+		}
+
+		// Validate IPv6 addresses
+		for _, ip := range host.IPv6 {
+			if net.ParseIP(ip) == nil {
+				return fmt.Errorf("host entry %s: invalid IPv6 address: %s", host.Hostname, ip)
+			}
+		}
+
+		// Must have at least one IP
+		if len(host.IPv4) == 0 && len(host.IPv6) == 0 {
+			return fmt.Errorf("host entry %s: must have at least one IP address", host.Hostname)
+		}
+	}
+
+	// Validate log level
+	validLevels := map[string]bool{
+		"debug": true, "info": true, "warn": true, "error": true,
+	}
+	if !validLevels[c.LogLevel] {
+		return fmt.Errorf("invalid log level: %s (must be debug, info, warn, or error)", c.LogLevel)
+	}
+
+	// Validate upstream strategy
+	if c.DNS.UpstreamStrategy != "" {
+		validStrategies := map[string]bool{"sequential": true, "strict": true, "parallel_best": true, "fastest": true, "failover": true}
+		if !validStrategies[c.DNS.UpstreamStrategy] {
+			return fmt.Errorf("invalid dns.upstream_strategy: %s (must be sequential, strict, parallel_best, fastest, or failover)", c.DNS.UpstreamStrategy)
+		}
+	}
+
+	// Validate hosts ingestion settings
+	if c.HostsRefreshInterval < 0 {
+		return fmt.Errorf("invalid hosts_refresh_interval: %d (must be >= 0)", c.HostsRefreshInterval)
+	}
+
+	// Validate upstream health-check settings
+	if c.UpstreamHealthcheck.Interval < 0 {
+		return fmt.Errorf("invalid upstream_healthcheck.interval: %d (must be >= 0)", c.UpstreamHealthcheck.Interval)
+	}
+	if c.UpstreamHealthcheck.FailureThreshold < 0 {
+		return fmt.Errorf("invalid upstream_healthcheck.failure_threshold: %d (must be >= 0)", c.UpstreamHealthcheck.FailureThreshold)
+	}
+	if c.UpstreamHealthcheck.BackoffMax < 0 {
+		return fmt.Errorf("invalid upstream_healthcheck.backoff_max: %d (must be >= 0)", c.UpstreamHealthcheck.BackoffMax)
+	}
+
+	if c.DNS.Shuffle != "" {
+		validShuffleModes := map[string]bool{"off": true, "random": true, "round-robin": true}
+		if !validShuffleModes[c.DNS.Shuffle] {
+			return fmt.Errorf("invalid dns.shuffle: %s (must be off, random, or round-robin)", c.DNS.Shuffle)
+		}
+	}
+
+	// Validate EDNS Client Subnet policy
+	if err := c.DNS.ECS.validate("dns.ecs"); err != nil {
+		return err
+	}
+	for _, upstream := range c.Upstream {
+		if upstream.ECS != "" && !validECSPolicies[upstream.ECS] {
+			return fmt.Errorf("invalid upstream %q ecs: %s (must be strip, forward, or add)", upstream.Address, upstream.ECS)
+		}
+	}
+
+	// Validate caching settings
+	if c.Caching.MaxEntries < 0 {
+		return fmt.Errorf("invalid caching.max_entries: %d (must be >= 0)", c.Caching.MaxEntries)
+	}
+	if c.Caching.MaxTTL > 0 && c.Caching.MinTTL > c.Caching.MaxTTL {
+		return fmt.Errorf("invalid caching.min_ttl: %d (must not exceed caching.max_ttl %d)", c.Caching.MinTTL, c.Caching.MaxTTL)
+	}
+	if c.Caching.Prefetch && c.Caching.PrefetchThreshold <= 0 {
+		return fmt.Errorf("invalid caching.prefetch_threshold: %d (must be > 0 when caching.prefetch is enabled)", c.Caching.PrefetchThreshold)
+	}
+
+	// Validate encrypted listener protocols
+	validListenerProtocols := map[string]bool{"dot": true, "doh": true, "doq": true}
+	for _, proto := range c.Encrypted.Protocols {
+		if !validListenerProtocols[proto] {
+			return fmt.Errorf("invalid encrypted.protocols entry: %s (must be dot, doh, or doq)", proto)
+		}
+	}
+	if (c.Encrypted.Enabled("dot") || c.Encrypted.Enabled("doh")) && (c.Encrypted.CertFile == "" || c.Encrypted.KeyFile == "") {
+		return fmt.Errorf("encrypted.cert_file and encrypted.key_file are required to serve dot or doh")
+	}
+
+	// Validate home DNS domain
+	if c.HomeDNSDomain == "" {
+		return fmt.Errorf("home_dns_domain cannot be empty")
+	}
+
+	// Validate blocking configuration
+	if c.Blocking.BlockType != "" {
+		validBlockTypes := map[string]bool{"nxdomain": true, "zero_ip": true, "sinkhole": true}
+		if !validBlockTypes[c.Blocking.BlockType] {
+			return fmt.Errorf("invalid blocking.block_type: %s (must be nxdomain, zero_ip, or sinkhole)", c.Blocking.BlockType)
+		}
+		if c.Blocking.BlockType == "sinkhole" && c.Blocking.SinkholeIPv4 == "" && c.Blocking.SinkholeIPv6 == "" {
+			return fmt.Errorf("blocking.block_type is sinkhole but no sinkhole_ipv4/sinkhole_ipv6 configured")
+		}
+	}
+	for _, group := range append(append([]BlockListGroup{}, c.Blocking.Groups...), c.Blocking.Allowlist...) {
+		if group.Name == "" {
+			return fmt.Errorf("blocking group: name cannot be empty")
+		}
+		if len(group.Sources) == 0 {
+			return fmt.Errorf("blocking group %q: must have at least one source", group.Name)
+		}
+		if group.Format != "hosts" && group.Format != "domains" {
+			return fmt.Errorf("blocking group %q: invalid format %q (must be hosts or domains)", group.Name, group.Format)
+		}
+	}
+	knownGroupNames := make(map[string]bool, len(c.Blocking.Groups))
+	for _, group := range c.Blocking.Groups {
+		knownGroupNames[group.Name] = true
+	}
+	for clientGroup, groupNames := range c.Blocking.GroupsBlock {
+		for _, name := range groupNames {
+			if !knownGroupNames[name] {
+				return fmt.Errorf("blocking.groups_block %q: unknown group %q", clientGroup, name)
+			}
+		}
+	}
+
+	// Validate query log configuration
+	validSinks := map[string]bool{"file": true, "memory": true, "prometheus": true}
+	for _, sink := range c.QueryLog.Sinks {
+		if !validSinks[sink] {
+			return fmt.Errorf("invalid query_log.sinks entry: %s (must be file, memory, or prometheus)", sink)
+		}
+		if sink == "file" && c.QueryLog.FilePath == "" {
+			return fmt.Errorf("query_log.sinks includes file but query_log.file_path is empty")
+		}
+	}
+	if c.QueryLog.MetricsPort != 0 && (c.QueryLog.MetricsPort < 1 || c.QueryLog.MetricsPort > 65535) {
+		return fmt.Errorf("invalid query_log.metrics_port: %d (must be 1-65535)", c.QueryLog.MetricsPort)
+	}
+	if c.QueryLog.StatsPort != 0 && (c.QueryLog.StatsPort < 1 || c.QueryLog.StatsPort > 65535) {
+		return fmt.Errorf("invalid query_log.stats_port: %d (must be 1-65535)", c.QueryLog.StatsPort)
+	}
+
+	// Validate response filtering configuration
+	if c.Filter.Action != "" {
+		validFilterActions := map[string]bool{"nxdomain": true, "sinkhole": true}
+		if !validFilterActions[c.Filter.Action] {
+			return fmt.Errorf("invalid filter.action: %s (must be nxdomain or sinkhole)", c.Filter.Action)
+		}
+		if c.Filter.Action == "sinkhole" && c.Filter.SinkholeIPv4 == "" && c.Filter.SinkholeIPv6 == "" {
+			return fmt.Errorf("filter.action is sinkhole but no sinkhole_ipv4/sinkhole_ipv6 configured")
+		}
+	}
+	for _, list := range c.Filter.Lists {
+		if list.Name == "" {
+			return fmt.Errorf("filter list: name cannot be empty")
+		}
+		if list.URL == "" {
+			return fmt.Errorf("filter list %q: url cannot be empty", list.Name)
+		}
+	}
+
+	return nil
+}
+
+// validateUpstreams checks a list of upstream servers and fills in protocol
+// defaults in place. Shared by the top-level Upstream list and each
+// Conditional routing group so they follow identical rules. bootstrapConfigured
+// allows Address to be a hostname instead of an IP, since a bootstrap
+// resolver is available to resolve it at connect time.
+func validateUpstreams(upstreams []UpstreamServer, bootstrapConfigured bool) error {
+	for i := range upstreams { // Use range with index to modify in place
+		upstream := &upstreams[i] // Get pointer to modify original
+
+		if upstream.Address == "" {
+			return fmt.Errorf("upstream server %d: address cannot be empty", i)
+		}
+
+		if net.ParseIP(upstream.Address) == nil && !bootstrapConfigured {
+			return fmt.Errorf("upstream server %d: address %q is not an IP (configure bootstrap resolvers to allow hostnames)", i, upstream.Address)
+		}
+
+		// Validate protocol
+		validProtocols := map[string]bool{
+			"udp": true, "tcp": true, "tls": true, "https": true, "quic": true,
+		}
+		if !validProtocols[upstream.Protocol] {
+			return fmt.Errorf("upstream server %d: invalid protocol '%s' (must be udp, tcp, tls, https, or quic)", i, upstream.Protocol)
+		}
+
+		// Set default ports if not specified
+		if upstream.Port == 0 {
+			switch upstream.Protocol {
+			case "udp", "tcp":
+				upstream.Port = 53
+			case "tls":
+				upstream.Port = 853
+			case "https":
+				upstream.Port = 443
+			case "quic":
+				upstream.Port = 853
+			}
+		}
+
+		// Set default HTTPS path
+		if upstream.Protocol == "https" && upstream.Path == "" {
+			upstream.Path = "/dns-query"
+		}
+	}
+
+	return nil
+}
+
+// Enabled reports whether protocol ("dot", "doh", or "doq") is configured
+// as one of the encrypted listeners Pocket-Concierge itself serves.
+func (e EncryptedListeners) Enabled(protocol string) bool {
+	for _, p := range e.Protocols {
+		if p == protocol {
+			return true
+		}
+	}
+	return false
+}
+
+// GetHostByName returns the HostEntry for a given hostname
+func (c *Config) GetHostByName(hostname string) (*HostEntry, bool) {
+	for _, host := range c.Hosts {
+		if host.Hostname == hostname {
+			return &host, true
+		}
+	}
+	return nil, false
+}
+
+// IsBlocked reports whether domain (or any of its parent domains) appears in
+// the simple DNS.BlockList. This is the legacy exact/subdomain blocklist;
+// see the blocking package for the richer multi-source blocking stage.
+func (c *Config) IsBlocked(domain string) bool {
+	if domain == "" {
+		return false
+	}
+
+	normalized := strings.ToLower(strings.TrimSuffix(domain, "."))
+
+	for _, blocked := range c.DNS.BlockList {
+		blocked = strings.ToLower(strings.TrimSuffix(blocked, "."))
+		if normalized == blocked || strings.HasSuffix(normalized, "."+blocked) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -353,6 +353,21 @@ func TestConfigValidate(t *testing.T) {
 			expectError: true,
 			errorMsg:    "must have at least one IP address",
 		},
+		{
+			name: "disabling both udp and tcp",
+			config: &Config{
+				Server: ServerConfig{
+					Port:       8053,
+					Address:    "127.0.0.1",
+					DisableUDP: true,
+					DisableTCP: true,
+				},
+				LogLevel:      "info",
+				HomeDNSDomain: "home",
+			},
+			expectError: true,
+			errorMsg:    "disable_udp and disable_tcp cannot both be set",
+		},
 		{
 			name: "invalid log level",
 			config: &Config{
@@ -424,6 +439,9 @@ func TestConfigValidateUpstreamDefaults(t *testing.T) {
 				Protocol: "https",
 			},
 		},
+		Bootstrap: BootstrapConfig{
+			Resolvers: []string{"1.1.1.1:53"},
+		},
 		LogLevel:      "info",
 		HomeDNSDomain: "home",
 	}
@@ -447,6 +465,91 @@ func TestConfigValidateUpstreamDefaults(t *testing.T) {
 	}
 }
 
+func TestConfigValidateConditional(t *testing.T) {
+	tests := []struct {
+		name        string
+		conditional map[string][]UpstreamServer
+		expectError bool
+		errorMsg    string
+	}{
+		{
+			name: "valid conditional group",
+			conditional: map[string][]UpstreamServer{
+				"corp.example": {{Address: "10.0.0.1", Protocol: "udp"}},
+			},
+			expectError: false,
+		},
+		{
+			name: "conditional group with no upstreams",
+			conditional: map[string][]UpstreamServer{
+				"corp.example": {},
+			},
+			expectError: true,
+			errorMsg:    "must have at least one upstream",
+		},
+		{
+			name: "conditional group with invalid protocol",
+			conditional: map[string][]UpstreamServer{
+				"corp.example": {{Address: "10.0.0.1", Protocol: "bogus"}},
+			},
+			expectError: true,
+			errorMsg:    "invalid protocol",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{
+				Server:        ServerConfig{Port: 8053, Address: "127.0.0.1"},
+				LogLevel:      "info",
+				HomeDNSDomain: "home",
+				Conditional:   tt.conditional,
+			}
+
+			err := cfg.Validate()
+			if tt.expectError {
+				if err == nil {
+					t.Error("Expected validation error but got none")
+				} else if !strings.Contains(err.Error(), tt.errorMsg) {
+					t.Errorf("Expected error containing '%s', got: %v", tt.errorMsg, err)
+				}
+			} else if err != nil {
+				t.Errorf("Unexpected validation error: %v", err)
+			}
+		})
+	}
+}
+
+func TestConfigConditionalYAMLRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+
+	original := DefaultConfig()
+	original.Conditional = map[string][]UpstreamServer{
+		"corp.example": {{Address: "10.0.0.1", Protocol: "udp", Port: 53}},
+		"home":         {{Address: "10.0.0.2", Protocol: "udp", Port: 53}},
+	}
+
+	if err := original.SaveConfig(path); err != nil {
+		t.Fatalf("SaveConfig failed: %v", err)
+	}
+
+	loaded, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+
+	if len(loaded.Conditional) != 2 {
+		t.Fatalf("Expected 2 conditional groups, got %d", len(loaded.Conditional))
+	}
+	if loaded.Conditional["corp.example"][0].Address != "10.0.0.1" {
+		t.Errorf("Expected corp.example upstream 10.0.0.1, got %s", loaded.Conditional["corp.example"][0].Address)
+	}
+	if loaded.Conditional["home"][0].Address != "10.0.0.2" {
+		t.Errorf("Expected home upstream 10.0.0.2, got %s", loaded.Conditional["home"][0].Address)
+	}
+}
+
 func TestConfigIsBlocked(t *testing.T) {
 	cfg := &Config{
 		DNS: DNSConfig{
@@ -520,6 +623,412 @@ func TestConfigIsBlocked(t *testing.T) {
 	}
 }
 
+func TestConfigValidateBlocking(t *testing.T) {
+	baseCfg := func() *Config {
+		return &Config{
+			Server:        ServerConfig{Port: 8053, Address: "127.0.0.1"},
+			LogLevel:      "info",
+			HomeDNSDomain: "home",
+		}
+	}
+
+	tests := []struct {
+		name        string
+		mutate      func(*Config)
+		expectError bool
+		errorMsg    string
+	}{
+		{
+			name:        "no blocking configured",
+			mutate:      func(c *Config) {},
+			expectError: false,
+		},
+		{
+			name: "valid group",
+			mutate: func(c *Config) {
+				c.Blocking.Groups = []BlockListGroup{{Name: "ads", Sources: []string{"lists/ads.txt"}, Format: "domains"}}
+			},
+			expectError: false,
+		},
+		{
+			name: "group with no sources",
+			mutate: func(c *Config) {
+				c.Blocking.Groups = []BlockListGroup{{Name: "ads", Format: "domains"}}
+			},
+			expectError: true,
+			errorMsg:    "must have at least one source",
+		},
+		{
+			name: "invalid format",
+			mutate: func(c *Config) {
+				c.Blocking.Groups = []BlockListGroup{{Name: "ads", Sources: []string{"lists/ads.txt"}, Format: "csv"}}
+			},
+			expectError: true,
+			errorMsg:    "invalid format",
+		},
+		{
+			name: "invalid block type",
+			mutate: func(c *Config) {
+				c.Blocking.BlockType = "rickroll"
+			},
+			expectError: true,
+			errorMsg:    "invalid blocking.block_type",
+		},
+		{
+			name: "sinkhole without address",
+			mutate: func(c *Config) {
+				c.Blocking.BlockType = "sinkhole"
+			},
+			expectError: true,
+			errorMsg:    "no sinkhole_ipv4/sinkhole_ipv6",
+		},
+		{
+			name: "sinkhole with address",
+			mutate: func(c *Config) {
+				c.Blocking.BlockType = "sinkhole"
+				c.Blocking.SinkholeIPv4 = "10.0.0.1"
+			},
+			expectError: false,
+		},
+		{
+			name: "groups_block referencing a known group",
+			mutate: func(c *Config) {
+				c.Blocking.Groups = []BlockListGroup{{Name: "ads", Sources: []string{"lists/ads.txt"}, Format: "domains"}}
+				c.Blocking.GroupsBlock = map[string][]string{"kids": {"ads"}}
+			},
+			expectError: false,
+		},
+		{
+			name: "groups_block referencing an unknown group",
+			mutate: func(c *Config) {
+				c.Blocking.Groups = []BlockListGroup{{Name: "ads", Sources: []string{"lists/ads.txt"}, Format: "domains"}}
+				c.Blocking.GroupsBlock = map[string][]string{"kids": {"social"}}
+			},
+			expectError: true,
+			errorMsg:    `unknown group "social"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := baseCfg()
+			tt.mutate(cfg)
+
+			err := cfg.Validate()
+			if tt.expectError {
+				if err == nil {
+					t.Fatal("Expected validation error but got none")
+				}
+				if !strings.Contains(err.Error(), tt.errorMsg) {
+					t.Errorf("Expected error containing %q, got: %v", tt.errorMsg, err)
+				}
+			} else if err != nil {
+				t.Errorf("Unexpected validation error: %v", err)
+			}
+		})
+	}
+}
+
+func TestConfigValidateECS(t *testing.T) {
+	baseCfg := func() *Config {
+		return &Config{
+			Server:        ServerConfig{Port: 8053, Address: "127.0.0.1"},
+			LogLevel:      "info",
+			HomeDNSDomain: "home",
+		}
+	}
+
+	tests := []struct {
+		name        string
+		mutate      func(*Config)
+		expectError bool
+		errorMsg    string
+	}{
+		{
+			name:        "no ecs configured",
+			mutate:      func(c *Config) {},
+			expectError: false,
+		},
+		{
+			name: "valid policy",
+			mutate: func(c *Config) {
+				c.DNS.ECS.Policy = "add"
+			},
+			expectError: false,
+		},
+		{
+			name: "invalid policy",
+			mutate: func(c *Config) {
+				c.DNS.ECS.Policy = "rewrite"
+			},
+			expectError: true,
+			errorMsg:    "invalid dns.ecs.policy",
+		},
+		{
+			name: "prefix_v4 out of range",
+			mutate: func(c *Config) {
+				c.DNS.ECS.PrefixV4 = 33
+			},
+			expectError: true,
+			errorMsg:    "invalid dns.ecs.prefix_v4",
+		},
+		{
+			name: "prefix_v6 out of range",
+			mutate: func(c *Config) {
+				c.DNS.ECS.PrefixV6 = 129
+			},
+			expectError: true,
+			errorMsg:    "invalid dns.ecs.prefix_v6",
+		},
+		{
+			name: "invalid upstream ecs override",
+			mutate: func(c *Config) {
+				c.Upstream = []UpstreamServer{{Address: "1.1.1.1", Protocol: "udp", ECS: "rewrite"}}
+			},
+			expectError: true,
+			errorMsg:    "invalid upstream",
+		},
+		{
+			name: "valid upstream ecs override",
+			mutate: func(c *Config) {
+				c.Upstream = []UpstreamServer{{Address: "1.1.1.1", Protocol: "udp", ECS: "forward"}}
+			},
+			expectError: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := baseCfg()
+			tt.mutate(cfg)
+
+			err := cfg.Validate()
+			if tt.expectError {
+				if err == nil {
+					t.Fatal("Expected validation error but got none")
+				}
+				if !strings.Contains(err.Error(), tt.errorMsg) {
+					t.Errorf("Expected error containing %q, got: %v", tt.errorMsg, err)
+				}
+			} else if err != nil {
+				t.Errorf("Unexpected validation error: %v", err)
+			}
+		})
+	}
+}
+
+func TestConfigValidateCaching(t *testing.T) {
+	baseCfg := func() *Config {
+		return &Config{
+			Server:        ServerConfig{Port: 8053, Address: "127.0.0.1"},
+			LogLevel:      "info",
+			HomeDNSDomain: "home",
+		}
+	}
+
+	tests := []struct {
+		name        string
+		mutate      func(*Config)
+		expectError bool
+		errorMsg    string
+	}{
+		{
+			name:        "no caching configured",
+			mutate:      func(c *Config) {},
+			expectError: false,
+		},
+		{
+			name: "negative max entries",
+			mutate: func(c *Config) {
+				c.Caching.MaxEntries = -1
+			},
+			expectError: true,
+			errorMsg:    "invalid caching.max_entries",
+		},
+		{
+			name: "min ttl exceeds max ttl",
+			mutate: func(c *Config) {
+				c.Caching.MinTTL = 120
+				c.Caching.MaxTTL = 60
+			},
+			expectError: true,
+			errorMsg:    "invalid caching.min_ttl",
+		},
+		{
+			name: "min ttl within max ttl",
+			mutate: func(c *Config) {
+				c.Caching.MinTTL = 30
+				c.Caching.MaxTTL = 60
+			},
+			expectError: false,
+		},
+		{
+			name: "prefetch without threshold",
+			mutate: func(c *Config) {
+				c.Caching.Prefetch = true
+			},
+			expectError: true,
+			errorMsg:    "invalid caching.prefetch_threshold",
+		},
+		{
+			name: "prefetch with threshold",
+			mutate: func(c *Config) {
+				c.Caching.Prefetch = true
+				c.Caching.PrefetchThreshold = 3
+			},
+			expectError: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := baseCfg()
+			tt.mutate(cfg)
+
+			err := cfg.Validate()
+			if tt.expectError {
+				if err == nil {
+					t.Fatal("Expected validation error but got none")
+				}
+				if !strings.Contains(err.Error(), tt.errorMsg) {
+					t.Errorf("Expected error containing %q, got: %v", tt.errorMsg, err)
+				}
+			} else if err != nil {
+				t.Errorf("Unexpected validation error: %v", err)
+			}
+		})
+	}
+}
+
+func TestLoadConfigFromDirectoryMergesFragments(t *testing.T) {
+	singleFileYAML := `
+server:
+  port: 8053
+  address: "127.0.0.1"
+dns:
+  ttl: 300
+  enable_recursion: true
+  cache_size: 500
+hosts:
+  - hostname: nas
+    ipv4: ["192.168.1.10"]
+  - hostname: printer
+    ipv4: ["192.168.1.20"]
+upstream:
+  - name: "Custom DNS"
+    address: "9.9.9.9"
+    protocol: "udp"
+    port: 53
+    verify: false
+log_level: "debug"
+home_dns_domain: "home"
+`
+
+	singleDir := t.TempDir()
+	singlePath := filepath.Join(singleDir, "config.yaml")
+	if err := os.WriteFile(singlePath, []byte(singleFileYAML), 0644); err != nil {
+		t.Fatalf("failed to write single config: %v", err)
+	}
+
+	original, err := LoadConfig(singlePath)
+	if err != nil {
+		t.Fatalf("LoadConfig(single file) failed: %v", err)
+	}
+
+	fragDir := t.TempDir()
+	fragments := map[string]string{
+		"01-server.yaml": `
+server:
+  port: 8053
+  address: "127.0.0.1"
+dns:
+  ttl: 300
+  cache_size: 500
+`,
+		"02-hosts.yml": `
+hosts:
+  - hostname: nas
+    ipv4: ["192.168.1.10"]
+  - hostname: printer
+    ipv4: ["192.168.1.20"]
+upstream:
+  - name: "Custom DNS"
+    address: "9.9.9.9"
+    protocol: "udp"
+    port: 53
+    verify: false
+`,
+		"03-misc.yaml": `
+dns:
+  enable_recursion: true
+log_level: "debug"
+home_dns_domain: "home"
+`,
+		"ignored.txt": "this should not be parsed as YAML",
+	}
+	for name, contents := range fragments {
+		if err := os.WriteFile(filepath.Join(fragDir, name), []byte(contents), 0644); err != nil {
+			t.Fatalf("failed to write fragment %s: %v", name, err)
+		}
+	}
+
+	merged, err := LoadConfig(fragDir)
+	if err != nil {
+		t.Fatalf("LoadConfig(directory) failed: %v", err)
+	}
+
+	if merged.Server != original.Server {
+		t.Errorf("Server mismatch: merged=%+v original=%+v", merged.Server, original.Server)
+	}
+	if merged.DNS.TTL != original.DNS.TTL || merged.DNS.EnableRecursion != original.DNS.EnableRecursion || merged.DNS.CacheSize != original.DNS.CacheSize {
+		t.Errorf("DNS mismatch: merged=%+v original=%+v", merged.DNS, original.DNS)
+	}
+	if len(merged.Hosts) != len(original.Hosts) {
+		t.Fatalf("expected %d hosts, got %d", len(original.Hosts), len(merged.Hosts))
+	}
+	for i := range original.Hosts {
+		if merged.Hosts[i].Hostname != original.Hosts[i].Hostname {
+			t.Errorf("host %d mismatch: merged=%s original=%s", i, merged.Hosts[i].Hostname, original.Hosts[i].Hostname)
+		}
+	}
+	if len(merged.Upstream) != len(original.Upstream) {
+		t.Fatalf("expected %d upstreams, got %d", len(original.Upstream), len(merged.Upstream))
+	}
+	if merged.Upstream[0].Address != original.Upstream[0].Address {
+		t.Errorf("upstream mismatch: merged=%s original=%s", merged.Upstream[0].Address, original.Upstream[0].Address)
+	}
+	if merged.LogLevel != original.LogLevel {
+		t.Errorf("LogLevel mismatch: merged=%s original=%s", merged.LogLevel, original.LogLevel)
+	}
+	if merged.HomeDNSDomain != original.HomeDNSDomain {
+		t.Errorf("HomeDNSDomain mismatch: merged=%s original=%s", merged.HomeDNSDomain, original.HomeDNSDomain)
+	}
+}
+
+func TestLoadConfigDirectoryIgnoresNonYAMLFiles(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "config.yaml"), []byte(`
+server:
+  port: 8053
+  address: "127.0.0.1"
+log_level: "info"
+home_dns_domain: "home"
+`), 0644); err != nil {
+		t.Fatalf("failed to write fragment: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "README.md"), []byte("not yaml"), 0644); err != nil {
+		t.Fatalf("failed to write non-yaml file: %v", err)
+	}
+
+	cfg, err := LoadConfig(dir)
+	if err != nil {
+		t.Fatalf("LoadConfig(directory) failed: %v", err)
+	}
+	if cfg.Server.Port != 8053 {
+		t.Errorf("expected port 8053, got %d", cfg.Server.Port)
+	}
+}
+
 func BenchmarkLoadConfig(b *testing.B) {
 	configYAML := `
 server:
@@ -0,0 +1,246 @@
+package config
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// UnmarshalYAML lets an upstream be written in config.yaml either as the
+// full structured form, or as a single shorthand string such as
+// "tls://1.1.1.1", "https://cloudflare-dns.com/dns-query",
+// "quic://dns.adguard.com", or an "sdns://" DNS Stamp, parsed by
+// ParseUpstreamSpec. This mirrors how EncryptedListeners.Addresses or
+// BootstrapConfig.Resolvers accept plain strings, without forcing every
+// upstream entry into the verbose struct form.
+func (u *UpstreamServer) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		parsed, err := ParseUpstreamSpec(value.Value)
+		if err != nil {
+			return err
+		}
+		*u = parsed
+		return nil
+	}
+
+	type plain UpstreamServer
+	var p plain
+	if err := value.Decode(&p); err != nil {
+		return err
+	}
+	*u = UpstreamServer(p)
+	return nil
+}
+
+// ParseUpstreamSpec parses a single URL-like upstream specification into an
+// UpstreamServer. Supported schemes are "udp://", "tcp://", "tls://" (DoT),
+// "https://" (DoH), "quic://" (DoQ), and "sdns://" (a DNS Stamp, see
+// https://dnscrypt.info/stamps-specifications). A bare "host" or
+// "host:port" with no scheme is treated as "udp://host[:port]".
+func ParseUpstreamSpec(spec string) (UpstreamServer, error) {
+	if strings.HasPrefix(spec, "sdns://") {
+		return parseDNSStamp(spec)
+	}
+
+	// Without "://", url.Parse would read a bare "dns.example.com:53" as
+	// scheme "dns.example.com", so scheme-less specs are handled up front.
+	u, err := url.Parse(spec)
+	if err != nil || u.Scheme == "" || !strings.Contains(spec, "://") {
+		return upstreamFromStampAddr(spec, "udp", 53)
+	}
+
+	switch u.Scheme {
+	case "udp":
+		return upstreamFromURL(u, "udp", 53)
+	case "tcp":
+		return upstreamFromURL(u, "tcp", 53)
+	case "tls":
+		return upstreamFromURL(u, "tls", 853)
+	case "quic":
+		return upstreamFromURL(u, "quic", 853)
+	case "https":
+		server, err := upstreamFromURL(u, "https", 443)
+		if err != nil {
+			return UpstreamServer{}, err
+		}
+		server.Path = u.Path
+		if server.Path == "" {
+			server.Path = "/dns-query"
+		}
+		return server, nil
+	default:
+		return UpstreamServer{}, fmt.Errorf("unsupported upstream scheme %q in %q", u.Scheme, spec)
+	}
+}
+
+// upstreamFromURL builds an UpstreamServer from a parsed scheme://host[:port]
+// spec, defaulting to defaultPort when no port is given. Verify is always
+// set.
+func upstreamFromURL(u *url.URL, protocol string, defaultPort int) (UpstreamServer, error) {
+	host := u.Hostname()
+	if host == "" {
+		return UpstreamServer{}, fmt.Errorf("upstream spec %q has no host", u.String())
+	}
+
+	port := defaultPort
+	if p := u.Port(); p != "" {
+		parsed, err := strconv.Atoi(p)
+		if err != nil {
+			return UpstreamServer{}, fmt.Errorf("invalid port %q in upstream spec %q: %w", p, u.String(), err)
+		}
+		port = parsed
+	}
+
+	return UpstreamServer{Address: host, Protocol: protocol, Port: port, Verify: true}, nil
+}
+
+// upstreamFromStampAddr builds an UpstreamServer from a bare "host" or
+// "host:port" address (as found in a DNS Stamp's addr field, or a
+// scheme-less config entry), defaulting to defaultPort when no port is
+// given.
+func upstreamFromStampAddr(addr, protocol string, defaultPort int) (UpstreamServer, error) {
+	if addr == "" {
+		return UpstreamServer{}, fmt.Errorf("upstream address is empty")
+	}
+
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return UpstreamServer{Address: addr, Protocol: protocol, Port: defaultPort, Verify: true}, nil
+	}
+
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return UpstreamServer{}, fmt.Errorf("invalid port in address %q: %w", addr, err)
+	}
+	return UpstreamServer{Address: host, Protocol: protocol, Port: port, Verify: true}, nil
+}
+
+// DNS Stamp protocol type bytes, per
+// https://dnscrypt.info/stamps-specifications.
+const (
+	stampProtoPlain    = 0x00
+	stampProtoDNSCrypt = 0x01
+	stampProtoDoH      = 0x02
+	stampProtoTLS      = 0x03
+	stampProtoDoQ      = 0x04
+)
+
+// parseDNSStamp decodes an "sdns://" DNS Stamp into an UpstreamServer.
+// DNSCrypt stamps are rejected, since Pocket-Concierge has no DNSCrypt
+// client; certificate pin hashes are parsed (to stay on the wire format)
+// but otherwise ignored, matching the rest of the config's boolean
+// Verify flag rather than pinning.
+func parseDNSStamp(spec string) (UpstreamServer, error) {
+	encoded := strings.TrimPrefix(spec, "sdns://")
+	raw, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return UpstreamServer{}, fmt.Errorf("invalid sdns stamp: %w", err)
+	}
+	if len(raw) < 9 {
+		return UpstreamServer{}, fmt.Errorf("invalid sdns stamp: too short")
+	}
+
+	protocol := raw[0]
+	rest := raw[9:] // skip the 1-byte protocol + 8-byte props fields
+
+	addr, rest, err := readLP(rest)
+	if err != nil {
+		return UpstreamServer{}, fmt.Errorf("invalid sdns stamp: %w", err)
+	}
+
+	switch protocol {
+	case stampProtoPlain:
+		return upstreamFromStampAddr(string(addr), "udp", 53)
+
+	case stampProtoDoH, stampProtoTLS, stampProtoDoQ:
+		if _, rest, err = readVLP(rest); err != nil {
+			return UpstreamServer{}, fmt.Errorf("invalid sdns stamp: %w", err)
+		}
+
+		hostname, rest, err := readLP(rest)
+		if err != nil {
+			return UpstreamServer{}, fmt.Errorf("invalid sdns stamp: %w", err)
+		}
+
+		protoName, defaultPort := stampProtocolName(protocol)
+
+		hostSpec := string(hostname)
+		if hostSpec == "" {
+			hostSpec = string(addr)
+		}
+		server, err := upstreamFromStampAddr(hostSpec, protoName, defaultPort)
+		if err != nil {
+			return UpstreamServer{}, err
+		}
+
+		if protocol == stampProtoDoH {
+			path, _, err := readLP(rest)
+			if err != nil {
+				return UpstreamServer{}, fmt.Errorf("invalid sdns stamp: %w", err)
+			}
+			server.Path = string(path)
+			if server.Path == "" {
+				server.Path = "/dns-query"
+			}
+		}
+
+		return server, nil
+
+	case stampProtoDNSCrypt:
+		return UpstreamServer{}, fmt.Errorf("sdns stamp: DNSCrypt is not supported")
+
+	default:
+		return UpstreamServer{}, fmt.Errorf("sdns stamp: unsupported protocol type %#x", protocol)
+	}
+}
+
+// stampProtocolName maps a DNS Stamp protocol byte to its UpstreamServer
+// protocol name and default port.
+func stampProtocolName(t byte) (protocol string, defaultPort int) {
+	switch t {
+	case stampProtoDoH:
+		return "https", 443
+	case stampProtoDoQ:
+		return "quic", 853
+	default:
+		return "tls", 853
+	}
+}
+
+// readLP reads a single length-prefixed field (1 length byte followed by
+// that many bytes) from the front of b.
+func readLP(b []byte) (value []byte, rest []byte, err error) {
+	if len(b) == 0 {
+		return nil, nil, fmt.Errorf("unexpected end of stamp")
+	}
+	n := int(b[0])
+	if len(b) < 1+n {
+		return nil, nil, fmt.Errorf("truncated stamp field")
+	}
+	return b[1 : 1+n], b[1+n:], nil
+}
+
+// readVLP reads a variable-length array of LP fields (e.g. cert hashes),
+// where the high bit of each length byte signals another field follows.
+func readVLP(b []byte) (values [][]byte, rest []byte, err error) {
+	for {
+		if len(b) == 0 {
+			return nil, nil, fmt.Errorf("unexpected end of stamp")
+		}
+		n := int(b[0] &^ 0x80)
+		more := b[0]&0x80 != 0
+		if len(b) < 1+n {
+			return nil, nil, fmt.Errorf("truncated stamp field")
+		}
+		values = append(values, b[1:1+n])
+		b = b[1+n:]
+		if !more {
+			return values, b, nil
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package config
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestParseUpstreamSpec(t *testing.T) {
+	tests := []struct {
+		name        string
+		spec        string
+		want        UpstreamServer
+		expectError bool
+	}{
+		{
+			name: "bare host and port defaults to udp",
+			spec: "9.9.9.9:53",
+			want: UpstreamServer{Address: "9.9.9.9", Protocol: "udp", Port: 53, Verify: true},
+		},
+		{
+			name: "bare host with no port defaults to 53",
+			spec: "9.9.9.9",
+			want: UpstreamServer{Address: "9.9.9.9", Protocol: "udp", Port: 53, Verify: true},
+		},
+		{
+			name: "udp scheme",
+			spec: "udp://9.9.9.9:5353",
+			want: UpstreamServer{Address: "9.9.9.9", Protocol: "udp", Port: 5353, Verify: true},
+		},
+		{
+			name: "tcp scheme",
+			spec: "tcp://9.9.9.9",
+			want: UpstreamServer{Address: "9.9.9.9", Protocol: "tcp", Port: 53, Verify: true},
+		},
+		{
+			name: "tls scheme defaults to port 853",
+			spec: "tls://1.1.1.1",
+			want: UpstreamServer{Address: "1.1.1.1", Protocol: "tls", Port: 853, Verify: true},
+		},
+		{
+			name: "quic scheme defaults to port 853",
+			spec: "quic://dns.adguard.com",
+			want: UpstreamServer{Address: "dns.adguard.com", Protocol: "quic", Port: 853, Verify: true},
+		},
+		{
+			name: "https scheme defaults to port 443 and /dns-query",
+			spec: "https://cloudflare-dns.com/dns-query",
+			want: UpstreamServer{Address: "cloudflare-dns.com", Protocol: "https", Port: 443, Path: "/dns-query", Verify: true},
+		},
+		{
+			name: "https scheme with explicit path",
+			spec: "https://dns.google/resolve",
+			want: UpstreamServer{Address: "dns.google", Protocol: "https", Port: 443, Path: "/resolve", Verify: true},
+		},
+		{
+			name:        "unsupported scheme",
+			spec:        "ftp://9.9.9.9",
+			expectError: true,
+		},
+		{
+			name:        "scheme with no host",
+			spec:        "tls://",
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseUpstreamSpec(tt.spec)
+			if tt.expectError {
+				if err == nil {
+					t.Fatalf("expected an error for spec %q, got none", tt.spec)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error for spec %q: %v", tt.spec, err)
+			}
+			if got != tt.want {
+				t.Errorf("ParseUpstreamSpec(%q) = %+v, want %+v", tt.spec, got, tt.want)
+			}
+		})
+	}
+}
+
+// buildStamp hand-encodes a DNS Stamp per the wire format documented at
+// https://dnscrypt.info/stamps-specifications, for round-trip testing
+// parseDNSStamp without depending on any external stamp source.
+func buildStamp(protocol byte, addr string, hashes [][]byte, hostname, path string) string {
+	var raw []byte
+	raw = append(raw, protocol)
+	raw = append(raw, make([]byte, 8)...) // props, unused by the parser
+
+	appendLP := func(v []byte) {
+		raw = append(raw, byte(len(v)))
+		raw = append(raw, v...)
+	}
+
+	appendLP([]byte(addr))
+
+	if protocol == stampProtoDoH || protocol == stampProtoTLS || protocol == stampProtoDoQ {
+		if len(hashes) == 0 {
+			raw = append(raw, 0x00)
+		} else {
+			for i, h := range hashes {
+				b := byte(len(h))
+				if i != len(hashes)-1 {
+					b |= 0x80
+				}
+				raw = append(raw, b)
+				raw = append(raw, h...)
+			}
+		}
+		appendLP([]byte(hostname))
+		if protocol == stampProtoDoH {
+			appendLP([]byte(path))
+		}
+	}
+
+	return "sdns://" + base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func TestParseDNSStampPlain(t *testing.T) {
+	stamp := buildStamp(stampProtoPlain, "9.9.9.9:53", nil, "", "")
+
+	got, err := ParseUpstreamSpec(stamp)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := UpstreamServer{Address: "9.9.9.9", Protocol: "udp", Port: 53, Verify: true}
+	if got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestParseDNSStampDoH(t *testing.T) {
+	stamp := buildStamp(stampProtoDoH, "149.112.112.112:443", [][]byte{{0xAA, 0xBB}}, "dns.quad9.net", "/dns-query")
+
+	got, err := ParseUpstreamSpec(stamp)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := UpstreamServer{Address: "dns.quad9.net", Protocol: "https", Port: 443, Path: "/dns-query", Verify: true}
+	if got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestParseDNSStampDoT(t *testing.T) {
+	stamp := buildStamp(stampProtoTLS, "1.1.1.1:853", nil, "cloudflare-dns.com", "")
+
+	got, err := ParseUpstreamSpec(stamp)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := UpstreamServer{Address: "cloudflare-dns.com", Protocol: "tls", Port: 853, Verify: true}
+	if got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestParseDNSStampDoQ(t *testing.T) {
+	stamp := buildStamp(stampProtoDoQ, "", nil, "dns.adguard.com:8853", "")
+
+	got, err := ParseUpstreamSpec(stamp)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := UpstreamServer{Address: "dns.adguard.com", Protocol: "quic", Port: 8853, Verify: true}
+	if got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestParseDNSStampRejectsDNSCrypt(t *testing.T) {
+	stamp := buildStamp(stampProtoDNSCrypt, "9.9.9.9:443", nil, "", "")
+
+	if _, err := ParseUpstreamSpec(stamp); err == nil {
+		t.Fatal("expected an error for a DNSCrypt stamp")
+	}
+}
+
+func TestParseDNSStampInvalidBase64(t *testing.T) {
+	if _, err := ParseUpstreamSpec("sdns://not-valid-base64!!!"); err == nil {
+		t.Fatal("expected an error for invalid base64")
+	}
+}
+
+func TestParseDNSStampTooShort(t *testing.T) {
+	if _, err := ParseUpstreamSpec("sdns://" + base64.RawURLEncoding.EncodeToString([]byte{0x02})); err == nil {
+		t.Fatal("expected an error for a truncated stamp")
+	}
+}
+
+func TestUpstreamServerUnmarshalYAMLString(t *testing.T) {
+	var u UpstreamServer
+	if err := yaml.Unmarshal([]byte(`"tls://1.1.1.1"`), &u); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := UpstreamServer{Address: "1.1.1.1", Protocol: "tls", Port: 853, Verify: true}
+	if u != want {
+		t.Errorf("got %+v, want %+v", u, want)
+	}
+}
+
+func TestUpstreamServerUnmarshalYAMLStruct(t *testing.T) {
+	var u UpstreamServer
+	yamlDoc := `
+address: "9.9.9.9"
+protocol: "udp"
+port: 53
+`
+	if err := yaml.Unmarshal([]byte(yamlDoc), &u); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := UpstreamServer{Address: "9.9.9.9", Protocol: "udp", Port: 53}
+	if u != want {
+		t.Errorf("got %+v, want %+v", u, want)
+	}
+}
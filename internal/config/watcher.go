@@ -0,0 +1,116 @@
+package config
+
+import (
+	"log"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Watcher watches a config file or directory for changes and reloads,
+// pushing the new *Config to subscribers.
+type Watcher struct {
+	path    string
+	watcher *fsnotify.Watcher
+
+	mu          sync.Mutex
+	subscribers []chan *Config
+
+	stopOnce sync.Once
+	stopCh   chan struct{}
+}
+
+// NewWatcher creates a Watcher for path (a single YAML file or a config
+// fragment directory).
+func NewWatcher(path string) (*Watcher, error) {
+	fsw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := fsw.Add(path); err != nil {
+		fsw.Close()
+		return nil, err
+	}
+
+	return &Watcher{
+		path:    path,
+		watcher: fsw,
+		stopCh:  make(chan struct{}),
+	}, nil
+}
+
+// Subscribe returns a channel that receives the newly loaded Config after
+// each change. The channel is buffered by one slot; a slow subscriber drops
+// stale reloads rather than blocking the watcher.
+func (w *Watcher) Subscribe() <-chan *Config {
+	ch := make(chan *Config, 1)
+
+	w.mu.Lock()
+	w.subscribers = append(w.subscribers, ch)
+	w.mu.Unlock()
+
+	return ch
+}
+
+// Start begins watching for filesystem events in the background.
+func (w *Watcher) Start() {
+	go w.run()
+}
+
+func (w *Watcher) run() {
+	for {
+		select {
+		case event, ok := <-w.watcher.Events:
+			if !ok {
+				return
+			}
+			if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Remove|fsnotify.Rename) == 0 {
+				continue
+			}
+
+			cfg, err := LoadConfig(w.path)
+			if err != nil {
+				log.Printf("config watcher: reload of %s failed: %v", w.path, err)
+				continue
+			}
+
+			w.publish(cfg)
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Printf("config watcher: %v", err)
+		case <-w.stopCh:
+			return
+		}
+	}
+}
+
+func (w *Watcher) publish(cfg *Config) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for _, ch := range w.subscribers {
+		select {
+		case ch <- cfg:
+		default:
+			// Subscriber hasn't drained the previous reload yet; replace
+			// that stale one with this one rather than block the watcher.
+			select {
+			case <-ch:
+			default:
+			}
+			select {
+			case ch <- cfg:
+			default:
+			}
+		}
+	}
+}
+
+// Stop terminates the watcher and closes the underlying fsnotify watcher.
+func (w *Watcher) Stop() error {
+	w.stopOnce.Do(func() { close(w.stopCh) })
+	return w.watcher.Close()
+}
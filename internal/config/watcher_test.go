@@ -0,0 +1,53 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatcherReloadsOnFileChange(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+
+	initial := `
+server:
+  port: 8053
+  address: "127.0.0.1"
+log_level: "info"
+home_dns_domain: "home"
+`
+	if err := os.WriteFile(path, []byte(initial), 0644); err != nil {
+		t.Fatalf("failed to write initial config: %v", err)
+	}
+
+	watcher, err := NewWatcher(path)
+	if err != nil {
+		t.Fatalf("NewWatcher failed: %v", err)
+	}
+	defer watcher.Stop()
+
+	updates := watcher.Subscribe()
+	watcher.Start()
+
+	updated := `
+server:
+  port: 9053
+  address: "127.0.0.1"
+log_level: "info"
+home_dns_domain: "home"
+`
+	if err := os.WriteFile(path, []byte(updated), 0644); err != nil {
+		t.Fatalf("failed to write updated config: %v", err)
+	}
+
+	select {
+	case cfg := <-updates:
+		if cfg.Server.Port != 9053 {
+			t.Errorf("expected reloaded port 9053, got %d", cfg.Server.Port)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for config reload")
+	}
+}
@@ -0,0 +1,286 @@
+package dns
+
+import (
+	"container/list"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/risadams/Pocket-Concierge/internal/config"
+	"github.com/risadams/Pocket-Concierge/internal/querylog"
+)
+
+// answerCacheEntry holds one cached resolver-chain response (positive or
+// negative), the TTL it was cached with, and how often it's been served
+// since, so cacheResolver can recompute remaining lifetime and decide when
+// an entry is hot enough to prefetch.
+type answerCacheEntry struct {
+	key      string
+	rcode    int
+	answer   []dns.RR
+	ns       []dns.RR
+	ttl      uint32
+	cachedAt time.Time
+
+	hits        int
+	prefetching bool
+}
+
+func (e *answerCacheEntry) remaining() time.Duration {
+	return time.Until(e.cachedAt.Add(time.Duration(e.ttl) * time.Second))
+}
+
+// cacheElem is the value stored in answerCache.order, so the LRU list can
+// find an entry's map key without a reverse index.
+type cacheElem struct {
+	key   string
+	entry *answerCacheEntry
+}
+
+// answerCache is an in-memory, LRU-bounded cache of resolver-chain
+// responses keyed by qname+qtype+qclass, so a repeated question doesn't
+// re-enter the conditional/client-name/upstream stages within its answer's
+// lifetime. Both positive answers and negative (NXDOMAIN/NODATA) results
+// are cached, per RFC 2308.
+type answerCache struct {
+	cfg config.CachingConfig
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List // front = most recently used
+
+	hitCount      int64
+	missCount     int64
+	prefetchCount int64
+}
+
+func newAnswerCache(cfg config.CachingConfig) *answerCache {
+	return &answerCache{
+		cfg:     cfg,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+func answerCacheKey(q dns.Question) string {
+	return fmt.Sprintf("%s/%d/%d", strings.ToLower(q.Name), q.Qtype, q.Qclass)
+}
+
+// get returns the cached entry for q, if any and not expired, and bumps its
+// recency and hit count. A miss (including an expired entry, which is
+// evicted on the way out) counts toward CacheStats.Misses.
+func (c *answerCache) get(q dns.Question) (*answerCacheEntry, bool) {
+	c.mu.Lock()
+	key := answerCacheKey(q)
+	elem, ok := c.entries[key]
+	var entry *answerCacheEntry
+	if ok {
+		entry = elem.Value.(*cacheElem).entry
+		if entry.remaining() <= 0 {
+			c.order.Remove(elem)
+			delete(c.entries, key)
+			ok, entry = false, nil
+		} else {
+			entry.hits++
+			c.order.MoveToFront(elem)
+		}
+	}
+	c.mu.Unlock()
+
+	if !ok {
+		atomic.AddInt64(&c.missCount, 1)
+		return nil, false
+	}
+	atomic.AddInt64(&c.hitCount, 1)
+	return entry, true
+}
+
+// set caches (rcode, answer, ns) for q until ttl elapses, evicting the
+// least-recently-used entry if this insert pushes the cache over
+// cfg.MaxEntries. A zero ttl isn't cached.
+func (c *answerCache) set(q dns.Question, rcode int, answer, ns []dns.RR, ttl uint32) {
+	if ttl == 0 {
+		return
+	}
+
+	key := answerCacheKey(q)
+	entry := &answerCacheEntry{key: key, rcode: rcode, answer: answer, ns: ns, ttl: ttl, cachedAt: time.Now()}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		elem.Value.(*cacheElem).entry = entry
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&cacheElem{key: key, entry: entry})
+
+	if max := c.cfg.MaxEntries; max > 0 {
+		for len(c.entries) > max {
+			oldest := c.order.Back()
+			if oldest == nil {
+				break
+			}
+			c.order.Remove(oldest)
+			delete(c.entries, oldest.Value.(*cacheElem).key)
+		}
+	}
+}
+
+// shouldPrefetch reports whether entry is hot enough, and close enough to
+// expiry, to refresh in the background rather than wait for a client to hit
+// the eventual miss. It marks the entry as already prefetching so a refresh
+// is only ever triggered once per entry's lifetime.
+func (c *answerCache) shouldPrefetch(entry *answerCacheEntry) bool {
+	if !c.cfg.Prefetch || entry.prefetching {
+		return false
+	}
+	if entry.hits < c.cfg.PrefetchThreshold {
+		return false
+	}
+	if entry.remaining() > time.Duration(c.cfg.PrefetchWindow)*time.Second {
+		return false
+	}
+
+	entry.prefetching = true
+	atomic.AddInt64(&c.prefetchCount, 1)
+	return true
+}
+
+// maybeStore caches resp if it's a result worth remembering: a positive
+// answer (TTL clamped to [MinTTL, MaxTTL]), or a negative one (NXDOMAIN or
+// NOERROR/NODATA) kept for NegativeTTL. Negative entries use NegativeTTL
+// rather than a per-response SOA minimum, since Strategy.Forward only ever
+// surfaces the answer section of an upstream reply.
+func (c *answerCache) maybeStore(q dns.Question, resp *Response) {
+	if resp.Rcode == dns.RcodeSuccess && len(resp.Answer) > 0 {
+		c.set(q, resp.Rcode, resp.Answer, resp.Ns, clampTTL(c.cfg, minTTL(resp.Answer)))
+		return
+	}
+
+	if c.cfg.NegativeTTL > 0 {
+		c.set(q, resp.Rcode, resp.Answer, resp.Ns, uint32(c.cfg.NegativeTTL))
+	}
+}
+
+// stats snapshots the cache's hit/miss/prefetch counters for Handler.CacheStats.
+func (c *answerCache) stats() CacheStats {
+	c.mu.Lock()
+	entries := len(c.entries)
+	c.mu.Unlock()
+
+	return CacheStats{
+		Hits:       atomic.LoadInt64(&c.hitCount),
+		Misses:     atomic.LoadInt64(&c.missCount),
+		Prefetches: atomic.LoadInt64(&c.prefetchCount),
+		Entries:    entries,
+	}
+}
+
+// CacheStats summarizes the response-caching stage's activity, for
+// Handler.CacheStats and server.GetStats.
+type CacheStats struct {
+	Hits       int64
+	Misses     int64
+	Prefetches int64
+	Entries    int
+}
+
+// minTTL returns the lowest Hdr.Ttl among answer, the RFC-correct bound on
+// how long the whole answer set may be cached.
+func minTTL(answer []dns.RR) uint32 {
+	var min uint32
+	for i, rr := range answer {
+		ttl := rr.Header().Ttl
+		if i == 0 || ttl < min {
+			min = ttl
+		}
+	}
+	return min
+}
+
+// clampTTL bounds ttl to [cfg.MinTTL, cfg.MaxTTL]. A zero MaxTTL leaves the
+// upper bound unclamped.
+func clampTTL(cfg config.CachingConfig, ttl uint32) uint32 {
+	if cfg.MinTTL > 0 && ttl < uint32(cfg.MinTTL) {
+		ttl = uint32(cfg.MinTTL)
+	}
+	if cfg.MaxTTL > 0 && ttl > uint32(cfg.MaxTTL) {
+		ttl = uint32(cfg.MaxTTL)
+	}
+	return ttl
+}
+
+// rewriteTTLs returns a copy of rrs with every TTL set to remaining, so a
+// client is never handed a TTL longer than how long the cached answer is
+// actually still valid for.
+func rewriteTTLs(rrs []dns.RR, remaining uint32) []dns.RR {
+	out := make([]dns.RR, len(rrs))
+	for i, rr := range rrs {
+		cp := dns.Copy(rr)
+		cp.Header().Ttl = remaining
+		out[i] = cp
+	}
+	return out
+}
+
+// cacheResolver answers a question from the answer cache when a fresh
+// entry exists (triggering a background prefetch if the entry is hot and
+// close to expiry), otherwise defers to Next and caches whatever comes
+// back. It's a no-op pass-through when caching is disabled.
+type cacheResolver struct {
+	baseResolver
+	cache *answerCache
+}
+
+func newCacheResolver(cfg config.CachingConfig) *cacheResolver {
+	return &cacheResolver{cache: newAnswerCache(cfg)}
+}
+
+func (r *cacheResolver) Resolve(h *Handler, req *Request) (*Response, error) {
+	if !r.cache.cfg.Enabled {
+		return r.resolveNext(h, req)
+	}
+
+	if entry, ok := r.cache.get(req.Question); ok {
+		if r.cache.shouldPrefetch(entry) {
+			go r.prefetch(h, req)
+		}
+
+		remaining := uint32(entry.remaining().Seconds())
+		if remaining == 0 {
+			remaining = 1
+		}
+
+		answer := rewriteTTLs(entry.answer, remaining)
+		h.resolver.LogQuery(req.Client, req.Question, &dns.Msg{Answer: answer}, "", querylog.SourceCache, 0)
+		return &Response{Rcode: entry.rcode, Answer: answer, Ns: entry.ns, Resolved: true}, nil
+	}
+
+	resp, err := r.resolveNext(h, req)
+	if err != nil || resp == nil {
+		return resp, err
+	}
+
+	if h.config.DNS.EnableRecursion {
+		r.cache.maybeStore(req.Question, resp)
+	}
+	return resp, nil
+}
+
+// prefetch re-runs the rest of the chain in the background and refreshes
+// the cache entry with whatever it returns, so the next access sees a
+// fresh answer instead of paying the miss latency the original caller
+// would have hit once the entry expired.
+func (r *cacheResolver) prefetch(h *Handler, req *Request) {
+	resp, err := r.resolveNext(h, req)
+	if err != nil || resp == nil {
+		return
+	}
+	r.cache.maybeStore(req.Question, resp)
+}
@@ -0,0 +1,176 @@
+package dns
+
+import (
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/risadams/Pocket-Concierge/internal/config"
+)
+
+func aAnswer(name string, ttl uint32) []dns.RR {
+	return []dns.RR{&dns.A{
+		Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: ttl},
+	}}
+}
+
+func aQuestion(name string) dns.Question {
+	return dns.Question{Name: name, Qtype: dns.TypeA, Qclass: dns.ClassINET}
+}
+
+func TestAnswerCacheGetMissOnEmptyCache(t *testing.T) {
+	c := newAnswerCache(config.CachingConfig{Enabled: true})
+
+	if _, ok := c.get(aQuestion("example.com.")); ok {
+		t.Fatal("expected a miss on an empty cache")
+	}
+	if stats := c.stats(); stats.Misses != 1 {
+		t.Errorf("expected 1 recorded miss, got %d", stats.Misses)
+	}
+}
+
+func TestAnswerCacheSetThenGetHits(t *testing.T) {
+	c := newAnswerCache(config.CachingConfig{Enabled: true})
+	answer := aAnswer("example.com.", 300)
+
+	c.set(aQuestion("example.com."), dns.RcodeSuccess, answer, nil, 300)
+
+	entry, ok := c.get(aQuestion("example.com."))
+	if !ok {
+		t.Fatal("expected a cache hit after set")
+	}
+	if len(entry.answer) != 1 {
+		t.Fatalf("expected 1 cached record, got %d", len(entry.answer))
+	}
+	if stats := c.stats(); stats.Hits != 1 {
+		t.Errorf("expected 1 recorded hit, got %d", stats.Hits)
+	}
+}
+
+func TestAnswerCacheSetSkipsZeroTTL(t *testing.T) {
+	c := newAnswerCache(config.CachingConfig{Enabled: true})
+	c.set(aQuestion("example.com."), dns.RcodeSuccess, aAnswer("example.com.", 0), nil, 0)
+
+	if _, ok := c.get(aQuestion("example.com.")); ok {
+		t.Fatal("expected a zero-TTL answer not to be cached")
+	}
+}
+
+func TestAnswerCacheGetMissAfterExpiry(t *testing.T) {
+	c := newAnswerCache(config.CachingConfig{Enabled: true})
+	c.set(aQuestion("example.com."), dns.RcodeSuccess, aAnswer("example.com.", 1), nil, 1)
+
+	elem := c.entries[answerCacheKey(aQuestion("example.com."))].Value.(*cacheElem)
+	elem.entry.cachedAt = time.Now().Add(-time.Hour)
+
+	if _, ok := c.get(aQuestion("example.com.")); ok {
+		t.Fatal("expected an expired entry to be treated as a miss")
+	}
+}
+
+func TestAnswerCacheKeyIsCaseInsensitive(t *testing.T) {
+	if answerCacheKey(aQuestion("Example.COM.")) != answerCacheKey(aQuestion("example.com.")) {
+		t.Fatal("expected answerCacheKey to normalize case")
+	}
+}
+
+func TestAnswerCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := newAnswerCache(config.CachingConfig{Enabled: true, MaxEntries: 2})
+
+	c.set(aQuestion("a.com."), dns.RcodeSuccess, aAnswer("a.com.", 300), nil, 300)
+	c.set(aQuestion("b.com."), dns.RcodeSuccess, aAnswer("b.com.", 300), nil, 300)
+	c.get(aQuestion("a.com.")) // touch a.com. so b.com. becomes the LRU entry
+	c.set(aQuestion("c.com."), dns.RcodeSuccess, aAnswer("c.com.", 300), nil, 300)
+
+	if _, ok := c.get(aQuestion("b.com.")); ok {
+		t.Fatal("expected the least-recently-used entry to have been evicted")
+	}
+	if _, ok := c.get(aQuestion("a.com.")); !ok {
+		t.Fatal("expected the recently-touched entry to survive eviction")
+	}
+}
+
+func TestAnswerCacheMaybeStoreCachesNegativeResult(t *testing.T) {
+	c := newAnswerCache(config.CachingConfig{Enabled: true, NegativeTTL: 30})
+	c.maybeStore(aQuestion("blocked.com."), &Response{Rcode: dns.RcodeNameError, Resolved: true})
+
+	entry, ok := c.get(aQuestion("blocked.com."))
+	if !ok {
+		t.Fatal("expected an NXDOMAIN result to be cached")
+	}
+	if entry.rcode != dns.RcodeNameError {
+		t.Errorf("expected cached rcode NameError, got %d", entry.rcode)
+	}
+}
+
+func TestAnswerCacheMaybeStoreSkipsNegativeResultWithoutNegativeTTL(t *testing.T) {
+	c := newAnswerCache(config.CachingConfig{Enabled: true})
+	c.maybeStore(aQuestion("blocked.com."), &Response{Rcode: dns.RcodeNameError, Resolved: true})
+
+	if _, ok := c.get(aQuestion("blocked.com.")); ok {
+		t.Fatal("expected no negative caching when caching.negative_ttl is unset")
+	}
+}
+
+func TestClampTTLBoundsToMinAndMax(t *testing.T) {
+	cfg := config.CachingConfig{MinTTL: 30, MaxTTL: 300}
+
+	if got := clampTTL(cfg, 10); got != 30 {
+		t.Errorf("expected a TTL below the minimum to be raised to 30, got %d", got)
+	}
+	if got := clampTTL(cfg, 3600); got != 300 {
+		t.Errorf("expected a TTL above the maximum to be lowered to 300, got %d", got)
+	}
+	if got := clampTTL(cfg, 120); got != 120 {
+		t.Errorf("expected a TTL within range to pass through unchanged, got %d", got)
+	}
+}
+
+func TestRewriteTTLsAppliesRemainingLifetime(t *testing.T) {
+	rewritten := rewriteTTLs(aAnswer("example.com.", 300), 42)
+
+	if got := rewritten[0].Header().Ttl; got != 42 {
+		t.Fatalf("expected rewritten TTL 42, got %d", got)
+	}
+	if original := aAnswer("example.com.", 300)[0].Header().Ttl; original != 300 {
+		t.Error("expected rewriteTTLs not to mutate its input")
+	}
+}
+
+func TestShouldPrefetchRequiresThresholdAndWindow(t *testing.T) {
+	cfg := config.CachingConfig{Prefetch: true, PrefetchThreshold: 3, PrefetchWindow: 10}
+	c := newAnswerCache(cfg)
+
+	cold := &answerCacheEntry{hits: 1, ttl: 300, cachedAt: time.Now()}
+	if c.shouldPrefetch(cold) {
+		t.Error("expected an entry below the hit threshold not to be prefetched")
+	}
+
+	farFromExpiry := &answerCacheEntry{hits: 5, ttl: 300, cachedAt: time.Now()}
+	if c.shouldPrefetch(farFromExpiry) {
+		t.Error("expected an entry far from expiry not to be prefetched")
+	}
+
+	eligible := &answerCacheEntry{hits: 5, ttl: 5, cachedAt: time.Now()}
+	if !c.shouldPrefetch(eligible) {
+		t.Error("expected a hot, soon-to-expire entry to be prefetched")
+	}
+	if !eligible.prefetching {
+		t.Error("expected shouldPrefetch to mark the entry as prefetching")
+	}
+	if c.shouldPrefetch(eligible) {
+		t.Error("expected an already-prefetching entry not to be prefetched again")
+	}
+}
+
+func TestMinTTLReturnsLowestAmongRecords(t *testing.T) {
+	answer := []dns.RR{
+		&dns.A{Hdr: dns.RR_Header{Ttl: 300}},
+		&dns.A{Hdr: dns.RR_Header{Ttl: 60}},
+		&dns.A{Hdr: dns.RR_Header{Ttl: 120}},
+	}
+
+	if got := minTTL(answer); got != 60 {
+		t.Fatalf("expected minTTL 60, got %d", got)
+	}
+}
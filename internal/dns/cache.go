@@ -4,66 +4,166 @@ import (
 	"net"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/miekg/dns"
 	"github.com/risadams/Pocket-Concierge/internal/config"
 )
 
-// HostCache provides fast hostname lookups
+// HostCache provides fast hostname lookups, built from cfg.Hosts plus any
+// hosts(5)-format files and URLs configured via cfg.HostsFiles/HostsURLs,
+// and the platform's system hosts file, e.g. /etc/hosts (see hostsfile.go).
+// When the same hostname is defined by more than one source, precedence is
+// cfg.Hosts (YAML) > HostsFiles (local) > system hosts file > HostsURLs
+// (remote): Rebuild claims a hostname from the first source that defines
+// it and skips it in every source after.
 type HostCache struct {
-	hosts   map[string]*config.HostEntry
-	records map[string][]dns.RR
-	mutex   sync.RWMutex
+	hosts     map[string]*config.HostEntry
+	records   map[string][]dns.RR
+	wildcards []wildcardHost
+	mutex     sync.RWMutex
+
+	cfg *config.Config
+
+	urlEntries        map[string][]config.HostEntry
+	urlMutex          sync.Mutex
+	urlRefreshStarted map[string]bool
+	urlRefreshMu      sync.Mutex
+
+	stopOnce sync.Once
+	stopCh   chan struct{}
+}
+
+// wildcardHost is a "*.suffix" host entry: it matches suffix itself and
+// any subdomain of it, with records built on demand (LookupRecords can't
+// precompute a cache entry per possible subdomain).
+type wildcardHost struct {
+	suffix string
+	entry  *config.HostEntry
+	ttl    int
 }
 
-// NewHostCache creates an optimized host cache
+// NewHostCache creates an optimized host cache for cfg, and begins
+// refreshing any configured HostsURLs in the background.
 func NewHostCache(cfg *config.Config) *HostCache {
 	cache := &HostCache{
-		hosts:   make(map[string]*config.HostEntry),
-		records: make(map[string][]dns.RR),
+		hosts:      make(map[string]*config.HostEntry),
+		records:    make(map[string][]dns.RR),
+		urlEntries: make(map[string][]config.HostEntry),
+		stopCh:     make(chan struct{}),
 	}
 	cache.Rebuild(cfg)
 	return cache
 }
 
-// Rebuild updates the cache with current config
-func (hc *HostCache) Rebuild(cfg *config.Config) {
-	hc.mutex.Lock()
-	defer hc.mutex.Unlock()
-
-	// Clear existing caches
-	hc.hosts = make(map[string]*config.HostEntry)
-	hc.records = make(map[string][]dns.RR)
+// currentConfig returns the config last passed to Rebuild.
+func (hc *HostCache) currentConfig() *config.Config {
+	hc.mutex.RLock()
+	defer hc.mutex.RUnlock()
+	return hc.cfg
+}
 
+// Rebuild updates the cache with the current config: cfg.Hosts, every
+// cfg.HostsFiles entry, the platform's system hosts file, and the most
+// recently fetched entries for every cfg.HostsURLs (kicking off that
+// URL's background refresh the first time Rebuild sees it). File and
+// network reads happen before the cache is swapped in, so concurrent
+// lookups are never blocked on I/O.
+func (hc *HostCache) Rebuild(cfg *config.Config) {
 	homeDomain := cfg.HomeDNSDomain
 	if homeDomain == "" {
 		homeDomain = "home"
 	}
 
-	// Build optimized lookup table
+	hosts := make(map[string]*config.HostEntry)
+	records := make(map[string][]dns.RR)
+	var wildcards []wildcardHost
+	claimed := make(map[string]bool)
+
+	addEntry := func(host *config.HostEntry) {
+		name := strings.ToLower(strings.TrimSuffix(host.Hostname, "."))
+		if claimed[name] {
+			return
+		}
+		claimed[name] = true
+		addHostEntry(hosts, records, &wildcards, host, homeDomain, cfg.DNS.TTL)
+	}
+
+	// Precedence: YAML (cfg.Hosts) > local hosts files > remote hosts URLs.
 	for i := range cfg.Hosts {
-		host := &cfg.Hosts[i]
-		normalizedName := strings.ToLower(strings.TrimSuffix(host.Hostname, "."))
-
-		// Determine the full hostname (add domain if not present)
-		var fullHostname string
-		if strings.Contains(normalizedName, ".") {
-			// Already has a domain
-			fullHostname = normalizedName
-		} else {
-			// Add the home domain
-			fullHostname = normalizedName + "." + homeDomain
+		addEntry(&cfg.Hosts[i])
+	}
+
+	for _, path := range cfg.HostsFiles {
+		entries, err := hc.LoadHostsFile(path)
+		if err != nil {
+			continue // best-effort: a missing/unreadable file shouldn't break the rest of the cache
 		}
+		for i := range entries {
+			addEntry(&entries[i])
+		}
+	}
 
-		// Store with multiple variations for fast lookup
-		hc.hosts[normalizedName] = host
-		hc.hosts[normalizedName+"."] = host
-		hc.hosts[fullHostname] = host
-		hc.hosts[fullHostname+"."] = host
+	// The platform's system hosts file is always consulted, below any
+	// explicitly configured HostsFiles: many systems won't have one
+	// readable (e.g. a minimal container), so a missing/unreadable file
+	// here is silently ignored rather than treated as a config error.
+	if entries, err := hc.LoadHostsFile(defaultSystemHostsPath()); err == nil {
+		for i := range entries {
+			addEntry(&entries[i])
+		}
+	}
 
-		// Build DNS records for fast resolution
-		hc.buildRecords(normalizedName, fullHostname, host, cfg.DNS.TTL)
+	interval := time.Duration(cfg.HostsRefreshInterval) * time.Second
+	for _, url := range cfg.HostsURLs {
+		// Best-effort: a URL that's never reachable just contributes no
+		// entries; it keeps retrying on its own background schedule. The
+		// first time a URL is seen this also performs its initial fetch,
+		// so the entries below are already available on this very call.
+		_ = hc.startURLRefresh(url, interval)
+		entries := hc.urlEntriesSnapshot(url)
+		for i := range entries {
+			addEntry(&entries[i])
+		}
 	}
+
+	hc.mutex.Lock()
+	hc.cfg = cfg
+	hc.hosts = hosts
+	hc.records = records
+	hc.wildcards = wildcards
+	hc.mutex.Unlock()
+}
+
+// addHostEntry registers host into hosts/records under its normalized and
+// full (domain-qualified) names, or appends it to *wildcards if its
+// hostname is of the form "*.suffix".
+func addHostEntry(hosts map[string]*config.HostEntry, records map[string][]dns.RR, wildcards *[]wildcardHost, host *config.HostEntry, homeDomain string, ttl int) {
+	normalizedName := strings.ToLower(strings.TrimSuffix(host.Hostname, "."))
+
+	if suffix, ok := strings.CutPrefix(normalizedName, "*."); ok {
+		*wildcards = append(*wildcards, wildcardHost{suffix: suffix, entry: host, ttl: ttl})
+		return
+	}
+
+	// Determine the full hostname (add domain if not present)
+	var fullHostname string
+	if strings.Contains(normalizedName, ".") {
+		// Already has a domain
+		fullHostname = normalizedName
+	} else {
+		// Add the home domain
+		fullHostname = normalizedName + "." + homeDomain
+	}
+
+	// Store with multiple variations for fast lookup
+	hosts[normalizedName] = host
+	hosts[normalizedName+"."] = host
+	hosts[fullHostname] = host
+	hosts[fullHostname+"."] = host
+
+	// Build DNS records for fast resolution
+	buildRecordsInto(records, normalizedName, fullHostname, host, ttl)
 }
 
 // Lookup finds a host entry quickly
@@ -72,12 +172,37 @@ func (hc *HostCache) Lookup(hostname string) (*config.HostEntry, bool) {
 	defer hc.mutex.RUnlock()
 
 	normalizedName := strings.ToLower(strings.TrimSpace(hostname))
-	host, found := hc.hosts[normalizedName]
-	return host, found
+	if host, found := hc.hosts[normalizedName]; found {
+		return host, true
+	}
+	if w, ok := matchWildcard(hc.wildcards, strings.TrimSuffix(normalizedName, ".")); ok {
+		return w.entry, true
+	}
+	return nil, false
 }
 
-// buildRecords creates DNS records for a host entry
-func (hc *HostCache) buildRecords(shortName, fullHostname string, host *config.HostEntry, ttl int) {
+// matchWildcard returns the most specific "*.suffix" wildcard whose suffix
+// matches name (name itself, or any subdomain of it), if any.
+func matchWildcard(wildcards []wildcardHost, name string) (wildcardHost, bool) {
+	var best wildcardHost
+	found := false
+	for _, w := range wildcards {
+		if name != w.suffix && !strings.HasSuffix(name, "."+w.suffix) {
+			continue
+		}
+		if !found || len(w.suffix) > len(best.suffix) {
+			best = w
+			found = true
+		}
+	}
+	return best, found
+}
+
+// buildRecordsInto creates DNS records for a host entry into records. It
+// takes records explicitly (rather than being an HostCache method) so
+// Rebuild can build a whole new generation of records before taking
+// hc.mutex to swap it in, keeping file/network I/O off the lock.
+func buildRecordsInto(records map[string][]dns.RR, shortName, fullHostname string, host *config.HostEntry, ttl int) {
 	// Build A records for IPv4 addresses
 	for _, ipv4 := range host.IPv4 {
 		ip := net.ParseIP(ipv4)
@@ -93,8 +218,10 @@ func (hc *HostCache) buildRecords(shortName, fullHostname string, host *config.H
 			},
 			A: ip.To4(),
 		}
-		hc.records[strings.ToLower(fullHostname)+":A"] = append(hc.records[strings.ToLower(fullHostname)+":A"], rr)
-		hc.records[strings.ToLower(shortName)+":A"] = append(hc.records[strings.ToLower(shortName)+":A"], rr)
+		records[strings.ToLower(fullHostname)+":A"] = append(records[strings.ToLower(fullHostname)+":A"], rr)
+		if shortName != fullHostname {
+			records[strings.ToLower(shortName)+":A"] = append(records[strings.ToLower(shortName)+":A"], rr)
+		}
 	}
 
 	// Build AAAA records for IPv6 addresses
@@ -112,11 +239,49 @@ func (hc *HostCache) buildRecords(shortName, fullHostname string, host *config.H
 			},
 			AAAA: ip.To16(),
 		}
-		hc.records[strings.ToLower(fullHostname)+":AAAA"] = append(hc.records[strings.ToLower(fullHostname)+":AAAA"], rr)
-		hc.records[strings.ToLower(shortName)+":AAAA"] = append(hc.records[strings.ToLower(shortName)+":AAAA"], rr)
+		records[strings.ToLower(fullHostname)+":AAAA"] = append(records[strings.ToLower(fullHostname)+":AAAA"], rr)
+		if shortName != fullHostname {
+			records[strings.ToLower(shortName)+":AAAA"] = append(records[strings.ToLower(shortName)+":AAAA"], rr)
+		}
+	}
+
+	// Build PTR records for reverse lookups, keyed by the standard
+	// in-addr.arpa./ip6.arpa. name for each configured address. Hosts are
+	// never cleared between entries sharing an IP (Rebuild builds the map
+	// once, from scratch), so LookupPTR naturally returns every matching PTR.
+	for _, ip := range append(append([]string{}, host.IPv4...), host.IPv6...) {
+		addPTRInto(records, ip, fullHostname, ttl)
 	}
 }
 
+// addPTRInto registers a PTR record for ip pointing at fullHostname's FQDN
+// form, under the standard reverse-zone name miekg/dns derives for it.
+func addPTRInto(records map[string][]dns.RR, ip, fullHostname string, ttl int) {
+	reverseName, err := dns.ReverseAddr(ip)
+	if err != nil {
+		return
+	}
+
+	rr := &dns.PTR{
+		Hdr: dns.RR_Header{
+			Name:   reverseName,
+			Rrtype: dns.TypePTR,
+			Class:  dns.ClassINET,
+			Ttl:    uint32(ttl),
+		},
+		Ptr: dns.Fqdn(fullHostname),
+	}
+
+	key := ptrKey(reverseName)
+	records[key] = append(records[key], rr)
+}
+
+// ptrKey normalizes a reverse-zone name into the records map key, matching
+// the trailing-dot-insensitive convention LookupRecords uses for A/AAAA.
+func ptrKey(reverseName string) string {
+	return strings.ToLower(strings.TrimSuffix(reverseName, ".")) + ":PTR"
+}
+
 // LookupRecords finds DNS records for a hostname and query type
 func (hc *HostCache) LookupRecords(hostname string, qtype uint16) []dns.RR {
 	hc.mutex.RLock()
@@ -139,5 +304,54 @@ func (hc *HostCache) LookupRecords(hostname string, qtype uint16) []dns.RR {
 		return records
 	}
 
+	if w, ok := matchWildcard(hc.wildcards, normalizedName); ok {
+		return recordsFromEntry(normalizedName, qtype, w.entry, w.ttl)
+	}
+
 	return nil
 }
+
+// recordsFromEntry builds A/AAAA records for name from host's configured
+// addresses on demand. Unlike buildRecordsInto, it isn't precomputed into
+// the records map: a wildcard entry matches arbitrarily many query names,
+// so there's no fixed set of cache keys to populate ahead of time.
+func recordsFromEntry(name string, qtype uint16, host *config.HostEntry, ttl int) []dns.RR {
+	var out []dns.RR
+
+	switch qtype {
+	case dns.TypeA:
+		for _, ipv4 := range host.IPv4 {
+			ip := net.ParseIP(ipv4)
+			if ip == nil || ip.To4() == nil {
+				continue
+			}
+			out = append(out, &dns.A{
+				Hdr: dns.RR_Header{Name: dns.Fqdn(name), Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: uint32(ttl)},
+				A:   ip.To4(),
+			})
+		}
+	case dns.TypeAAAA:
+		for _, ipv6 := range host.IPv6 {
+			ip := net.ParseIP(ipv6)
+			if ip == nil {
+				continue
+			}
+			out = append(out, &dns.AAAA{
+				Hdr:  dns.RR_Header{Name: dns.Fqdn(name), Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: uint32(ttl)},
+				AAAA: ip.To16(),
+			})
+		}
+	}
+
+	return out
+}
+
+// LookupPTR finds PTR records for reverseName, the standard
+// in-addr.arpa./ip6.arpa. qname a reverse-lookup query asks about. If
+// multiple hosts share the same IP, all of their PTR records are returned.
+func (hc *HostCache) LookupPTR(reverseName string) []dns.RR {
+	hc.mutex.RLock()
+	defer hc.mutex.RUnlock()
+
+	return hc.records[ptrKey(reverseName)]
+}
@@ -251,6 +251,54 @@ func TestHostCache_RecordTTL(t *testing.T) {
 	}
 }
 
+func TestHostCache_LookupPTR(t *testing.T) {
+	cfg := &config.Config{
+		DNS: config.DNSConfig{
+			TTL: 300,
+		},
+		HomeDNSDomain: "home",
+		Hosts: []config.HostEntry{
+			{
+				Hostname: "test1",
+				IPv4:     []string{"192.168.1.1"},
+				IPv6:     []string{"2001:db8::1"},
+			},
+			{
+				Hostname: "test2",
+				IPv4:     []string{"192.168.1.1"}, // shared IP with test1
+			},
+		},
+	}
+
+	cache := NewHostCache(cfg)
+
+	records := cache.LookupPTR("1.1.168.192.in-addr.arpa.")
+	if len(records) != 2 {
+		t.Fatalf("Expected 2 PTR records for a shared IP, got %d", len(records))
+	}
+
+	targets := map[string]bool{}
+	for _, rr := range records {
+		ptr, ok := rr.(*dns.PTR)
+		if !ok {
+			t.Fatalf("Expected a PTR record, got %T", rr)
+		}
+		targets[ptr.Ptr] = true
+	}
+	if !targets["test1.home."] || !targets["test2.home."] {
+		t.Errorf("Expected PTR targets for both hosts, got %v", targets)
+	}
+
+	ipv6Records := cache.LookupPTR("1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa.")
+	if len(ipv6Records) != 1 {
+		t.Fatalf("Expected 1 PTR record for the IPv6 address, got %d", len(ipv6Records))
+	}
+
+	if records := cache.LookupPTR("99.1.168.192.in-addr.arpa."); len(records) != 0 {
+		t.Errorf("Expected 0 PTR records for an unconfigured address, got %d", len(records))
+	}
+}
+
 // Benchmark tests
 func BenchmarkHostCache_Lookup(b *testing.B) {
 	cfg := &config.Config{
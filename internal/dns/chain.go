@@ -0,0 +1,91 @@
+package dns
+
+import (
+	"net"
+
+	"github.com/miekg/dns"
+	"github.com/risadams/Pocket-Concierge/internal/config"
+)
+
+// Request carries per-question state through Handler's resolver chain.
+type Request struct {
+	Client   net.Addr
+	Original *dns.Msg
+	Question dns.Question
+
+	// Upstreams is the upstream list chosen for this question (conditional
+	// routing, falling back to the default list), set by conditionalResolver
+	// before upstreamResolver consumes it.
+	Upstreams []config.UpstreamServer
+
+	// ClientName is the reverse-DNS name of the requesting client, set by
+	// clientNameResolver when one is known, for log enrichment.
+	ClientName string
+}
+
+// Response is what one ChainResolver stage produces for a Request.
+// Resolved is true once a stage has decided the final answer for this
+// question (including an intentional empty/NODATA answer); false is only
+// ever returned by resolveNext when the chain runs out of stages.
+type Response struct {
+	Rcode    int
+	Answer   []dns.RR
+	Ns       []dns.RR
+	Resolved bool
+}
+
+// ChainResolver is one stage of Handler's resolver chain, modeled on
+// blocky's resolver chain: each stage either answers a Request itself or
+// defers to Next. Built-in stages are wired up in NewHandler, so adding a
+// new one (rewrite rules, DNS64, ECS stripping) means writing one more
+// ChainResolver and inserting it into that chain, not editing the others.
+type ChainResolver interface {
+	Resolve(h *Handler, req *Request) (*Response, error)
+	SetNext(next ChainResolver)
+	Next() ChainResolver
+}
+
+// baseResolver provides the Next/SetNext bookkeeping shared by every
+// built-in resolver.
+type baseResolver struct {
+	next ChainResolver
+}
+
+func (b *baseResolver) SetNext(next ChainResolver) { b.next = next }
+func (b *baseResolver) Next() ChainResolver        { return b.next }
+
+// resolveNext defers to the next stage in the chain, or reports the
+// question resolved with an empty (NODATA) answer if this is the last
+// stage.
+func (b *baseResolver) resolveNext(h *Handler, req *Request) (*Response, error) {
+	if b.next == nil {
+		return &Response{Resolved: true}, nil
+	}
+	return b.next.Resolve(h, req)
+}
+
+// buildChain wires up Handler's default resolver chain: DDR discovery,
+// simple domain-list blocking, local hosts/PTR/home-zone answers, grouped
+// blocklist matching, the answer cache, conditional upstream routing,
+// client-name enrichment, and finally upstream forwarding.
+func buildChain(h *Handler) ChainResolver {
+	cache := newCacheResolver(h.config.Caching)
+	h.answerCache = cache.cache
+
+	stages := []ChainResolver{
+		&ddrResolver{},
+		&blockingResolver{},
+		&hostsResolver{},
+		&groupBlockResolver{},
+		cache,
+		&conditionalResolver{},
+		&clientNameResolver{},
+		&upstreamResolver{},
+	}
+
+	for i := 0; i < len(stages)-1; i++ {
+		stages[i].SetNext(stages[i+1])
+	}
+
+	return stages[0]
+}
@@ -0,0 +1,42 @@
+package dns
+
+import (
+	"testing"
+
+	"github.com/risadams/Pocket-Concierge/internal/config"
+)
+
+func TestResolveNextReturnsResolvedOnLastStage(t *testing.T) {
+	b := &baseResolver{}
+
+	resp, err := b.resolveNext(nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !resp.Resolved {
+		t.Fatal("expected the last stage to report the request resolved")
+	}
+}
+
+func TestSetNextRecordsNextStage(t *testing.T) {
+	terminal := &blockingResolver{}
+	b := &baseResolver{}
+	b.SetNext(terminal)
+
+	if b.Next() != terminal {
+		t.Fatal("expected Next to return the stage passed to SetNext")
+	}
+}
+
+func TestBuildChainWiresEveryStage(t *testing.T) {
+	h := NewHandler(&config.Config{HomeDNSDomain: "home"})
+
+	count := 0
+	for stage := h.chain; stage != nil; stage = stage.Next() {
+		count++
+	}
+
+	if count != 8 {
+		t.Fatalf("expected 8 chained stages, got %d", count)
+	}
+}
@@ -0,0 +1,106 @@
+package dns
+
+import (
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/risadams/Pocket-Concierge/internal/config"
+)
+
+// fakePTRUpstream is a minimal UDP DNS server that answers every PTR query
+// with a fixed target, used to exercise resolveClientName's upstream
+// fallback without a real reverse-DNS provider.
+type fakePTRUpstream struct {
+	server *dns.Server
+	conn   net.PacketConn
+	target string
+	hits   int
+}
+
+func newFakePTRUpstream(t *testing.T, target string) *fakePTRUpstream {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	fu := &fakePTRUpstream{conn: conn, target: target}
+	fu.server = &dns.Server{PacketConn: conn, Handler: dns.HandlerFunc(fu.handle)}
+
+	go fu.server.ActivateAndServe()
+	t.Cleanup(func() { fu.server.Shutdown() })
+
+	return fu
+}
+
+func (fu *fakePTRUpstream) handle(w dns.ResponseWriter, r *dns.Msg) {
+	fu.hits++
+	msg := &dns.Msg{}
+	msg.SetReply(r)
+	msg.Answer = append(msg.Answer, &dns.PTR{
+		Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypePTR, Class: dns.ClassINET, Ttl: 300},
+		Ptr: fu.target,
+	})
+	w.WriteMsg(msg)
+}
+
+func (fu *fakePTRUpstream) upstream() config.UpstreamServer {
+	_, portStr, _ := net.SplitHostPort(fu.conn.LocalAddr().String())
+	port, _ := strconv.Atoi(portStr)
+	return config.UpstreamServer{Address: "127.0.0.1", Protocol: "udp", Port: port}
+}
+
+func TestResolveClientNameFallsBackToUpstream(t *testing.T) {
+	fu := newFakePTRUpstream(t, "laptop.lan.")
+
+	cfg := &config.Config{
+		HomeDNSDomain: "home",
+		DNS:           config.DNSConfig{TTL: 300, EnableRecursion: true},
+		Upstream:      []config.UpstreamServer{fu.upstream()},
+	}
+
+	handler := NewHandler(cfg)
+	reverseName, _ := dns.ReverseAddr("192.168.1.50")
+
+	if got := handler.resolveClientName(reverseName); got != "laptop.lan." {
+		t.Errorf("expected laptop.lan., got %q", got)
+	}
+}
+
+func TestResolveClientNameCachesUpstreamResult(t *testing.T) {
+	fu := newFakePTRUpstream(t, "laptop.lan.")
+
+	cfg := &config.Config{
+		HomeDNSDomain: "home",
+		DNS:           config.DNSConfig{TTL: 300, EnableRecursion: true},
+		Caching:       config.CachingConfig{Enabled: true, MaxEntries: 100},
+		Upstream:      []config.UpstreamServer{fu.upstream()},
+	}
+
+	handler := NewHandler(cfg)
+	reverseName, _ := dns.ReverseAddr("192.168.1.51")
+
+	handler.resolveClientName(reverseName)
+	handler.resolveClientName(reverseName)
+
+	if fu.hits != 1 {
+		t.Errorf("expected the second lookup to be served from cache, got %d upstream hits", fu.hits)
+	}
+}
+
+func TestResolveClientNameNoRecursionReturnsEmpty(t *testing.T) {
+	cfg := &config.Config{
+		HomeDNSDomain: "home",
+		DNS:           config.DNSConfig{TTL: 300, EnableRecursion: false},
+	}
+
+	handler := NewHandler(cfg)
+	reverseName, _ := dns.ReverseAddr("192.168.1.52")
+
+	if got := handler.resolveClientName(reverseName); got != "" {
+		t.Errorf("expected empty client name with recursion disabled, got %q", got)
+	}
+}
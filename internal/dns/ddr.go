@@ -0,0 +1,100 @@
+package dns
+
+import (
+	"net"
+	"strings"
+
+	"github.com/miekg/dns"
+	"github.com/risadams/Pocket-Concierge/internal/config"
+)
+
+// ddrQueryName is the well-known name RFC 9462 Discovery of Designated
+// Resolvers queries target to discover a resolver's encrypted endpoints.
+const ddrQueryName = "_dns.resolver.arpa."
+
+// protocolOrder fixes the advertised priority order: DoT, then DoH, then DoQ.
+var protocolOrder = []string{"dot", "doh", "doq"}
+
+// alpnForProtocol maps an encrypted listener protocol to its ALPN token.
+var alpnForProtocol = map[string]string{
+	"dot": "dot",
+	"doh": "h2",
+	"doq": "doq",
+}
+
+// portForProtocol is the default port advertised for each protocol.
+var portForProtocol = map[string]uint16{
+	"dot": 853,
+	"doh": 443,
+	"doq": 853,
+}
+
+// IsDDRQuery reports whether question asks about this server's own
+// designated resolvers, per RFC 9462.
+func IsDDRQuery(name string, qtype uint16) bool {
+	return qtype == dns.TypeSVCB && strings.EqualFold(dns.Fqdn(name), ddrQueryName)
+}
+
+// BuildDDRAnswer synthesizes one SVCB record per enabled encrypted listener
+// protocol, advertising the ALPN, port, and any IP hints clients need to
+// auto-upgrade to DoT/DoH/DoQ.
+func BuildDDRAnswer(cfg *config.Config) []dns.RR {
+	if len(cfg.Encrypted.Protocols) == 0 {
+		return nil
+	}
+
+	target := cfg.DDR.TargetName
+	if target == "" {
+		target = cfg.Server.Address
+	}
+	target = dns.Fqdn(target)
+
+	priority := cfg.DDR.Priority
+	if priority == 0 {
+		priority = 1
+	}
+
+	var ipv4Hint, ipv6Hint net.IP
+	if ip := net.ParseIP(cfg.Server.Address); ip != nil {
+		if v4 := ip.To4(); v4 != nil {
+			ipv4Hint = v4
+		} else {
+			ipv6Hint = ip
+		}
+	}
+
+	var answers []dns.RR
+	for _, protocol := range protocolOrder {
+		if !cfg.Encrypted.Enabled(protocol) {
+			continue
+		}
+
+		values := []dns.SVCBKeyValue{
+			&dns.SVCBAlpn{Alpn: []string{alpnForProtocol[protocol]}},
+			&dns.SVCBPort{Port: portForProtocol[protocol]},
+		}
+
+		if ipv4Hint != nil {
+			values = append(values, &dns.SVCBIPv4Hint{Hint: []net.IP{ipv4Hint}})
+		}
+		if ipv6Hint != nil {
+			values = append(values, &dns.SVCBIPv6Hint{Hint: []net.IP{ipv6Hint}})
+		}
+
+		answers = append(answers, &dns.SVCB{
+			Hdr: dns.RR_Header{
+				Name:   ddrQueryName,
+				Rrtype: dns.TypeSVCB,
+				Class:  dns.ClassINET,
+				Ttl:    uint32(cfg.DNS.TTL),
+			},
+			Priority: priority,
+			Target:   target,
+			Value:    values,
+		})
+
+		priority++
+	}
+
+	return answers
+}
@@ -0,0 +1,111 @@
+package dns
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/risadams/Pocket-Concierge/internal/config"
+)
+
+func TestIsDDRQuery(t *testing.T) {
+	tests := []struct {
+		name     string
+		qname    string
+		qtype    uint16
+		expected bool
+	}{
+		{"matching SVCB query", "_dns.resolver.arpa.", dns.TypeSVCB, true},
+		{"matching without trailing dot", "_dns.resolver.arpa", dns.TypeSVCB, true},
+		{"wrong qtype", "_dns.resolver.arpa.", dns.TypeA, false},
+		{"wrong name", "example.com.", dns.TypeSVCB, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsDDRQuery(tt.qname, tt.qtype); got != tt.expected {
+				t.Errorf("IsDDRQuery(%q, %d) = %v, expected %v", tt.qname, tt.qtype, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestBuildDDRAnswerAllProtocols(t *testing.T) {
+	cfg := &config.Config{
+		Server: config.ServerConfig{Address: "192.168.1.10", Port: 53},
+		DNS:    config.DNSConfig{TTL: 300},
+		Encrypted: config.EncryptedListeners{
+			Protocols: []string{"dot", "doh", "doq"},
+		},
+	}
+
+	answers := BuildDDRAnswer(cfg)
+	if len(answers) != 3 {
+		t.Fatalf("expected 3 SVCB records, got %d", len(answers))
+	}
+
+	wantALPN := map[int]string{0: "dot", 1: "h2", 2: "doq"}
+	wantPort := map[string]uint16{"dot": 853, "h2": 443, "doq": 853}
+
+	for i, rr := range answers {
+		svcb, ok := rr.(*dns.SVCB)
+		if !ok {
+			t.Fatalf("answer %d is not an SVCB record: %T", i, rr)
+		}
+
+		var alpn string
+		var port uint16
+		var hasIPv4Hint bool
+
+		for _, v := range svcb.Value {
+			switch kv := v.(type) {
+			case *dns.SVCBAlpn:
+				alpn = kv.Alpn[0]
+			case *dns.SVCBPort:
+				port = kv.Port
+			case *dns.SVCBIPv4Hint:
+				hasIPv4Hint = len(kv.Hint) == 1 && kv.Hint[0].String() == "192.168.1.10"
+			}
+		}
+
+		if alpn != wantALPN[i] {
+			t.Errorf("answer %d: expected alpn %q, got %q", i, wantALPN[i], alpn)
+		}
+		if port != wantPort[alpn] {
+			t.Errorf("answer %d: expected port %d, got %d", i, wantPort[alpn], port)
+		}
+		if !hasIPv4Hint {
+			t.Errorf("answer %d: expected ipv4hint 192.168.1.10", i)
+		}
+	}
+}
+
+func TestBuildDDRAnswerNoProtocolsConfigured(t *testing.T) {
+	cfg := &config.Config{Server: config.ServerConfig{Address: "192.168.1.10"}}
+
+	if answers := BuildDDRAnswer(cfg); answers != nil {
+		t.Errorf("expected no SVCB answers when no encrypted protocols are configured, got %v", answers)
+	}
+}
+
+func TestBuildDDRAnswerCustomTargetAndPriority(t *testing.T) {
+	cfg := &config.Config{
+		Server: config.ServerConfig{Address: "192.168.1.10"},
+		DDR:    config.DDRConfig{TargetName: "concierge.home.", Priority: 5},
+		Encrypted: config.EncryptedListeners{
+			Protocols: []string{"dot"},
+		},
+	}
+
+	answers := BuildDDRAnswer(cfg)
+	if len(answers) != 1 {
+		t.Fatalf("expected 1 SVCB record, got %d", len(answers))
+	}
+
+	svcb := answers[0].(*dns.SVCB)
+	if svcb.Target != "concierge.home." {
+		t.Errorf("expected target concierge.home., got %s", svcb.Target)
+	}
+	if svcb.Priority != 5 {
+		t.Errorf("expected priority 5, got %d", svcb.Priority)
+	}
+}
@@ -0,0 +1,129 @@
+package dns
+
+import (
+	"net"
+
+	"github.com/miekg/dns"
+	"github.com/risadams/Pocket-Concierge/internal/config"
+)
+
+// defaultECSPrefixV4/V6 are the subnet mask lengths applied under the "add"
+// policy when DNSConfig.ECS.PrefixV4/PrefixV6 aren't set.
+const (
+	defaultECSPrefixV4 = 24
+	defaultECSPrefixV6 = 56
+)
+
+// ecsPolicyFor resolves the effective EDNS Client Subnet policy for
+// upstream: its own ECS override if set, otherwise the default
+// DNSConfig.ECS.Policy, defaulting to "strip" when neither is configured.
+func ecsPolicyFor(cfg *config.Config, upstream config.UpstreamServer) string {
+	if upstream.ECS != "" {
+		return upstream.ECS
+	}
+	if cfg.DNS.ECS.Policy != "" {
+		return cfg.DNS.ECS.Policy
+	}
+	return "strip"
+}
+
+// applyECS returns the outgoing query to send to an upstream under policy:
+//   - "strip" (the default): query is returned unchanged; it never carries
+//     a client's ECS option in the first place, since forwardUpstream builds
+//     it fresh from the question alone.
+//   - "forward": a clone of query carrying original's own ECS option
+//     unchanged, if the client sent one.
+//   - "add": a clone of query carrying an ECS option derived from client's
+//     address, masked to prefixV4/prefixV6 bits.
+//
+// query is never mutated in place: Strategy implementations share one query
+// pointer across concurrently-raced upstreams (see raceUpstreams), so each
+// upstream needing an ECS option must get its own clone.
+func applyECS(query *dns.Msg, original *dns.Msg, client net.Addr, policy string, prefixV4, prefixV6 int) *dns.Msg {
+	switch policy {
+	case "forward":
+		if subnet := ecsFromMsg(original); subnet != nil {
+			clone := query.Copy()
+			withECS(clone, subnet)
+			return clone
+		}
+	case "add":
+		if subnet := ecsFromAddr(client, prefixV4, prefixV6); subnet != nil {
+			clone := query.Copy()
+			withECS(clone, subnet)
+			return clone
+		}
+	}
+
+	return query
+}
+
+// ecsFromMsg extracts the EDNS Client Subnet option from msg's OPT record,
+// if any.
+func ecsFromMsg(msg *dns.Msg) *dns.EDNS0_SUBNET {
+	if msg == nil {
+		return nil
+	}
+	opt := msg.IsEdns0()
+	if opt == nil {
+		return nil
+	}
+	for _, o := range opt.Option {
+		if subnet, ok := o.(*dns.EDNS0_SUBNET); ok {
+			return subnet
+		}
+	}
+	return nil
+}
+
+// ecsFromAddr builds an EDNS Client Subnet option from client's address,
+// masked to prefixV4 (for an IPv4 client) or prefixV6 (IPv6) bits, falling
+// back to defaultECSPrefixV4/V6 when the configured prefix is 0. It returns
+// nil when client is nil or its address can't be parsed as an IP.
+func ecsFromAddr(client net.Addr, prefixV4, prefixV6 int) *dns.EDNS0_SUBNET {
+	if client == nil {
+		return nil
+	}
+
+	host, _, err := net.SplitHostPort(client.String())
+	if err != nil {
+		host = client.String()
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil
+	}
+
+	if ip4 := ip.To4(); ip4 != nil {
+		if prefixV4 <= 0 {
+			prefixV4 = defaultECSPrefixV4
+		}
+		return &dns.EDNS0_SUBNET{
+			Code:          dns.EDNS0SUBNET,
+			Family:        1,
+			SourceNetmask: uint8(prefixV4),
+			Address:       ip4.Mask(net.CIDRMask(prefixV4, 32)),
+		}
+	}
+
+	if prefixV6 <= 0 {
+		prefixV6 = defaultECSPrefixV6
+	}
+	return &dns.EDNS0_SUBNET{
+		Code:          dns.EDNS0SUBNET,
+		Family:        2,
+		SourceNetmask: uint8(prefixV6),
+		Address:       ip.Mask(net.CIDRMask(prefixV6, 128)),
+	}
+}
+
+// withECS attaches subnet to msg's OPT record, creating one (EDNS0 enabled,
+// DO bit unset) first if msg doesn't already have one.
+func withECS(msg *dns.Msg, subnet *dns.EDNS0_SUBNET) {
+	opt := msg.IsEdns0()
+	if opt == nil {
+		msg.SetEdns0(dns.DefaultMsgSize, false)
+		opt = msg.IsEdns0()
+	}
+	opt.Option = append(opt.Option, subnet)
+}
@@ -0,0 +1,120 @@
+package dns
+
+import (
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/risadams/Pocket-Concierge/internal/config"
+)
+
+func TestECSPolicyForPrefersUpstreamOverride(t *testing.T) {
+	cfg := &config.Config{DNS: config.DNSConfig{ECS: config.ECSConfig{Policy: "strip"}}}
+	upstream := config.UpstreamServer{Address: "1.1.1.1", ECS: "add"}
+
+	if got := ecsPolicyFor(cfg, upstream); got != "add" {
+		t.Errorf("expected upstream override to win, got %q", got)
+	}
+}
+
+func TestECSPolicyForDefaultsToStrip(t *testing.T) {
+	cfg := &config.Config{}
+	upstream := config.UpstreamServer{Address: "1.1.1.1"}
+
+	if got := ecsPolicyFor(cfg, upstream); got != "strip" {
+		t.Errorf("expected default policy strip, got %q", got)
+	}
+}
+
+func TestApplyECSStripLeavesQueryUnchanged(t *testing.T) {
+	query := &dns.Msg{}
+	query.SetQuestion("example.com.", dns.TypeA)
+
+	client, _ := net.ResolveUDPAddr("udp", "192.168.1.50:12345")
+	out := applyECS(query, nil, client, "strip", 0, 0)
+
+	if out != query {
+		t.Error("expected strip policy to return the same query pointer, not a clone")
+	}
+	if out.IsEdns0() != nil {
+		t.Error("expected no EDNS0 OPT record under strip policy")
+	}
+}
+
+func TestApplyECSAddDerivesSubnetFromClient(t *testing.T) {
+	query := &dns.Msg{}
+	query.SetQuestion("example.com.", dns.TypeA)
+
+	client, _ := net.ResolveUDPAddr("udp", "192.168.1.50:12345")
+	out := applyECS(query, nil, client, "add", 24, 56)
+
+	if out == query {
+		t.Fatal("expected add policy to return a clone, not the shared query")
+	}
+	subnet := ecsFromMsg(out)
+	if subnet == nil {
+		t.Fatal("expected an ECS option to be attached")
+	}
+	if subnet.SourceNetmask != 24 {
+		t.Errorf("expected /24 netmask, got /%d", subnet.SourceNetmask)
+	}
+	if !subnet.Address.Equal(net.ParseIP("192.168.1.0").To4()) {
+		t.Errorf("expected masked address 192.168.1.0, got %s", subnet.Address)
+	}
+
+	// The original shared query must be untouched.
+	if query.IsEdns0() != nil {
+		t.Error("expected the original query to remain unmodified")
+	}
+}
+
+func TestApplyECSForwardCarriesClientOption(t *testing.T) {
+	query := &dns.Msg{}
+	query.SetQuestion("example.com.", dns.TypeA)
+
+	original := &dns.Msg{}
+	original.SetQuestion("example.com.", dns.TypeA)
+	clientSubnet := &dns.EDNS0_SUBNET{Code: dns.EDNS0SUBNET, Family: 1, SourceNetmask: 32, Address: net.ParseIP("10.0.0.5").To4()}
+	withECS(original, clientSubnet)
+
+	out := applyECS(query, original, nil, "forward", 0, 0)
+
+	subnet := ecsFromMsg(out)
+	if subnet == nil {
+		t.Fatal("expected the client's ECS option to be forwarded")
+	}
+	if !subnet.Address.Equal(net.ParseIP("10.0.0.5").To4()) {
+		t.Errorf("expected forwarded address 10.0.0.5, got %s", subnet.Address)
+	}
+}
+
+func TestApplyECSForwardWithoutClientOptionLeavesQueryUnchanged(t *testing.T) {
+	query := &dns.Msg{}
+	query.SetQuestion("example.com.", dns.TypeA)
+
+	original := &dns.Msg{}
+	original.SetQuestion("example.com.", dns.TypeA)
+
+	out := applyECS(query, original, nil, "forward", 0, 0)
+	if out != query {
+		t.Error("expected no clone when the client sent no ECS option to forward")
+	}
+}
+
+func TestEcsFromAddrDefaultsPrefixWhenUnset(t *testing.T) {
+	client, _ := net.ResolveUDPAddr("udp", "192.168.1.50:12345")
+
+	subnet := ecsFromAddr(client, 0, 0)
+	if subnet == nil {
+		t.Fatal("expected a subnet option")
+	}
+	if subnet.SourceNetmask != defaultECSPrefixV4 {
+		t.Errorf("expected default v4 prefix %d, got %d", defaultECSPrefixV4, subnet.SourceNetmask)
+	}
+}
+
+func TestEcsFromAddrNilReturnsNil(t *testing.T) {
+	if subnet := ecsFromAddr(nil, 24, 56); subnet != nil {
+		t.Error("expected nil subnet for a nil client address")
+	}
+}
@@ -1,82 +1,308 @@
 package dns
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync/atomic"
 	"time"
 
 	"github.com/miekg/dns"
+	"github.com/risadams/Pocket-Concierge/internal/bootstrap"
 	"github.com/risadams/Pocket-Concierge/internal/config"
+	"github.com/risadams/Pocket-Concierge/internal/filter"
+	"github.com/risadams/Pocket-Concierge/internal/querylog"
 )
 
 // Handler manages DNS request processing
 type Handler struct {
-	config       *config.Config
-	resolver     *Resolver
-	client       *dns.Client
-	secureClient *SecureClient
+	config        *config.Config
+	resolver      *Resolver
+	client        *dns.Client
+	secureClient  *SecureClient
+	filter        *filter.Filter
+	startTime     time.Time
+	rrCounters    *roundRobinCounters
+	answerCache   *answerCache
+	chain         ChainResolver
+	healthChecker *HealthChecker
+	inFlight      int64 // queries currently being resolved, read by MetricsHandler
 }
 
 // NewHandler creates a new DNS handler
 func NewHandler(cfg *config.Config) *Handler {
-	return &Handler{
+	bootstrapResolver := bootstrap.NewResolver(cfg.Bootstrap)
+	bootstrapResolver.StartRefresh()
+
+	h := &Handler{
 		config:   cfg,
 		resolver: NewResolver(cfg),
 		client: &dns.Client{
 			Timeout: 5 * time.Second,
 		},
-		secureClient: NewSecureClient(),
+		secureClient: NewSecureClientWithBootstrap(bootstrapResolver),
+		startTime:    time.Now(),
+		rrCounters:   newRoundRobinCounters(),
 	}
+
+	if len(cfg.Filter.Lists) > 0 {
+		if f, err := filter.New(cfg.Filter); err == nil {
+			h.filter = f
+		}
+	}
+
+	h.chain = buildChain(h)
+
+	h.healthChecker = NewHealthChecker(h, cfg.UpstreamHealthcheck)
+	h.healthChecker.Start()
+
+	return h
 }
 
 func (h *Handler) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+	response := h.Handle(w.RemoteAddr(), r)
+
+	// Write response (error handling omitted for performance)
+	w.WriteMsg(response)
+}
+
+// Handle resolves r on behalf of client and returns the response, without
+// writing it to any transport. It is the shared core behind ServeDNS
+// (UDP/TCP/DoT) and the DoH HTTP handler, so every transport the server
+// listens on sees identical resolution behavior. Each question is walked
+// through h.chain (DDR, blocking, hosts/PTR, grouped blocking, answer
+// cache, conditional routing, client-name lookup, upstream forwarding, in
+// that order) until a stage answers it.
+func (h *Handler) Handle(client net.Addr, r *dns.Msg) *dns.Msg {
+	atomic.AddInt64(&h.inFlight, 1)
+	defer atomic.AddInt64(&h.inFlight, -1)
+
 	response := &dns.Msg{}
 	response.SetReply(r)
 	response.Authoritative = true
 
-	// Process all questions efficiently
 	for _, question := range r.Question {
-		// Check if domain is blocked
-		if h.config.IsBlocked(question.Name) {
-			// Return NXDOMAIN for blocked domains
-			response.Rcode = dns.RcodeNameError
-			continue
-		}
+		req := &Request{Client: client, Original: r, Question: question}
 
-		// Try high-speed local resolution first using pre-built records
-		if localAnswers := h.resolver.ResolveFast(question.Name, question.Qtype); len(localAnswers) > 0 {
-			response.Answer = append(response.Answer, localAnswers...)
+		resp, err := h.chain.Resolve(h, req)
+		if err != nil || resp == nil {
 			continue
 		}
 
-		// If not found locally and recursion enabled, forward upstream
-		if h.config.DNS.EnableRecursion {
-			if upstreamAnswers := h.forwardUpstream(question, r); len(upstreamAnswers) > 0 {
-				response.Answer = append(response.Answer, upstreamAnswers...)
-				continue
-			}
+		if resp.Rcode != dns.RcodeSuccess {
+			response.Rcode = resp.Rcode
 		}
+		response.Answer = append(response.Answer, resp.Answer...)
+		response.Ns = append(response.Ns, resp.Ns...)
 	}
 
-	// Write response (error handling omitted for performance)
-	w.WriteMsg(response)
+	return response
 }
 
-// forwardUpstream handles upstream DNS forwarding
-func (h *Handler) forwardUpstream(question dns.Question, original *dns.Msg) []dns.RR {
+// forwardUpstream queries upstreams (already chosen by conditionalResolver)
+// for question via the configured Strategy (DNSConfig.UpstreamStrategy).
+// original is the client's own request, consulted for its EDNS Client
+// Subnet option under the "forward" ECS policy. The returned answers are
+// already shuffled per cfg.DNS.Shuffle, so every caller sees consistent
+// ordering behavior without re-applying it themselves.
+func (h *Handler) forwardUpstream(client net.Addr, original *dns.Msg, question dns.Question, upstreams []config.UpstreamServer) []dns.RR {
 	query := &dns.Msg{}
 	query.SetQuestion(question.Name, question.Qtype)
 	query.RecursionDesired = true
 
-	// Try each upstream server
-	for _, upstream := range h.config.Upstream {
-		response, err := h.secureClient.Query(query, upstream)
-		if err != nil {
-			continue
+	// There's no inbound request context to inherit here (miekg/dns's
+	// ServeDNS doesn't hand us one), so this is the root of the context tree
+	// for the forward: it only needs to live long enough to let raceUpstreams
+	// cancel a race's losing upstream once a winner answers.
+	answers := strategyFor(h.config.DNS.UpstreamStrategy).Forward(context.Background(), h, client, original, question, query, upstreams)
+
+	return shuffleAnswers(h.config.DNS.Shuffle, question.Name, answers, h.rrCounters)
+}
+
+// queryUpstream sends query to upstream (adjusted per the resolved EDNS
+// Client Subnet policy for upstream, derived from client's address and
+// original's own ECS option), records the outcome in the resolver's health
+// tracker, and logs the completed query on success. A bootstrap resolution
+// failure doesn't count against the upstream's health score, since it never
+// reached the upstream; every Strategy still falls through to the next
+// upstream either way. ctx is passed to secureClient.Query so a racing
+// Strategy can cancel this query once another candidate has already won.
+func (h *Handler) queryUpstream(ctx context.Context, client net.Addr, original *dns.Msg, question dns.Question, query *dns.Msg, upstream config.UpstreamServer) (*dns.Msg, error) {
+	policy := ecsPolicyFor(h.config, upstream)
+	outgoing := applyECS(query, original, client, policy, h.config.DNS.ECS.PrefixV4, h.config.DNS.ECS.PrefixV6)
+
+	start := time.Now()
+	response, err := h.secureClient.Query(ctx, outgoing, upstream)
+	if err != nil {
+		if errors.Is(err, context.Canceled) {
+			// Lost a race against a faster candidate; not a real upstream
+			// failure, so don't count it against health or log it as one.
+			return nil, err
 		}
+		if !errors.Is(err, bootstrap.ErrResolution) {
+			h.resolver.Health().RecordFailure(upstream)
+		}
+		h.resolver.LogUpstreamError(upstream.Address)
+		return nil, err
+	}
+
+	latency := time.Since(start)
+	h.resolver.Health().RecordSuccess(upstream, latency)
+	h.resolver.LogQuery(client, question, response, upstream.Address, querylog.SourceUpstream, latency)
+	return response, nil
+}
 
-		if response != nil && len(response.Answer) > 0 {
-			return response.Answer
+// resolveClientName resolves reverseName (a client's in-addr.arpa/ip6.arpa
+// name) to a PTR target: first the HostCache, then the answer cache shared
+// with the main resolver chain, then upstream on a miss (caching whatever
+// it returns), so repeated queries from the same client don't re-resolve
+// its name on every request. It returns "" when reverseName resolves to no
+// name anywhere, or when recursion is disabled and it isn't a known host.
+func (h *Handler) resolveClientName(reverseName string) string {
+	if ptrAnswers := h.resolver.LookupPTR(reverseName); len(ptrAnswers) > 0 {
+		return ptrTarget(ptrAnswers)
+	}
+
+	question := dns.Question{Name: reverseName, Qtype: dns.TypePTR, Qclass: dns.ClassINET}
+
+	if h.answerCache != nil {
+		if entry, ok := h.answerCache.get(question); ok {
+			return ptrTarget(entry.answer)
 		}
 	}
 
-	return nil
+	if !h.config.DNS.EnableRecursion {
+		return ""
+	}
+
+	answers := h.forwardUpstream(nil, nil, question, h.resolver.UpstreamsFor(reverseName))
+	if h.answerCache != nil {
+		h.answerCache.maybeStore(question, &Response{Rcode: dns.RcodeSuccess, Answer: answers})
+	}
+
+	return ptrTarget(answers)
+}
+
+// ptrTarget returns the target of the first PTR record in answers, or "".
+func ptrTarget(answers []dns.RR) string {
+	if len(answers) == 0 {
+		return ""
+	}
+	if ptr, ok := answers[0].(*dns.PTR); ok {
+		return ptr.Ptr
+	}
+	return ""
+}
+
+// filterAnswer consults the response-filtering stage for question, before
+// it is ever forwarded upstream. It mirrors Resolver.BlockingAnswer's shape.
+func (h *Handler) filterAnswer(question dns.Question) (answers []dns.RR, blocked bool) {
+	if h.filter == nil || !h.filter.CheckQuestion(question.Name) {
+		return nil, false
+	}
+
+	if question.Qtype != dns.TypeA && question.Qtype != dns.TypeAAAA {
+		return nil, true
+	}
+
+	if h.filter.Action() == filter.ActionSinkhole {
+		ttl := uint32(h.config.DNS.TTL)
+		return sinkholeAnswer(question.Name, question.Qtype, ttl, h.filter.SinkholeIPv4(), h.filter.SinkholeIPv6()), true
+	}
+
+	return nil, true
+}
+
+// answerTargets extracts the names carried by an upstream answer section
+// that response filtering should check: A/AAAA owner names and CNAME/HTTPS
+// alias targets, so a chain to a blocked domain is caught.
+func answerTargets(answers []dns.RR) []string {
+	names := make([]string, 0, len(answers))
+	for _, rr := range answers {
+		switch v := rr.(type) {
+		case *dns.A:
+			names = append(names, v.Hdr.Name)
+		case *dns.AAAA:
+			names = append(names, v.Hdr.Name)
+		case *dns.CNAME:
+			names = append(names, v.Target)
+		case *dns.HTTPS:
+			names = append(names, v.Target)
+		}
+	}
+	return names
+}
+
+// FilterStats returns the response-filtering stage's query/match counters,
+// or nil if no filter is configured.
+func (h *Handler) FilterStats() *filter.Stats {
+	if h.filter == nil {
+		return nil
+	}
+	stats := h.filter.Stats()
+	return &stats
+}
+
+// CacheStats returns the response-caching stage's hit/miss/prefetch
+// counters, or nil if caching is disabled.
+func (h *Handler) CacheStats() *CacheStats {
+	if h.answerCache == nil || !h.answerCache.cfg.Enabled {
+		return nil
+	}
+	stats := h.answerCache.stats()
+	return &stats
+}
+
+// MetricsHandler returns the handler to serve /metrics from, or nil if
+// query logging has no "prometheus" sink configured. The returned handler
+// appends an in-flight query gauge to the query log's own Prometheus
+// output, since that count lives on Handler rather than any querylog sink.
+func (h *Handler) MetricsHandler() http.Handler {
+	inner := h.resolver.MetricsHandler()
+	if inner == nil {
+		return nil
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		inner.ServeHTTP(w, r)
+		fmt.Fprintln(w, "# HELP dns_in_flight_queries DNS queries currently being resolved.")
+		fmt.Fprintln(w, "# TYPE dns_in_flight_queries gauge")
+		fmt.Fprintf(w, "dns_in_flight_queries %d\n", atomic.LoadInt64(&h.inFlight))
+	})
+}
+
+// StatsHandler returns the handler to serve /stats from, or nil if query
+// logging has no "memory" sink configured.
+func (h *Handler) StatsHandler() http.Handler {
+	return h.resolver.StatsHandler()
+}
+
+// UpstreamHealth reports the current health (latency, failures, quarantine
+// status) of every configured upstream, for logging or a metrics endpoint.
+func (h *Handler) UpstreamHealth() []UpstreamStatus {
+	return h.resolver.Health().Status(h.config.Upstream)
+}
+
+// HealthzHandler returns an http.Handler reporting 200 if at least one
+// upstream has answered successfully (a real query or a health probe) within
+// the last within, or 503 otherwise, for a liveness/readiness probe to gate on.
+func (h *Handler) HealthzHandler(within time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if h.resolver.Health().AnySuccessWithin(within) {
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte("ok\n"))
+			return
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("no upstream has answered successfully recently\n"))
+	})
+}
+
+// Close releases resources held by the handler's query log sinks, so
+// Server.Stop can drain any buffered records before the process exits.
+// It does not yet tear down the health checker, bootstrap resolver, or
+// blocklist refresh goroutines, which all currently outlive a Stop call.
+func (h *Handler) Close() error {
+	return h.resolver.Close()
 }
@@ -3,6 +3,9 @@ package dns
 import (
 	"fmt"
 	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/miekg/dns"
@@ -118,13 +121,13 @@ func TestHandlerServeDNSLocalResolution(t *testing.T) {
 			name:          "A record query",
 			hostname:      "test.home.",
 			qtype:         dns.TypeA,
-			expectedCount: 4, // 2 IPv4 addresses x 2 (short+full name) = 4 records
+			expectedCount: 2, // 2 IPv4 addresses; "test.home" is already fully-qualified so short/full keys collide and aren't double-counted
 		},
 		{
 			name:          "AAAA record query",
 			hostname:      "test.home.",
 			qtype:         dns.TypeAAAA,
-			expectedCount: 2, // 1 IPv6 address x 2 (short+full name) = 2 records
+			expectedCount: 1, // 1 IPv6 address; "test.home" is already fully-qualified so short/full keys collide and aren't double-counted
 		},
 		{
 			name:          "nonexistent host",
@@ -175,6 +178,36 @@ func TestHandlerServeDNSLocalResolution(t *testing.T) {
 	}
 }
 
+func TestHandlerMetricsHandlerNilWithoutPrometheusSink(t *testing.T) {
+	handler := NewHandler(&config.Config{HomeDNSDomain: "home"})
+	if handler.MetricsHandler() != nil {
+		t.Error("expected a nil MetricsHandler when no prometheus sink is configured")
+	}
+}
+
+func TestHandlerMetricsHandlerReportsInFlightGauge(t *testing.T) {
+	cfg := &config.Config{
+		HomeDNSDomain: "home",
+		Hosts: []config.HostEntry{
+			{Hostname: "test.home", IPv4: []string{"192.168.1.100"}},
+		},
+		QueryLog: config.QueryLogConfig{Sinks: []string{"prometheus"}},
+	}
+	handler := NewHandler(cfg)
+
+	query := &dns.Msg{}
+	query.SetQuestion("test.home.", dns.TypeA)
+	handler.Handle(nil, query)
+
+	rec := httptest.NewRecorder()
+	handler.MetricsHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := rec.Body.String()
+
+	if !strings.Contains(body, "dns_in_flight_queries 0") {
+		t.Errorf("expected the in-flight gauge to settle back to 0 after Handle returns, got:\n%s", body)
+	}
+}
+
 func TestHandlerServeDNSMultipleQuestions(t *testing.T) {
 	cfg := &config.Config{
 		HomeDNSDomain: "home",
@@ -213,8 +246,8 @@ func TestHandlerServeDNSMultipleQuestions(t *testing.T) {
 	}
 
 	response := writer.responses[0]
-	if len(response.Answer) != 4 {
-		t.Errorf("Expected 4 answers for 2 questions, got %d", len(response.Answer))
+	if len(response.Answer) != 2 {
+		t.Errorf("Expected 2 answers for 2 questions, got %d", len(response.Answer))
 	}
 }
 
@@ -251,6 +284,131 @@ func TestHandlerServeDNSEmptyResponse(t *testing.T) {
 	if !response.Authoritative {
 		t.Error("Expected authoritative response even when no answers")
 	}
+
+	if response.Rcode != dns.RcodeNameError {
+		t.Errorf("Expected NXDOMAIN for a name with no matching host, got %s", dns.RcodeToString[response.Rcode])
+	}
+
+	if len(response.Ns) != 1 {
+		t.Fatalf("Expected 1 authority record (SOA), got %d", len(response.Ns))
+	}
+	if _, ok := response.Ns[0].(*dns.SOA); !ok {
+		t.Errorf("Expected authority record to be an SOA, got %T", response.Ns[0])
+	}
+}
+
+func TestHandlerServeDNSNodataForExistingHostWrongType(t *testing.T) {
+	cfg := &config.Config{
+		HomeDNSDomain: "home",
+		DNS: config.DNSConfig{
+			TTL:             300,
+			EnableRecursion: false,
+		},
+		Hosts: []config.HostEntry{
+			{Hostname: "ipv4only", IPv4: []string{"192.168.1.10"}},
+		},
+	}
+
+	handler := NewHandler(cfg)
+	writer := &MockResponseWriter{}
+
+	// The host exists but only has an A record; an AAAA query should be
+	// NODATA (NoError + SOA in authority), not NXDOMAIN.
+	query := &dns.Msg{}
+	query.SetQuestion("ipv4only.home.", dns.TypeAAAA)
+	query.Id = 12345
+
+	handler.ServeDNS(writer, query)
+
+	if len(writer.responses) != 1 {
+		t.Fatalf("Expected 1 response, got %d", len(writer.responses))
+	}
+
+	response := writer.responses[0]
+	if response.Rcode != dns.RcodeSuccess {
+		t.Errorf("Expected NoError (NODATA) for an existing host with no AAAA record, got %s", dns.RcodeToString[response.Rcode])
+	}
+	if len(response.Answer) != 0 {
+		t.Errorf("Expected 0 answers for NODATA, got %d", len(response.Answer))
+	}
+	if len(response.Ns) != 1 {
+		t.Fatalf("Expected 1 authority record (SOA), got %d", len(response.Ns))
+	}
+	if _, ok := response.Ns[0].(*dns.SOA); !ok {
+		t.Errorf("Expected authority record to be an SOA, got %T", response.Ns[0])
+	}
+}
+
+func TestHandlerServeDNSPTRLookup(t *testing.T) {
+	cfg := &config.Config{
+		HomeDNSDomain: "home",
+		DNS: config.DNSConfig{
+			TTL:             300,
+			EnableRecursion: false,
+		},
+		Hosts: []config.HostEntry{
+			{Hostname: "test1", IPv4: []string{"192.168.1.10"}},
+		},
+	}
+
+	handler := NewHandler(cfg)
+	writer := &MockResponseWriter{}
+
+	query := &dns.Msg{}
+	query.SetQuestion("10.1.168.192.in-addr.arpa.", dns.TypePTR)
+	query.Id = 12345
+
+	handler.ServeDNS(writer, query)
+
+	if len(writer.responses) != 1 {
+		t.Fatalf("Expected 1 response, got %d", len(writer.responses))
+	}
+
+	response := writer.responses[0]
+	if response.Rcode != dns.RcodeSuccess {
+		t.Errorf("Expected NoError, got %s", dns.RcodeToString[response.Rcode])
+	}
+	if len(response.Answer) != 1 {
+		t.Fatalf("Expected 1 PTR answer, got %d", len(response.Answer))
+	}
+	ptr, ok := response.Answer[0].(*dns.PTR)
+	if !ok {
+		t.Fatalf("Expected a PTR record, got %T", response.Answer[0])
+	}
+	if ptr.Ptr != "test1.home." {
+		t.Errorf("Expected PTR target test1.home., got %s", ptr.Ptr)
+	}
+}
+
+func TestHandlerServeDNSPTRNXDOMAINForAuthoritativeReverseZone(t *testing.T) {
+	cfg := &config.Config{
+		HomeDNSDomain: "home",
+		ReverseZones:  []string{"1.168.192.in-addr.arpa"},
+		DNS: config.DNSConfig{
+			TTL:             300,
+			EnableRecursion: false,
+		},
+	}
+
+	handler := NewHandler(cfg)
+	writer := &MockResponseWriter{}
+
+	query := &dns.Msg{}
+	query.SetQuestion("99.1.168.192.in-addr.arpa.", dns.TypePTR)
+	query.Id = 12345
+
+	handler.ServeDNS(writer, query)
+
+	response := writer.responses[0]
+	if response.Rcode != dns.RcodeNameError {
+		t.Errorf("Expected NXDOMAIN for an unmatched name in an authoritative reverse zone, got %s", dns.RcodeToString[response.Rcode])
+	}
+	if len(response.Ns) != 1 {
+		t.Fatalf("Expected 1 authority record (SOA), got %d", len(response.Ns))
+	}
+	if _, ok := response.Ns[0].(*dns.SOA); !ok {
+		t.Errorf("Expected authority record to be an SOA, got %T", response.Ns[0])
+	}
 }
 
 // MockSecureClient for testing upstream forwarding
@@ -299,7 +457,7 @@ func TestHandlerForwardUpstream(t *testing.T) {
 	// Test forwardUpstream method
 	// Since we can't mock the actual upstream without more complex setup,
 	// we test that the method doesn't panic and returns expected structure
-	answers := handler.forwardUpstream(question, query)
+	answers := handler.forwardUpstream(nil, nil, question, cfg.Upstream)
 
 	// The method should return a slice (empty or with answers)
 	// In real scenarios, this would contain upstream responses
@@ -0,0 +1,292 @@
+package dns
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/risadams/Pocket-Concierge/internal/config"
+)
+
+// benchThreshold is the number of (decayed) consecutive failures after
+// which an upstream is considered benched and given minimal selection
+// weight.
+const benchThreshold = 3
+
+// ewmaAlpha controls how quickly the rolling latency average reacts to new
+// samples; higher is more reactive.
+const ewmaAlpha = 0.3
+
+// failureHalfLife is how long it takes a benched upstream's failure count
+// to decay by half, so a single past outage doesn't permanently
+// deprioritize it once it has recovered.
+const failureHalfLife = time.Minute
+
+// Defaults applied when the corresponding UpstreamHealthcheckConfig field is
+// left at its zero value.
+const (
+	defaultProbeFailureThreshold = 3
+	defaultProbeBackoffMax       = 5 * time.Minute
+)
+
+// upstreamHealth tracks rolling health for a single upstream server.
+type upstreamHealth struct {
+	avgLatency          time.Duration
+	consecutiveFailures int
+	lastFailure         time.Time
+	lastSuccess         time.Time
+
+	// probeFailures and quarantinedUntil are maintained solely by
+	// RecordProbe, from the active background HealthChecker, independent of
+	// consecutiveFailures (which tracks real query traffic).
+	probeFailures    int
+	quarantinedUntil time.Time
+}
+
+// HealthTracker records per-upstream latency and failure history so callers
+// can weight upstream selection toward healthier servers, mirroring Blocky's
+// ParallelBestResolver health scoring.
+type HealthTracker struct {
+	mu      sync.Mutex
+	history map[string]*upstreamHealth
+}
+
+// NewHealthTracker creates an empty health tracker.
+func NewHealthTracker() *HealthTracker {
+	return &HealthTracker{history: make(map[string]*upstreamHealth)}
+}
+
+// upstreamKey uniquely identifies an upstream server for health tracking.
+func upstreamKey(u config.UpstreamServer) string {
+	return fmt.Sprintf("%s:%s:%d", u.Protocol, u.Address, u.Port)
+}
+
+// RecordSuccess registers a successful query and its latency.
+func (ht *HealthTracker) RecordSuccess(u config.UpstreamServer, latency time.Duration) {
+	ht.mu.Lock()
+	defer ht.mu.Unlock()
+
+	h := ht.entry(u)
+	h.consecutiveFailures = 0
+	h.lastSuccess = time.Now()
+	if h.avgLatency == 0 {
+		h.avgLatency = latency
+		return
+	}
+	h.avgLatency = time.Duration(ewmaAlpha*float64(latency) + (1-ewmaAlpha)*float64(h.avgLatency))
+}
+
+// RecordFailure registers a failed query.
+func (ht *HealthTracker) RecordFailure(u config.UpstreamServer) {
+	ht.mu.Lock()
+	defer ht.mu.Unlock()
+
+	h := ht.entry(u)
+	h.consecutiveFailures++
+	h.lastFailure = time.Now()
+}
+
+// RecordProbe registers the outcome of an active background health-check
+// probe (distinct from RecordFailure/RecordSuccess, which track real query
+// traffic). cfg.FailureThreshold consecutive probe failures quarantine the
+// upstream for an exponentially growing backoff, capped at cfg.BackoffMax,
+// so failoverStrategy skips it until it recovers.
+func (ht *HealthTracker) RecordProbe(u config.UpstreamServer, err error, latency time.Duration, cfg config.UpstreamHealthcheckConfig) {
+	ht.mu.Lock()
+	defer ht.mu.Unlock()
+
+	h := ht.entry(u)
+
+	if err != nil {
+		h.probeFailures++
+
+		threshold := cfg.FailureThreshold
+		if threshold <= 0 {
+			threshold = defaultProbeFailureThreshold
+		}
+		if h.probeFailures < threshold {
+			return
+		}
+
+		backoffMax := defaultProbeBackoffMax
+		if cfg.BackoffMax > 0 {
+			backoffMax = time.Duration(cfg.BackoffMax) * time.Second
+		}
+
+		shift := h.probeFailures - threshold
+		if shift > 10 {
+			shift = 10 // avoid overflowing the 1<<shift below
+		}
+		backoff := time.Duration(1<<uint(shift)) * time.Second
+		if backoff > backoffMax {
+			backoff = backoffMax
+		}
+		h.quarantinedUntil = time.Now().Add(backoff)
+		return
+	}
+
+	h.probeFailures = 0
+	h.quarantinedUntil = time.Time{}
+	h.lastSuccess = time.Now()
+	if h.avgLatency == 0 {
+		h.avgLatency = latency
+		return
+	}
+	h.avgLatency = time.Duration(ewmaAlpha*float64(latency) + (1-ewmaAlpha)*float64(h.avgLatency))
+}
+
+// Quarantined reports whether u is currently serving out a backoff period
+// from repeated active-probe failures (see RecordProbe).
+func (ht *HealthTracker) Quarantined(u config.UpstreamServer) bool {
+	ht.mu.Lock()
+	defer ht.mu.Unlock()
+
+	h, ok := ht.history[upstreamKey(u)]
+	if !ok {
+		return false
+	}
+	return time.Now().Before(h.quarantinedUntil)
+}
+
+// AnySuccessWithin reports whether at least one upstream has answered
+// successfully (a real query or a health probe) within the last d, so a
+// /healthz endpoint can
+// gate on overall upstream reachability rather than any single server.
+func (ht *HealthTracker) AnySuccessWithin(d time.Duration) bool {
+	ht.mu.Lock()
+	defer ht.mu.Unlock()
+
+	cutoff := time.Now().Add(-d)
+	for _, h := range ht.history {
+		if h.lastSuccess.After(cutoff) {
+			return true
+		}
+	}
+	return false
+}
+
+// UpstreamStatus summarizes an upstream's current health, for logging or a
+// metrics endpoint to consume.
+type UpstreamStatus struct {
+	Upstream            config.UpstreamServer
+	AvgLatency          time.Duration
+	ConsecutiveFailures int
+	Quarantined         bool
+	QuarantinedUntil    time.Time
+}
+
+// Status reports the current health of every upstream in upstreams, in the
+// same order, for logging/metrics. An upstream with no recorded history yet
+// is reported as healthy with zero values.
+func (ht *HealthTracker) Status(upstreams []config.UpstreamServer) []UpstreamStatus {
+	ht.mu.Lock()
+	defer ht.mu.Unlock()
+
+	statuses := make([]UpstreamStatus, 0, len(upstreams))
+	for _, u := range upstreams {
+		h, ok := ht.history[upstreamKey(u)]
+		if !ok {
+			statuses = append(statuses, UpstreamStatus{Upstream: u})
+			continue
+		}
+		statuses = append(statuses, UpstreamStatus{
+			Upstream:            u,
+			AvgLatency:          h.avgLatency,
+			ConsecutiveFailures: h.consecutiveFailures,
+			Quarantined:         time.Now().Before(h.quarantinedUntil),
+			QuarantinedUntil:    h.quarantinedUntil,
+		})
+	}
+	return statuses
+}
+
+func (ht *HealthTracker) entry(u config.UpstreamServer) *upstreamHealth {
+	key := upstreamKey(u)
+	h, ok := ht.history[key]
+	if !ok {
+		h = &upstreamHealth{}
+		ht.history[key] = h
+	}
+	return h
+}
+
+// Weight returns the selection weight for an upstream: benched servers (at
+// or past benchThreshold decayed consecutive failures) get a small fixed
+// weight so they can still recover and be probed occasionally, otherwise
+// weight decreases as latency grows.
+func (ht *HealthTracker) Weight(u config.UpstreamServer) float64 {
+	ht.mu.Lock()
+	defer ht.mu.Unlock()
+
+	h, ok := ht.history[upstreamKey(u)]
+	if !ok {
+		return 1.0
+	}
+
+	if decayedFailures(h.consecutiveFailures, h.lastFailure) >= benchThreshold {
+		return 0.05
+	}
+
+	if h.avgLatency <= 0 {
+		return 1.0
+	}
+
+	// Inverse relationship: a 1s upstream is weighted half of a 0ms one.
+	return 1.0 / (1.0 + h.avgLatency.Seconds())
+}
+
+// decayedFailures halves count for every failureHalfLife elapsed since
+// lastFailure, so an upstream that failed repeatedly an hour ago is judged
+// on its recent behavior, not that outage.
+func decayedFailures(count int, lastFailure time.Time) int {
+	if count == 0 || lastFailure.IsZero() {
+		return count
+	}
+
+	halvings := int(time.Since(lastFailure) / failureHalfLife)
+	if halvings <= 0 {
+		return count
+	}
+	if halvings >= 63 {
+		return 0
+	}
+
+	return count >> uint(halvings)
+}
+
+// WeightedPick selects n distinct upstreams from candidates, weighted by
+// Weight (health-based), without replacement. If candidates has fewer than
+// n entries, all of them are returned.
+func (ht *HealthTracker) WeightedPick(candidates []config.UpstreamServer, n int) []config.UpstreamServer {
+	if len(candidates) <= n {
+		return candidates
+	}
+
+	pool := append([]config.UpstreamServer{}, candidates...)
+	picked := make([]config.UpstreamServer, 0, n)
+
+	for i := 0; i < n && len(pool) > 0; i++ {
+		weights := make([]float64, len(pool))
+		total := 0.0
+		for j, u := range pool {
+			weights[j] = ht.Weight(u)
+			total += weights[j]
+		}
+
+		target := rand.Float64() * total
+		idx := len(pool) - 1
+		for j, w := range weights {
+			target -= w
+			if target <= 0 {
+				idx = j
+				break
+			}
+		}
+
+		picked = append(picked, pool[idx])
+		pool = append(pool[:idx], pool[idx+1:]...)
+	}
+
+	return picked
+}
@@ -0,0 +1,351 @@
+package dns
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/risadams/Pocket-Concierge/internal/config"
+)
+
+// fakeUpstream is a minimal UDP DNS server used to exercise the
+// parallel-best forwarding strategy with injected latency and errors.
+type fakeUpstream struct {
+	server  *dns.Server
+	conn    net.PacketConn
+	latency time.Duration
+	fail    bool
+}
+
+func newFakeUpstream(t *testing.T, latency time.Duration, fail bool) *fakeUpstream {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	fu := &fakeUpstream{conn: conn, latency: latency, fail: fail}
+	fu.server = &dns.Server{PacketConn: conn, Handler: dns.HandlerFunc(fu.handle)}
+
+	go fu.server.ActivateAndServe()
+	t.Cleanup(func() { fu.server.Shutdown() })
+
+	return fu
+}
+
+func (fu *fakeUpstream) handle(w dns.ResponseWriter, r *dns.Msg) {
+	if fu.latency > 0 {
+		time.Sleep(fu.latency)
+	}
+
+	msg := &dns.Msg{}
+	msg.SetReply(r)
+
+	if fu.fail {
+		msg.Rcode = dns.RcodeServerFailure
+		w.WriteMsg(msg)
+		return
+	}
+
+	rr, _ := dns.NewRR(r.Question[0].Name + " 300 IN A 10.1.1.1")
+	msg.Answer = append(msg.Answer, rr)
+	w.WriteMsg(msg)
+}
+
+func (fu *fakeUpstream) upstream() config.UpstreamServer {
+	_, portStr, _ := net.SplitHostPort(fu.conn.LocalAddr().String())
+	port, _ := strconv.Atoi(portStr)
+	return config.UpstreamServer{Address: "127.0.0.1", Protocol: "udp", Port: port}
+}
+
+func TestHandlerForwardParallelBestPicksFastest(t *testing.T) {
+	slow := newFakeUpstream(t, 100*time.Millisecond, false)
+	fast := newFakeUpstream(t, 0, false)
+
+	cfg := &config.Config{
+		HomeDNSDomain: "home",
+		DNS: config.DNSConfig{
+			TTL:              300,
+			EnableRecursion:  true,
+			UpstreamStrategy: "parallel_best",
+		},
+		Upstream: []config.UpstreamServer{slow.upstream(), fast.upstream()},
+	}
+
+	handler := NewHandler(cfg)
+	query := &dns.Msg{}
+	query.SetQuestion("example.com.", dns.TypeA)
+
+	answers := handler.forwardUpstream(nil, nil, query.Question[0], cfg.Upstream)
+	if len(answers) == 0 {
+		t.Fatal("expected an answer from the fastest healthy upstream")
+	}
+}
+
+func TestHandlerForwardParallelBestSkipsServfail(t *testing.T) {
+	broken := newFakeUpstream(t, 0, true)
+	healthy := newFakeUpstream(t, 10*time.Millisecond, false)
+
+	cfg := &config.Config{
+		HomeDNSDomain: "home",
+		DNS: config.DNSConfig{
+			TTL:              300,
+			EnableRecursion:  true,
+			UpstreamStrategy: "parallel_best",
+		},
+		Upstream: []config.UpstreamServer{broken.upstream(), healthy.upstream()},
+	}
+
+	handler := NewHandler(cfg)
+	query := &dns.Msg{}
+	query.SetQuestion("example.com.", dns.TypeA)
+
+	answers := handler.forwardUpstream(nil, nil, query.Question[0], cfg.Upstream)
+	if len(answers) == 0 {
+		t.Fatal("expected the healthy upstream's answer despite the SERVFAIL race")
+	}
+}
+
+func TestHealthTrackerBenchesFailingUpstream(t *testing.T) {
+	ht := NewHealthTracker()
+	u := config.UpstreamServer{Address: "10.0.0.1", Protocol: "udp", Port: 53}
+
+	if w := ht.Weight(u); w != 1.0 {
+		t.Errorf("expected default weight 1.0 for unknown upstream, got %v", w)
+	}
+
+	for i := 0; i < benchThreshold; i++ {
+		ht.RecordFailure(u)
+	}
+
+	if w := ht.Weight(u); w >= 1.0 {
+		t.Errorf("expected benched upstream to have reduced weight, got %v", w)
+	}
+
+	ht.RecordSuccess(u, 10*time.Millisecond)
+	if w := ht.Weight(u); w <= 0.05 {
+		t.Errorf("expected weight to recover after a success, got %v", w)
+	}
+}
+
+func TestHealthTrackerWeightedPickReturnsDistinctUpstreams(t *testing.T) {
+	ht := NewHealthTracker()
+	candidates := []config.UpstreamServer{
+		{Address: "10.0.0.1", Protocol: "udp", Port: 53},
+		{Address: "10.0.0.2", Protocol: "udp", Port: 53},
+		{Address: "10.0.0.3", Protocol: "udp", Port: 53},
+	}
+
+	picked := ht.WeightedPick(candidates, 2)
+	if len(picked) != 2 {
+		t.Fatalf("expected 2 upstreams, got %d", len(picked))
+	}
+	if picked[0] == picked[1] {
+		t.Errorf("expected distinct upstreams, got duplicate %v", picked[0])
+	}
+}
+
+func TestHealthTrackerWeightedPickFewerThanN(t *testing.T) {
+	ht := NewHealthTracker()
+	candidates := []config.UpstreamServer{{Address: "10.0.0.1", Protocol: "udp", Port: 53}}
+
+	picked := ht.WeightedPick(candidates, 2)
+	if len(picked) != 1 {
+		t.Fatalf("expected all candidates returned when fewer than n, got %d", len(picked))
+	}
+}
+
+func TestHealthTrackerBenchDecaysOverTime(t *testing.T) {
+	ht := NewHealthTracker()
+	u := config.UpstreamServer{Address: "10.0.0.1", Protocol: "udp", Port: 53}
+	h := ht.entry(u)
+	h.consecutiveFailures = benchThreshold
+
+	if w := ht.Weight(u); w != 0.05 {
+		t.Fatalf("expected a freshly benched upstream to be at the floor weight, got %v", w)
+	}
+
+	// Backdate the failure well past a few half-lives instead of sleeping.
+	h.lastFailure = time.Now().Add(-4 * failureHalfLife)
+	if w := ht.Weight(u); w <= 0.05 {
+		t.Errorf("expected the bench to have decayed after several half-lives, got %v", w)
+	}
+}
+
+func TestHealthTrackerRecordProbeQuarantinesAfterThreshold(t *testing.T) {
+	ht := NewHealthTracker()
+	u := config.UpstreamServer{Address: "10.0.0.1", Protocol: "udp", Port: 53}
+	cfg := config.UpstreamHealthcheckConfig{FailureThreshold: 2, BackoffMax: 60}
+
+	ht.RecordProbe(u, errProbe, 0, cfg)
+	if ht.Quarantined(u) {
+		t.Fatal("expected no quarantine before reaching the failure threshold")
+	}
+
+	ht.RecordProbe(u, errProbe, 0, cfg)
+	if !ht.Quarantined(u) {
+		t.Fatal("expected quarantine once the failure threshold is reached")
+	}
+}
+
+func TestHealthTrackerRecordProbeSuccessClearsQuarantine(t *testing.T) {
+	ht := NewHealthTracker()
+	u := config.UpstreamServer{Address: "10.0.0.1", Protocol: "udp", Port: 53}
+	cfg := config.UpstreamHealthcheckConfig{FailureThreshold: 1}
+
+	ht.RecordProbe(u, errProbe, 0, cfg)
+	if !ht.Quarantined(u) {
+		t.Fatal("expected quarantine after a single failure at threshold 1")
+	}
+
+	ht.RecordProbe(u, nil, 5*time.Millisecond, cfg)
+	if ht.Quarantined(u) {
+		t.Error("expected a successful probe to clear the quarantine")
+	}
+}
+
+func TestHealthTrackerStatusReportsQuarantineAndLatency(t *testing.T) {
+	ht := NewHealthTracker()
+	u := config.UpstreamServer{Address: "10.0.0.1", Protocol: "udp", Port: 53}
+	unknown := config.UpstreamServer{Address: "10.0.0.2", Protocol: "udp", Port: 53}
+
+	ht.RecordProbe(u, nil, 20*time.Millisecond, config.UpstreamHealthcheckConfig{})
+
+	statuses := ht.Status([]config.UpstreamServer{u, unknown})
+	if len(statuses) != 2 {
+		t.Fatalf("expected 2 statuses, got %d", len(statuses))
+	}
+	if statuses[0].Quarantined {
+		t.Error("expected the healthy upstream to not be quarantined")
+	}
+	if statuses[1].Upstream != unknown {
+		t.Errorf("expected the second status to describe the unknown upstream, got %+v", statuses[1])
+	}
+}
+
+func TestHealthTrackerAnySuccessWithin(t *testing.T) {
+	ht := NewHealthTracker()
+	u := config.UpstreamServer{Address: "10.0.0.1", Protocol: "udp", Port: 53}
+
+	if ht.AnySuccessWithin(time.Minute) {
+		t.Error("expected no recent success before any query has completed")
+	}
+
+	ht.RecordSuccess(u, 10*time.Millisecond)
+	if !ht.AnySuccessWithin(time.Minute) {
+		t.Error("expected a recent success right after RecordSuccess")
+	}
+	if ht.AnySuccessWithin(0) {
+		t.Error("expected a zero window to never count a past success as recent")
+	}
+}
+
+var errProbe = fmt.Errorf("probe failed")
+
+func TestHandlerForwardFailoverSkipsQuarantinedUpstream(t *testing.T) {
+	broken := newFakeUpstream(t, 0, true)
+	healthy := newFakeUpstream(t, 0, false)
+
+	cfg := &config.Config{
+		HomeDNSDomain: "home",
+		DNS: config.DNSConfig{
+			TTL:              300,
+			EnableRecursion:  true,
+			UpstreamStrategy: "failover",
+		},
+		Upstream: []config.UpstreamServer{broken.upstream(), healthy.upstream()},
+	}
+
+	handler := NewHandler(cfg)
+	handler.resolver.Health().RecordProbe(broken.upstream(), errProbe, 0, config.UpstreamHealthcheckConfig{FailureThreshold: 1})
+
+	query := &dns.Msg{}
+	query.SetQuestion("example.com.", dns.TypeA)
+
+	answers := handler.forwardUpstream(nil, nil, query.Question[0], cfg.Upstream)
+	if len(answers) == 0 {
+		t.Fatal("expected an answer from the healthy, non-quarantined upstream")
+	}
+}
+
+func TestHandlerForwardFailoverFallsBackWhenAllQuarantined(t *testing.T) {
+	healthy := newFakeUpstream(t, 0, false)
+
+	cfg := &config.Config{
+		HomeDNSDomain: "home",
+		DNS: config.DNSConfig{
+			TTL:              300,
+			EnableRecursion:  true,
+			UpstreamStrategy: "failover",
+		},
+		Upstream: []config.UpstreamServer{healthy.upstream()},
+	}
+
+	handler := NewHandler(cfg)
+	handler.resolver.Health().RecordProbe(healthy.upstream(), errProbe, 0, config.UpstreamHealthcheckConfig{FailureThreshold: 1})
+
+	query := &dns.Msg{}
+	query.SetQuestion("example.com.", dns.TypeA)
+
+	answers := handler.forwardUpstream(nil, nil, query.Question[0], cfg.Upstream)
+	if len(answers) == 0 {
+		t.Fatal("expected failover to still try the only (quarantined) upstream rather than give up")
+	}
+}
+
+func TestHealthCheckerProbeAllRecordsOutcomes(t *testing.T) {
+	healthy := newFakeUpstream(t, 0, false)
+
+	cfg := &config.Config{
+		HomeDNSDomain: "home",
+		DNS:           config.DNSConfig{TTL: 300, EnableRecursion: true},
+		Upstream:      []config.UpstreamServer{healthy.upstream()},
+	}
+
+	handler := NewHandler(cfg)
+	checker := NewHealthChecker(handler, config.UpstreamHealthcheckConfig{ProbeDomain: "example.com."})
+	checker.probeAll()
+
+	// probeAll fans out asynchronously; poll briefly for the recorded result.
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		statuses := handler.resolver.Health().Status(cfg.Upstream)
+		if statuses[0].AvgLatency > 0 || !statuses[0].QuarantinedUntil.IsZero() {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	statuses := handler.resolver.Health().Status(cfg.Upstream)
+	if statuses[0].AvgLatency <= 0 {
+		t.Error("expected the probe to have recorded a successful latency sample")
+	}
+}
+
+func TestHandlerForwardFastestRacesAllUpstreams(t *testing.T) {
+	slow := newFakeUpstream(t, 50*time.Millisecond, false)
+	fast := newFakeUpstream(t, 0, false)
+
+	cfg := &config.Config{
+		HomeDNSDomain: "home",
+		DNS: config.DNSConfig{
+			TTL:              300,
+			EnableRecursion:  true,
+			UpstreamStrategy: "fastest",
+		},
+		Upstream: []config.UpstreamServer{slow.upstream(), fast.upstream()},
+	}
+
+	handler := NewHandler(cfg)
+	query := &dns.Msg{}
+	query.SetQuestion("example.com.", dns.TypeA)
+
+	answers := handler.forwardUpstream(nil, nil, query.Question[0], cfg.Upstream)
+	if len(answers) == 0 {
+		t.Fatal("expected an answer racing all configured upstreams")
+	}
+}
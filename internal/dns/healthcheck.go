@@ -0,0 +1,92 @@
+package dns
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/risadams/Pocket-Concierge/internal/config"
+)
+
+// Defaults applied when the corresponding UpstreamHealthcheckConfig field is
+// left at its zero value.
+const (
+	defaultHealthcheckInterval = 30 * time.Second
+	defaultProbeDomain         = "example.com."
+)
+
+// HealthChecker actively probes every configured upstream with a known
+// query on an interval, independent of real client traffic, so a dead
+// upstream is quarantined (see HealthTracker.RecordProbe) before it ever
+// fails a real query. It mirrors bootstrap.Resolver's StartRefresh/Stop
+// ticker pattern.
+type HealthChecker struct {
+	handler *Handler
+	cfg     config.UpstreamHealthcheckConfig
+
+	stopOnce sync.Once
+	stopCh   chan struct{}
+}
+
+// NewHealthChecker creates a HealthChecker that probes h.config.Upstream on
+// behalf of h. Start must be called to begin probing.
+func NewHealthChecker(h *Handler, cfg config.UpstreamHealthcheckConfig) *HealthChecker {
+	return &HealthChecker{handler: h, cfg: cfg, stopCh: make(chan struct{})}
+}
+
+// Start begins the background probing goroutine. It is a no-op if cfg is
+// not enabled. Stop must be called to release the goroutine.
+func (c *HealthChecker) Start() {
+	if !c.cfg.Enabled {
+		return
+	}
+
+	interval := defaultHealthcheckInterval
+	if c.cfg.Interval > 0 {
+		interval = time.Duration(c.cfg.Interval) * time.Second
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				c.probeAll()
+			case <-c.stopCh:
+				return
+			}
+		}
+	}()
+}
+
+// probeAll queries every configured upstream concurrently with an A query
+// for cfg.ProbeDomain, recording each outcome against the shared
+// HealthTracker. It bypasses h.chain entirely: a probe is never answered
+// from cache, blocklists, or local records, only the upstream itself.
+func (c *HealthChecker) probeAll() {
+	probeDomain := c.cfg.ProbeDomain
+	if probeDomain == "" {
+		probeDomain = defaultProbeDomain
+	}
+
+	query := &dns.Msg{}
+	query.SetQuestion(probeDomain, dns.TypeA)
+	query.RecursionDesired = true
+
+	for _, upstream := range c.handler.config.Upstream {
+		upstream := upstream
+		go func() {
+			start := time.Now()
+			_, err := c.handler.secureClient.Query(context.Background(), query.Copy(), upstream)
+			c.handler.resolver.Health().RecordProbe(upstream, err, time.Since(start), c.cfg)
+		}()
+	}
+}
+
+// Stop terminates the background probing goroutine, if running.
+func (c *HealthChecker) Stop() {
+	c.stopOnce.Do(func() { close(c.stopCh) })
+}
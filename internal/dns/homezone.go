@@ -0,0 +1,76 @@
+package dns
+
+import (
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/risadams/Pocket-Concierge/internal/config"
+)
+
+const (
+	soaRefresh = 3600
+	soaRetry   = 600
+	soaExpire  = 86400
+	soaMinimum = 300
+)
+
+// inHomeZone reports whether name falls inside the authoritative
+// cfg.HomeDNSDomain zone, so a miss there should be answered
+// authoritatively (NXDOMAIN/NODATA + SOA) rather than forwarded upstream.
+func inHomeZone(name, homeDomain string) bool {
+	if homeDomain == "" {
+		return false
+	}
+	return matchesZone(name, homeDomain)
+}
+
+// reverseZoneFor reports whether name (a PTR qname) falls inside one of the
+// configured ReverseZones, returning the matched zone so its SOA can be
+// synthesized. Mirrors inHomeZone's suffix matching for the forward zone.
+func reverseZoneFor(name string, zones []string) (zone string, authoritative bool) {
+	for _, z := range zones {
+		if matchesZone(name, z) {
+			return z, true
+		}
+	}
+	return "", false
+}
+
+// matchesZone reports whether name is equal to, or a subdomain of, zone.
+func matchesZone(name, zone string) bool {
+	normalized := strings.ToLower(strings.TrimSuffix(name, "."))
+	zone = strings.ToLower(strings.TrimSuffix(zone, "."))
+
+	return normalized == zone || strings.HasSuffix(normalized, "."+zone)
+}
+
+// homeSOA synthesizes the authority-section SOA RR for the home zone.
+func homeSOA(cfg *config.Config, serialSource time.Time) *dns.SOA {
+	return zoneSOA(cfg, cfg.HomeDNSDomain, serialSource)
+}
+
+// zoneSOA synthesizes the authority-section SOA RR for zone (the home zone
+// or one of cfg.ReverseZones). The serial is derived from serialSource (the
+// handler's construction time, as a stand-in for "config load time" since
+// tests construct Config literals directly rather than through LoadConfig)
+// so it changes across restarts without requiring any persisted state.
+func zoneSOA(cfg *config.Config, zone string, serialSource time.Time) *dns.SOA {
+	zoneName := dns.Fqdn(zone)
+
+	return &dns.SOA{
+		Hdr: dns.RR_Header{
+			Name:   zoneName,
+			Rrtype: dns.TypeSOA,
+			Class:  dns.ClassINET,
+			Ttl:    uint32(cfg.DNS.TTL),
+		},
+		Ns:      "ns." + zoneName,
+		Mbox:    "hostmaster." + zoneName,
+		Serial:  uint32(serialSource.Unix()),
+		Refresh: soaRefresh,
+		Retry:   soaRetry,
+		Expire:  soaExpire,
+		Minttl:  soaMinimum,
+	}
+}
@@ -0,0 +1,199 @@
+package dns
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/risadams/Pocket-Concierge/internal/config"
+)
+
+// defaultHostsRefreshInterval is used when HostsRefreshInterval is unset.
+const defaultHostsRefreshInterval = time.Hour
+
+// parseHostsFile parses r in the standard hosts(5) format: "ip hostname
+// [alias...]", with "#" starting a comment and blank lines ignored.
+// Multiple lines naming the same hostname are merged into a single
+// HostEntry, so separate IPv4 and IPv6 lines for one host (a common
+// hosts(5) convention) collect into one entry's IPv4/IPv6 lists. Wildcard
+// hostnames such as "*.lan" pass through unchanged, for HostCache to match
+// as a domain suffix.
+func parseHostsFile(r io.Reader) ([]config.HostEntry, error) {
+	byName := make(map[string]*config.HostEntry)
+	var order []string
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if idx := strings.IndexByte(line, '#'); idx >= 0 {
+			line = line[:idx]
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+
+		ip := net.ParseIP(fields[0])
+		if ip == nil {
+			continue
+		}
+		isIPv4 := ip.To4() != nil
+
+		for _, name := range fields[1:] {
+			name = strings.ToLower(name)
+			entry, exists := byName[name]
+			if !exists {
+				entry = &config.HostEntry{Hostname: name}
+				byName[name] = entry
+				order = append(order, name)
+			}
+			if isIPv4 {
+				entry.IPv4 = appendUniqueIP(entry.IPv4, fields[0])
+			} else {
+				entry.IPv6 = appendUniqueIP(entry.IPv6, fields[0])
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to scan hosts file: %w", err)
+	}
+
+	entries := make([]config.HostEntry, 0, len(order))
+	for _, name := range order {
+		entries = append(entries, *byName[name])
+	}
+	return entries, nil
+}
+
+// appendUniqueIP appends ip to list unless it's already present.
+func appendUniqueIP(list []string, ip string) []string {
+	for _, existing := range list {
+		if existing == ip {
+			return list
+		}
+	}
+	return append(list, ip)
+}
+
+// LoadHostsFile reads and parses the hosts(5)-format file at path.
+func (hc *HostCache) LoadHostsFile(path string) ([]config.HostEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open hosts file %s: %w", path, err)
+	}
+	defer f.Close()
+
+	entries, err := parseHostsFile(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse hosts file %s: %w", path, err)
+	}
+	return entries, nil
+}
+
+// fetchHostsURL retrieves and parses the hosts(5)-format document at url.
+func fetchHostsURL(url string) ([]config.HostEntry, error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch hosts url %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("hosts url %s returned %s", url, resp.Status)
+	}
+
+	entries, err := parseHostsFile(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse hosts url %s: %w", url, err)
+	}
+	return entries, nil
+}
+
+// LoadHostsURL is the public entry point for registering a hosts URL: it
+// delegates to startURLRefresh and returns that call's initial-fetch error
+// (nil if a refresh for url was already running, since that means an
+// initial fetch already happened).
+func (hc *HostCache) LoadHostsURL(url string, interval time.Duration) error {
+	return hc.startURLRefresh(url, interval)
+}
+
+// setURLEntries stores the latest parsed entries for a hosts URL.
+func (hc *HostCache) setURLEntries(url string, entries []config.HostEntry) {
+	hc.urlMutex.Lock()
+	hc.urlEntries[url] = entries
+	hc.urlMutex.Unlock()
+}
+
+// urlEntriesSnapshot returns a copy of the most recently fetched entries
+// for url, or nil if it hasn't been fetched yet.
+func (hc *HostCache) urlEntriesSnapshot(url string) []config.HostEntry {
+	hc.urlMutex.Lock()
+	defer hc.urlMutex.Unlock()
+
+	entries := hc.urlEntries[url]
+	if entries == nil {
+		return nil
+	}
+	out := make([]config.HostEntry, len(entries))
+	copy(out, entries)
+	return out
+}
+
+// startURLRefresh ensures a background refresh goroutine is running for
+// url, starting it (guarded so it only ever runs once per url) along with
+// a synchronous initial fetch, so the entries are available for the very
+// next Rebuild rather than only after the first tick. It returns the
+// initial fetch's error; the background refresh keeps retrying regardless
+// and a later retry's outcome isn't reported here.
+func (hc *HostCache) startURLRefresh(url string, interval time.Duration) error {
+	hc.urlRefreshMu.Lock()
+	if hc.urlRefreshStarted == nil {
+		hc.urlRefreshStarted = make(map[string]bool)
+	}
+	if hc.urlRefreshStarted[url] {
+		hc.urlRefreshMu.Unlock()
+		return nil
+	}
+	hc.urlRefreshStarted[url] = true
+	hc.urlRefreshMu.Unlock()
+
+	entries, err := fetchHostsURL(url)
+	if err == nil {
+		hc.setURLEntries(url, entries)
+	}
+
+	if interval <= 0 {
+		interval = defaultHostsRefreshInterval
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if entries, err := fetchHostsURL(url); err == nil {
+					hc.setURLEntries(url, entries)
+					hc.Rebuild(hc.currentConfig())
+				}
+			case <-hc.stopCh:
+				return
+			}
+		}
+	}()
+
+	return err
+}
+
+// Stop terminates every background hosts-URL refresh goroutine.
+func (hc *HostCache) Stop() {
+	hc.stopOnce.Do(func() { close(hc.stopCh) })
+}
@@ -0,0 +1,245 @@
+package dns
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/risadams/Pocket-Concierge/internal/config"
+)
+
+func TestParseHostsFile(t *testing.T) {
+	data := `
+# a comment line
+127.0.0.1 localhost
+192.168.1.10 nas nas.lan   # trailing comment
+192.168.1.10 nas2
+
+2001:db8::10 nas nas.lan
+
+0.0.0.0 *.lan
+`
+
+	entries, err := parseHostsFile(strings.NewReader(data))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	byName := make(map[string]config.HostEntry)
+	for _, e := range entries {
+		byName[e.Hostname] = e
+	}
+
+	nas, ok := byName["nas"]
+	if !ok {
+		t.Fatal("expected an entry for 'nas'")
+	}
+	if len(nas.IPv4) != 1 || nas.IPv4[0] != "192.168.1.10" {
+		t.Errorf("expected nas IPv4 [192.168.1.10], got %v", nas.IPv4)
+	}
+	if len(nas.IPv6) != 1 || nas.IPv6[0] != "2001:db8::10" {
+		t.Errorf("expected nas IPv6 [2001:db8::10], got %v", nas.IPv6)
+	}
+
+	if _, ok := byName["nas2"]; !ok {
+		t.Error("expected an entry for 'nas2'")
+	}
+
+	if _, ok := byName["*.lan"]; !ok {
+		t.Error("expected the wildcard hostname '*.lan' to pass through unchanged")
+	}
+}
+
+func TestParseHostsFileIgnoresMalformedLines(t *testing.T) {
+	data := "not-an-ip somehost\n192.168.1.1\n"
+
+	entries, err := parseHostsFile(strings.NewReader(data))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("expected no entries from malformed lines, got %v", entries)
+	}
+}
+
+func TestHostCache_LoadHostsFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "hosts")
+	if err := os.WriteFile(path, []byte("10.0.0.5 fileserver\n"), 0o644); err != nil {
+		t.Fatalf("failed to write temp hosts file: %v", err)
+	}
+
+	cache := NewHostCache(&config.Config{DNS: config.DNSConfig{TTL: 300}, HomeDNSDomain: "home"})
+
+	entries, err := cache.LoadHostsFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Hostname != "fileserver" {
+		t.Fatalf("expected a single 'fileserver' entry, got %v", entries)
+	}
+}
+
+func TestHostCache_LoadHostsFileMissing(t *testing.T) {
+	cache := NewHostCache(&config.Config{DNS: config.DNSConfig{TTL: 300}, HomeDNSDomain: "home"})
+
+	if _, err := cache.LoadHostsFile(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Fatal("expected an error for a missing hosts file")
+	}
+}
+
+func TestHostCache_RebuildMergesHostsFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "hosts")
+	if err := os.WriteFile(path, []byte("10.0.0.5 fileserver\n"), 0o644); err != nil {
+		t.Fatalf("failed to write temp hosts file: %v", err)
+	}
+
+	cfg := &config.Config{
+		DNS:           config.DNSConfig{TTL: 300},
+		HomeDNSDomain: "home",
+		HostsFiles:    []string{path},
+	}
+
+	cache := NewHostCache(cfg)
+	defer cache.Stop()
+
+	entry, found := cache.Lookup("fileserver")
+	if !found {
+		t.Fatal("expected to find 'fileserver' from HostsFiles")
+	}
+	if len(entry.IPv4) != 1 || entry.IPv4[0] != "10.0.0.5" {
+		t.Errorf("expected IPv4 [10.0.0.5], got %v", entry.IPv4)
+	}
+}
+
+func TestHostCache_RebuildMergesHostsURL(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("10.0.0.9 urlhost\n"))
+	}))
+	defer srv.Close()
+
+	cfg := &config.Config{
+		DNS:           config.DNSConfig{TTL: 300},
+		HomeDNSDomain: "home",
+		HostsURLs:     []string{srv.URL},
+	}
+
+	cache := NewHostCache(cfg)
+	defer cache.Stop()
+
+	entry, found := cache.Lookup("urlhost")
+	if !found {
+		t.Fatal("expected to find 'urlhost' from HostsURLs")
+	}
+	if len(entry.IPv4) != 1 || entry.IPv4[0] != "10.0.0.9" {
+		t.Errorf("expected IPv4 [10.0.0.9], got %v", entry.IPv4)
+	}
+}
+
+func TestHostCache_LoadHostsURLUnreachable(t *testing.T) {
+	cache := NewHostCache(&config.Config{DNS: config.DNSConfig{TTL: 300}, HomeDNSDomain: "home"})
+	defer cache.Stop()
+
+	if err := cache.LoadHostsURL("http://127.0.0.1:0/hosts", time.Hour); err == nil {
+		t.Fatal("expected an error for an unreachable hosts URL")
+	}
+}
+
+func TestHostCache_SourcePrecedence(t *testing.T) {
+	dir := t.TempDir()
+	filePath := filepath.Join(dir, "hosts")
+	if err := os.WriteFile(filePath, []byte("10.0.0.1 shared\n"), 0o644); err != nil {
+		t.Fatalf("failed to write temp hosts file: %v", err)
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("10.0.0.2 shared\n"))
+	}))
+	defer srv.Close()
+
+	cfg := &config.Config{
+		DNS:           config.DNSConfig{TTL: 300},
+		HomeDNSDomain: "home",
+		Hosts: []config.HostEntry{
+			{Hostname: "shared", IPv4: []string{"10.0.0.0"}},
+		},
+		HostsFiles: []string{filePath},
+		HostsURLs:  []string{srv.URL},
+	}
+
+	cache := NewHostCache(cfg)
+	defer cache.Stop()
+
+	entry, found := cache.Lookup("shared")
+	if !found {
+		t.Fatal("expected to find 'shared'")
+	}
+	if len(entry.IPv4) != 1 || entry.IPv4[0] != "10.0.0.0" {
+		t.Errorf("expected the YAML-defined IPv4 [10.0.0.0] to win, got %v", entry.IPv4)
+	}
+}
+
+func TestHostCache_WildcardLookup(t *testing.T) {
+	cfg := &config.Config{
+		DNS:           config.DNSConfig{TTL: 300},
+		HomeDNSDomain: "home",
+		Hosts: []config.HostEntry{
+			{Hostname: "*.lan", IPv4: []string{"192.168.1.1"}},
+			{Hostname: "*.dev.lan", IPv4: []string{"192.168.1.2"}},
+		},
+	}
+
+	cache := NewHostCache(cfg)
+
+	entry, found := cache.Lookup("printer.lan")
+	if !found {
+		t.Fatal("expected 'printer.lan' to match the '*.lan' wildcard")
+	}
+	if entry.IPv4[0] != "192.168.1.1" {
+		t.Errorf("expected IPv4 192.168.1.1, got %v", entry.IPv4)
+	}
+
+	entry, found = cache.Lookup("app.dev.lan")
+	if !found {
+		t.Fatal("expected 'app.dev.lan' to match a wildcard")
+	}
+	if entry.IPv4[0] != "192.168.1.2" {
+		t.Errorf("expected the more specific '*.dev.lan' wildcard to win, got %v", entry.IPv4)
+	}
+
+	if _, found := cache.Lookup("example.com"); found {
+		t.Error("expected 'example.com' not to match any wildcard")
+	}
+}
+
+func TestHostCache_WildcardLookupRecords(t *testing.T) {
+	cfg := &config.Config{
+		DNS:           config.DNSConfig{TTL: 300},
+		HomeDNSDomain: "home",
+		Hosts: []config.HostEntry{
+			{Hostname: "*.lan", IPv4: []string{"192.168.1.1"}, IPv6: []string{"2001:db8::1"}},
+		},
+	}
+
+	cache := NewHostCache(cfg)
+
+	records := cache.LookupRecords("printer.lan", dns.TypeA)
+	if len(records) != 1 {
+		t.Fatalf("expected 1 A record for a wildcard match, got %d", len(records))
+	}
+	a, ok := records[0].(*dns.A)
+	if !ok || a.A.String() != "192.168.1.1" {
+		t.Errorf("expected A record 192.168.1.1, got %v", records[0])
+	}
+
+	records = cache.LookupRecords("printer.lan", dns.TypeAAAA)
+	if len(records) != 1 {
+		t.Fatalf("expected 1 AAAA record for a wildcard match, got %d", len(records))
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !windows
+
+package dns
+
+// defaultSystemHostsPath returns the platform's standard hosts(5) file,
+// loaded automatically by Rebuild alongside any configured HostsFiles.
+func defaultSystemHostsPath() string {
+	return "/etc/hosts"
+}
@@ -0,0 +1,18 @@
+//go:build windows
+
+package dns
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// defaultSystemHostsPath returns the platform's standard hosts(5) file,
+// loaded automatically by Rebuild alongside any configured HostsFiles.
+func defaultSystemHostsPath() string {
+	systemRoot := os.Getenv("SystemRoot")
+	if systemRoot == "" {
+		systemRoot = `C:\Windows`
+	}
+	return filepath.Join(systemRoot, "System32", "drivers", "etc", "hosts")
+}
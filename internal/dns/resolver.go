@@ -1,22 +1,69 @@
 package dns
 
 import (
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
 	"github.com/miekg/dns"
+	"github.com/risadams/Pocket-Concierge/internal/blocking"
 	"github.com/risadams/Pocket-Concierge/internal/config"
+	"github.com/risadams/Pocket-Concierge/internal/querylog"
 )
 
 // Resolver handles local hostname resolution
 type Resolver struct {
-	config    *config.Config
+	configMu sync.RWMutex
+	config   *config.Config
+
 	hostCache *HostCache
+	blocker   *blocking.Blocker
+	health    *HealthTracker
+	querylog  querylog.Logger
 }
 
 // NewResolver creates a new resolver
 func NewResolver(cfg *config.Config) *Resolver {
-	return &Resolver{
+	r := &Resolver{
 		config:    cfg,
 		hostCache: NewHostCache(cfg),
+		health:    NewHealthTracker(),
+	}
+
+	if len(cfg.Blocking.Groups) > 0 {
+		if blocker, err := blocking.NewBlocker(cfg.Blocking); err == nil {
+			blocker.StartRefresh()
+			r.blocker = blocker
+		}
 	}
+
+	if logger, err := querylog.New(cfg.QueryLog); err == nil {
+		r.querylog = logger
+	}
+
+	return r
+}
+
+// cfg returns the currently active config under a read lock, so hot
+// reloads from ApplyConfig never race with in-flight queries.
+func (r *Resolver) cfg() *config.Config {
+	r.configMu.RLock()
+	defer r.configMu.RUnlock()
+	return r.config
+}
+
+// ApplyConfig atomically swaps in a newly loaded config: it rebuilds
+// hostCache under its own lock and swaps the config pointer read by every
+// other resolver method, so queries in flight either see the old config
+// consistently or the new one, never a mix.
+func (r *Resolver) ApplyConfig(cfg *config.Config) {
+	r.hostCache.Rebuild(cfg)
+
+	r.configMu.Lock()
+	r.config = cfg
+	r.configMu.Unlock()
 }
 
 // ResolveFast attempts to resolve a hostname using pre-built DNS records
@@ -29,13 +76,171 @@ func (r *Resolver) ResolveLocal(hostname string) (*config.HostEntry, bool) {
 	return r.hostCache.Lookup(hostname)
 }
 
+// LookupPTR attempts reverse-DNS resolution using pre-built PTR records.
+func (r *Resolver) LookupPTR(reverseName string) []dns.RR {
+	return r.hostCache.LookupPTR(reverseName)
+}
+
 // GetAllHosts returns all configured hosts
 func (r *Resolver) GetAllHosts() []config.HostEntry {
-	return r.config.Hosts
+	return r.cfg().Hosts
 }
 
 // AddHost adds a new host entry (for future dynamic configuration)
 func (r *Resolver) AddHost(host config.HostEntry) {
-	r.config.Hosts = append(r.config.Hosts, host)
-	r.hostCache.Rebuild(r.config) // Rebuild cache when adding hosts
+	cfg := r.cfg()
+	cfg.Hosts = append(cfg.Hosts, host)
+	r.hostCache.Rebuild(cfg) // Rebuild cache when adding hosts
+}
+
+// BlockingAnswer consults the blocking stage for hostname, restricted to the
+// blocklist Groups enforced for clientGroup (see BlockingConfig.GroupsBlock).
+// It is called after ResolveFast and before forwarding upstream. blocked
+// reports whether the name matched the compiled block/allow set; answers
+// holds the records to return for A/AAAA queries (empty for NXDOMAIN or any
+// other qtype).
+func (r *Resolver) BlockingAnswer(hostname string, qtype uint16, clientGroup string) (answers []dns.RR, blocked bool) {
+	if r.blocker == nil || !r.blocker.IsBlockedForGroup(hostname, clientGroup) {
+		return nil, false
+	}
+
+	if qtype != dns.TypeA && qtype != dns.TypeAAAA {
+		return nil, true
+	}
+
+	ttl := uint32(r.cfg().DNS.TTL)
+
+	switch r.blocker.Action() {
+	case blocking.ActionZeroIP:
+		return zeroIPAnswer(hostname, qtype, ttl), true
+	case blocking.ActionSinkhole:
+		return sinkholeAnswer(hostname, qtype, ttl, r.blocker.SinkholeIPv4(), r.blocker.SinkholeIPv6()), true
+	default:
+		return nil, true
+	}
+}
+
+// ClientGroupFor returns the client group addr belongs to, per
+// BlockingConfig.ClientGroups, or "default" if blocking is disabled or addr
+// matches no configured group.
+func (r *Resolver) ClientGroupFor(addr net.Addr) string {
+	if r.blocker == nil {
+		return "default"
+	}
+	return r.blocker.ClientGroupFor(addr)
+}
+
+func zeroIPAnswer(hostname string, qtype uint16, ttl uint32) []dns.RR {
+	if qtype == dns.TypeA {
+		return sinkholeAnswer(hostname, qtype, ttl, "0.0.0.0", "")
+	}
+	return sinkholeAnswer(hostname, qtype, ttl, "", "::")
+}
+
+func sinkholeAnswer(hostname string, qtype uint16, ttl uint32, ipv4, ipv6 string) []dns.RR {
+	name := dns.Fqdn(hostname)
+
+	if qtype == dns.TypeA && ipv4 != "" {
+		if ip := net.ParseIP(ipv4).To4(); ip != nil {
+			return []dns.RR{&dns.A{
+				Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: ttl},
+				A:   ip,
+			}}
+		}
+	}
+
+	if qtype == dns.TypeAAAA && ipv6 != "" {
+		if ip := net.ParseIP(ipv6).To16(); ip != nil {
+			return []dns.RR{&dns.AAAA{
+				Hdr:  dns.RR_Header{Name: name, Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: ttl},
+				AAAA: ip,
+			}}
+		}
+	}
+
+	return nil
+}
+
+// LogQuery records a completed query, tagged with the source that answered
+// it (one of the querylog.Source* constants), with the configured query
+// log sinks, if any are configured. It is a no-op when query logging is
+// disabled.
+func (r *Resolver) LogQuery(client net.Addr, q dns.Question, resp *dns.Msg, upstream, source string, latency time.Duration) {
+	if r.querylog == nil {
+		return
+	}
+	r.querylog.OnQuery(client, q, resp, upstream, source, latency)
+}
+
+// LogUpstreamError records a failed query attempt against upstream that
+// never produced a response, with the configured query log sinks. It is a
+// no-op when query logging is disabled.
+func (r *Resolver) LogUpstreamError(upstream string) {
+	if r.querylog == nil {
+		return
+	}
+	r.querylog.OnUpstreamError(upstream)
+}
+
+// MetricsHandler returns the configured query log's Prometheus metrics
+// handler, or nil if query logging is disabled or no "prometheus" sink is
+// configured.
+func (r *Resolver) MetricsHandler() http.Handler {
+	mh, ok := r.querylog.(querylog.MetricsHandlerer)
+	if !ok {
+		return nil
+	}
+	return mh.MetricsHandler()
+}
+
+// StatsHandler returns the configured query log's top-N stats handler, or
+// nil if query logging is disabled or no "memory" sink is configured.
+func (r *Resolver) StatsHandler() http.Handler {
+	sh, ok := r.querylog.(querylog.StatsHandlerer)
+	if !ok {
+		return nil
+	}
+	return sh.StatsHandler()
+}
+
+// Close releases the resources held by the configured query log sinks
+// (currently the "file" sink's background writer and open file handle),
+// draining any entries queued before the call returns. It is a no-op when
+// query logging is disabled or no configured sink needs cleanup.
+func (r *Resolver) Close() error {
+	c, ok := r.querylog.(querylog.Closer)
+	if !ok {
+		return nil
+	}
+	return c.Close()
+}
+
+// Health returns the resolver's per-upstream health tracker, used by the
+// parallel-best forwarding strategy to weight upstream selection.
+func (r *Resolver) Health() *HealthTracker {
+	return r.health
+}
+
+// UpstreamsFor returns the upstream servers that should handle a query for
+// hostname, walking the name from longest to shortest label suffix against
+// the configured Conditional routing groups. It falls back to the default
+// Upstream list when no conditional mapping matches.
+func (r *Resolver) UpstreamsFor(hostname string) []config.UpstreamServer {
+	cfg := r.cfg()
+
+	if len(cfg.Conditional) == 0 {
+		return cfg.Upstream
+	}
+
+	normalized := strings.ToLower(strings.TrimSuffix(hostname, "."))
+	labels := strings.Split(normalized, ".")
+
+	for i := 0; i < len(labels); i++ {
+		suffix := strings.Join(labels[i:], ".")
+		if upstreams, ok := cfg.Conditional[suffix]; ok {
+			return upstreams
+		}
+	}
+
+	return cfg.Upstream
 }
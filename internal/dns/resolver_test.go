@@ -66,19 +66,19 @@ func TestResolverResolveFast(t *testing.T) {
 			name:          "A records found",
 			hostname:      "test.home.",
 			qtype:         dns.TypeA,
-			expectedCount: 4, // 2 IPv4 addresses x 2 (short+full name) = 4 records
+			expectedCount: 2, // 2 IPv4 addresses; "test.home" is already fully-qualified so short/full keys collide and aren't double-counted
 		},
 		{
 			name:          "AAAA records found",
 			hostname:      "test.home.",
 			qtype:         dns.TypeAAAA,
-			expectedCount: 2, // 1 IPv6 address x 2 (short+full name) = 2 records
+			expectedCount: 1, // 1 IPv6 address; "test.home" is already fully-qualified so short/full keys collide and aren't double-counted
 		},
 		{
 			name:          "IPv6 only host",
 			hostname:      "ipv6only.home.",
 			qtype:         dns.TypeAAAA,
-			expectedCount: 2, // 1 IPv6 address x 2 (short+full name) = 2 records
+			expectedCount: 1, // 1 IPv6 address; "ipv6only.home" is already fully-qualified so short/full keys collide and aren't double-counted
 		},
 		{
 			name:          "IPv6 only host queried for A",
@@ -256,10 +256,12 @@ func TestResolverAddHost(t *testing.T) {
 		t.Errorf("Expected 2 hosts after adding, got %d", len(allHosts))
 	}
 
-	// Verify DNS records were rebuilt
+	// Verify DNS records were rebuilt. "new.home" is already fully-qualified
+	// so its short/full keys collide and the 1 configured IPv4 address isn't
+	// double-counted.
 	records := resolver.ResolveFast("new.home.", dns.TypeA)
-	if len(records) != 2 {
-		t.Errorf("Expected 2 DNS record for new host, got %d", len(records))
+	if len(records) != 1 {
+		t.Errorf("Expected 1 DNS record for new host, got %d", len(records))
 	}
 }
 
@@ -307,10 +309,90 @@ func TestResolverConcurrency(t *testing.T) {
 		<-done
 	}
 
-	// Verify resolver still works after concurrent access
+	// Verify resolver still works after concurrent access. "test.home" is
+	// already fully-qualified so its short/full keys collide and the 1
+	// configured IPv4 address isn't double-counted.
 	records := resolver.ResolveFast("test.home.", dns.TypeA)
-	if len(records) != 2 {
-		t.Errorf("Expected 2 record after concurrent access, got %d", len(records))
+	if len(records) != 1 {
+		t.Errorf("Expected 1 record after concurrent access, got %d", len(records))
+	}
+}
+
+func TestResolverUpstreamsForConditional(t *testing.T) {
+	corpUpstream := config.UpstreamServer{Address: "10.0.0.1", Protocol: "udp", Port: 53}
+	homeUpstream := config.UpstreamServer{Address: "10.0.0.2", Protocol: "udp", Port: 53}
+	defaultUpstream := config.UpstreamServer{Address: "1.1.1.1", Protocol: "udp", Port: 53}
+
+	cfg := &config.Config{
+		HomeDNSDomain: "home",
+		Upstream:      []config.UpstreamServer{defaultUpstream},
+		Conditional: map[string][]config.UpstreamServer{
+			"corp.example": {corpUpstream},
+			"home":         {homeUpstream},
+		},
+	}
+
+	resolver := NewResolver(cfg)
+
+	tests := []struct {
+		name     string
+		hostname string
+		expected config.UpstreamServer
+	}{
+		{"matches conditional suffix", "db.corp.example.", corpUpstream},
+		{"matches conditional suffix exactly", "corp.example.", corpUpstream},
+		{"matches shorter home suffix", "nas.home.", homeUpstream},
+		{"falls back to default upstream", "example.com.", defaultUpstream},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstreams := resolver.UpstreamsFor(tt.hostname)
+			if len(upstreams) != 1 || upstreams[0] != tt.expected {
+				t.Errorf("UpstreamsFor(%q) = %v, expected [%v]", tt.hostname, upstreams, tt.expected)
+			}
+		})
+	}
+}
+
+func TestResolverUpstreamsForConditionalLongestMatchWins(t *testing.T) {
+	exampleUpstream := config.UpstreamServer{Address: "10.0.0.1", Protocol: "udp", Port: 53}
+	corpUpstream := config.UpstreamServer{Address: "10.0.0.2", Protocol: "tls", Port: 853}
+
+	cfg := &config.Config{
+		HomeDNSDomain: "home",
+		Conditional: map[string][]config.UpstreamServer{
+			"example":      {exampleUpstream},
+			"corp.example": {corpUpstream},
+		},
+	}
+
+	resolver := NewResolver(cfg)
+
+	// Both "example" and the more specific "corp.example" match; the longer
+	// suffix (and its own protocol) should win.
+	upstreams := resolver.UpstreamsFor("db.corp.example.")
+	if len(upstreams) != 1 || upstreams[0] != corpUpstream {
+		t.Errorf("expected the longer suffix match corp.example, got %v", upstreams)
+	}
+
+	upstreams = resolver.UpstreamsFor("host.example.")
+	if len(upstreams) != 1 || upstreams[0] != exampleUpstream {
+		t.Errorf("expected the example suffix match, got %v", upstreams)
+	}
+}
+
+func TestResolverUpstreamsForNoConditional(t *testing.T) {
+	defaultUpstream := config.UpstreamServer{Address: "1.1.1.1", Protocol: "udp", Port: 53}
+	cfg := &config.Config{
+		HomeDNSDomain: "home",
+		Upstream:      []config.UpstreamServer{defaultUpstream},
+	}
+
+	resolver := NewResolver(cfg)
+	upstreams := resolver.UpstreamsFor("anything.example.")
+	if len(upstreams) != 1 || upstreams[0] != defaultUpstream {
+		t.Errorf("expected default upstream when no conditional table configured, got %v", upstreams)
 	}
 }
 
@@ -381,3 +463,39 @@ func BenchmarkResolverConcurrentAccess(b *testing.B) {
 		}
 	})
 }
+
+func TestResolverApplyConfigAddsNewHostnames(t *testing.T) {
+	cfg := &config.Config{
+		HomeDNSDomain: "home",
+		DNS:           config.DNSConfig{TTL: 300},
+		Hosts: []config.HostEntry{
+			{Hostname: "nas.home", IPv4: []string{"192.168.1.10"}},
+		},
+	}
+
+	resolver := NewResolver(cfg)
+
+	if answers := resolver.ResolveFast("printer.home.", dns.TypeA); len(answers) != 0 {
+		t.Fatalf("expected printer.home. to be unresolvable before reload, got %v", answers)
+	}
+
+	reloaded := &config.Config{
+		HomeDNSDomain: "home",
+		DNS:           config.DNSConfig{TTL: 300},
+		Hosts: []config.HostEntry{
+			{Hostname: "nas.home", IPv4: []string{"192.168.1.10"}},
+			{Hostname: "printer.home", IPv4: []string{"192.168.1.20"}},
+		},
+	}
+
+	resolver.ApplyConfig(reloaded)
+
+	answers := resolver.ResolveFast("printer.home.", dns.TypeA)
+	if len(answers) != 1 {
+		t.Fatalf("expected printer.home. to resolve after ApplyConfig, got %v", answers)
+	}
+
+	if upstreams := resolver.UpstreamsFor("anything."); len(upstreams) != 0 {
+		t.Errorf("expected no upstreams configured, got %v", upstreams)
+	}
+}
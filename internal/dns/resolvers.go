@@ -0,0 +1,162 @@
+package dns
+
+import (
+	"net"
+
+	"github.com/miekg/dns"
+	"github.com/risadams/Pocket-Concierge/internal/querylog"
+)
+
+// ddrResolver answers Discovery of Designated Resolvers (RFC 9462) queries
+// and otherwise defers to Next.
+type ddrResolver struct{ baseResolver }
+
+func (r *ddrResolver) Resolve(h *Handler, req *Request) (*Response, error) {
+	if IsDDRQuery(req.Question.Name, req.Question.Qtype) {
+		return &Response{Answer: BuildDDRAnswer(h.config), Resolved: true}, nil
+	}
+	return r.resolveNext(h, req)
+}
+
+// blockingResolver answers the simple exact/subdomain blocklist
+// (config.IsBlocked) with NXDOMAIN, ahead of every other stage.
+type blockingResolver struct{ baseResolver }
+
+func (r *blockingResolver) Resolve(h *Handler, req *Request) (*Response, error) {
+	if h.config.IsBlocked(req.Question.Name) {
+		return &Response{Rcode: dns.RcodeNameError, Resolved: true}, nil
+	}
+	return r.resolveNext(h, req)
+}
+
+// hostsResolver answers from the pre-built HostCache: reverse (PTR)
+// lookups, forward A/AAAA fast-path records, and authoritative home-zone
+// misses (NXDOMAIN/NODATA + SOA). A miss that isn't in the home zone (and
+// isn't an authoritative reverse zone) defers to Next.
+type hostsResolver struct{ baseResolver }
+
+func (r *hostsResolver) Resolve(h *Handler, req *Request) (*Response, error) {
+	question := req.Question
+
+	if question.Qtype == dns.TypePTR {
+		if ptrAnswers := h.resolver.LookupPTR(question.Name); len(ptrAnswers) > 0 {
+			h.resolver.LogQuery(req.Client, question, &dns.Msg{Answer: ptrAnswers}, "", querylog.SourceLocal, 0)
+			return &Response{Answer: ptrAnswers, Resolved: true}, nil
+		}
+
+		if zone, authoritative := reverseZoneFor(question.Name, h.config.ReverseZones); authoritative {
+			resp := &Response{Rcode: dns.RcodeNameError, Ns: []dns.RR{zoneSOA(h.config, zone, h.startTime)}, Resolved: true}
+			h.resolver.LogQuery(req.Client, question, &dns.Msg{MsgHdr: dns.MsgHdr{Rcode: resp.Rcode}}, "", querylog.SourceLocal, 0)
+			return resp, nil
+		}
+	}
+
+	if localAnswers := h.resolver.ResolveFast(question.Name, question.Qtype); len(localAnswers) > 0 {
+		localAnswers = shuffleAnswers(h.config.DNS.Shuffle, question.Name, localAnswers, h.rrCounters)
+		h.resolver.LogQuery(req.Client, question, &dns.Msg{Answer: localAnswers}, "", querylog.SourceLocal, 0)
+		return &Response{Answer: localAnswers, Resolved: true}, nil
+	}
+
+	if inHomeZone(question.Name, h.config.HomeDNSDomain) {
+		resp := &Response{Ns: []dns.RR{homeSOA(h.config, h.startTime)}, Resolved: true}
+		if _, exists := h.resolver.ResolveLocal(question.Name); !exists {
+			resp.Rcode = dns.RcodeNameError
+		}
+		h.resolver.LogQuery(req.Client, question, &dns.Msg{MsgHdr: dns.MsgHdr{Rcode: resp.Rcode}}, "", querylog.SourceLocal, 0)
+		return resp, nil
+	}
+
+	return r.resolveNext(h, req)
+}
+
+// groupBlockResolver consults the grouped blocklist/allowlist stage
+// (BlockingConfig), which answers with NXDOMAIN or a configured sinkhole
+// record, ahead of upstream forwarding. Which Groups apply depends on the
+// client's group, per BlockingConfig.ClientGroups/GroupsBlock.
+type groupBlockResolver struct{ baseResolver }
+
+func (r *groupBlockResolver) Resolve(h *Handler, req *Request) (*Response, error) {
+	question := req.Question
+	clientGroup := h.resolver.ClientGroupFor(req.Client)
+
+	blockAnswers, blocked := h.resolver.BlockingAnswer(question.Name, question.Qtype, clientGroup)
+	if !blocked {
+		return r.resolveNext(h, req)
+	}
+
+	resp := &Response{Answer: blockAnswers, Resolved: true}
+	if len(blockAnswers) == 0 {
+		resp.Rcode = dns.RcodeNameError
+	}
+	h.resolver.LogQuery(req.Client, question, &dns.Msg{Answer: blockAnswers, MsgHdr: dns.MsgHdr{Rcode: resp.Rcode}}, "", querylog.SourceBlocked, 0)
+	return resp, nil
+}
+
+// conditionalResolver picks the upstream list a question should be
+// forwarded to (the conditional-routing table, falling back to the
+// default upstreams) and annotates req.Upstreams for upstreamResolver.
+// It never answers a question itself.
+type conditionalResolver struct{ baseResolver }
+
+func (r *conditionalResolver) Resolve(h *Handler, req *Request) (*Response, error) {
+	req.Upstreams = h.resolver.UpstreamsFor(req.Question.Name)
+	return r.resolveNext(h, req)
+}
+
+// clientNameResolver resolves the requesting client's own reverse-DNS name,
+// for log enrichment: first the HostCache, then upstream (cached in the
+// same answer cache the main resolver chain uses, so repeated requests from
+// the same client don't re-resolve its name on every query). It never
+// answers a question itself, and leaves req.ClientName empty when the
+// client resolves to no name anywhere.
+type clientNameResolver struct{ baseResolver }
+
+func (r *clientNameResolver) Resolve(h *Handler, req *Request) (*Response, error) {
+	if req.Client != nil {
+		if host, _, err := net.SplitHostPort(req.Client.String()); err == nil {
+			if reverseName, err := dns.ReverseAddr(host); err == nil {
+				req.ClientName = h.resolveClientName(reverseName)
+			}
+		}
+	}
+	return r.resolveNext(h, req)
+}
+
+// upstreamResolver is the final stage: it consults the response-filtering
+// stage, then forwards to req.Upstreams via the configured Strategy, and
+// caches a successful answer for the next identical question.
+type upstreamResolver struct{ baseResolver }
+
+func (r *upstreamResolver) Resolve(h *Handler, req *Request) (*Response, error) {
+	question := req.Question
+
+	if !h.config.DNS.EnableRecursion {
+		return &Response{Resolved: true}, nil
+	}
+
+	// Response filtering runs around forwarding: first against the
+	// question itself, then (below) against the answer section of
+	// whatever comes back, so a CNAME chain to a blocked domain is caught
+	// even when the queried name isn't blocked directly.
+	if filterAnswers, blocked := h.filterAnswer(question); blocked {
+		if len(filterAnswers) > 0 {
+			return &Response{Answer: filterAnswers, Resolved: true}, nil
+		}
+		return &Response{Rcode: dns.RcodeNameError, Resolved: true}, nil
+	}
+
+	upstreamAnswers := h.forwardUpstream(req.Client, req.Original, question, req.Upstreams)
+	if len(upstreamAnswers) == 0 {
+		return &Response{Resolved: true}, nil
+	}
+
+	if h.filter != nil && h.filter.MatchAnswers(answerTargets(upstreamAnswers)) {
+		return &Response{Rcode: dns.RcodeNameError, Resolved: true}, nil
+	}
+
+	if h.answerCache != nil {
+		h.answerCache.maybeStore(question, &Response{Rcode: dns.RcodeSuccess, Answer: upstreamAnswers})
+	}
+
+	return &Response{Answer: upstreamAnswers, Resolved: true}, nil
+}
@@ -2,38 +2,53 @@ package dns
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
+	"encoding/binary"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
+	"strconv"
 	"sync"
 	"time"
 
 	"github.com/miekg/dns"
+	"github.com/quic-go/quic-go"
+	"github.com/risadams/Pocket-Concierge/internal/bootstrap"
 	"github.com/risadams/Pocket-Concierge/internal/config"
 )
 
+// dnsOverQUICALPN is the ALPN token DoQ (RFC 9250) resolvers negotiate.
+const dnsOverQUICALPN = "doq"
+
 // SecureClient handles secure DNS protocols with optimized connection pooling
 type SecureClient struct {
-	httpClient   *http.Client
-	tlsConfig    *tls.Config
-	clients      map[string]*dns.Client
-	clientsMutex sync.RWMutex
+	httpClient     *http.Client
+	tlsConfig      *tls.Config
+	clients        map[string]*dns.Client
+	clientsMutex   sync.RWMutex
+	quicConns      map[string]*quic.Conn
+	quicConnsMutex sync.RWMutex
+	bootstrap      *bootstrap.Resolver
 }
 
 // NewSecureClient creates a new secure DNS client with optimized settings
 func NewSecureClient() *SecureClient {
+	return NewSecureClientWithBootstrap(bootstrap.NewResolver(config.BootstrapConfig{}))
+}
+
+// NewSecureClientWithBootstrap creates a secure DNS client that resolves
+// hostname-form upstream addresses (DoH/DoT endpoints given as names) via
+// br before dialing, rather than relying on the system resolver.
+func NewSecureClientWithBootstrap(br *bootstrap.Resolver) *SecureClient {
 	// Configure optimized HTTP transport
 	transport := &http.Transport{
 		MaxIdleConns:        100,
 		MaxIdleConnsPerHost: 10,
 		IdleConnTimeout:     30 * time.Second,
 		TLSHandshakeTimeout: 5 * time.Second,
-		DialContext: (&net.Dialer{
-			Timeout:   3 * time.Second,
-			KeepAlive: 30 * time.Second,
-		}).DialContext,
+		DialContext:         br.DialContext,
 		TLSClientConfig: &tls.Config{
 			MinVersion: tls.VersionTLS12,
 		},
@@ -47,7 +62,9 @@ func NewSecureClient() *SecureClient {
 		tlsConfig: &tls.Config{
 			MinVersion: tls.VersionTLS12,
 		},
-		clients: make(map[string]*dns.Client),
+		clients:   make(map[string]*dns.Client),
+		quicConns: make(map[string]*quic.Conn),
+		bootstrap: br,
 	}
 }
 
@@ -100,40 +117,187 @@ func (sc *SecureClient) getOrCreateClient(upstream config.UpstreamServer) *dns.C
 	return client
 }
 
-// Query sends a DNS query using the specified upstream server
-func (sc *SecureClient) Query(msg *dns.Msg, upstream config.UpstreamServer) (*dns.Msg, error) {
+// Query sends a DNS query using the specified upstream server. ctx is
+// threaded down into the protocol-specific dial/exchange/request calls, so a
+// caller racing several upstreams (see raceUpstreams) can abort this query
+// once another candidate has already answered.
+//
+// This switch is still the single place protocol dispatch happens; a full
+// pluggable Upstream-interface extraction (so a third party could register
+// a new protocol without touching this switch) was judged too large a
+// change to make alongside the config-side shorthand-string support added
+// in config.ParseUpstreamSpec, given how much of the cache-by-protocol
+// machinery above (getOrCreateClient, the QUIC connection cache) would need
+// to move with it. config.UpstreamServer remains the one shape every
+// upstream is described by, however it's written in config.yaml.
+func (sc *SecureClient) Query(ctx context.Context, msg *dns.Msg, upstream config.UpstreamServer) (*dns.Msg, error) {
 	switch upstream.Protocol {
 	case "udp", "tcp":
-		return sc.queryTraditional(msg, upstream)
+		return sc.queryTraditional(ctx, msg, upstream)
 	case "tls":
-		return sc.queryDoT(msg, upstream)
+		return sc.queryDoT(ctx, msg, upstream)
 	case "https":
-		return sc.queryDoH(msg, upstream)
+		return sc.queryDoH(ctx, msg, upstream)
 	case "quic":
-		return nil, fmt.Errorf("DNS-over-QUIC not yet implemented")
+		return sc.queryDoQ(ctx, msg, upstream)
 	default:
 		return nil, fmt.Errorf("unsupported protocol: %s", upstream.Protocol)
 	}
 }
 
 // queryTraditional handles UDP/TCP DNS queries with connection pooling
-func (sc *SecureClient) queryTraditional(msg *dns.Msg, upstream config.UpstreamServer) (*dns.Msg, error) {
+func (sc *SecureClient) queryTraditional(ctx context.Context, msg *dns.Msg, upstream config.UpstreamServer) (*dns.Msg, error) {
 	client := sc.getOrCreateClient(upstream)
-	addr := fmt.Sprintf("%s:%d", upstream.Address, upstream.Port)
-	response, _, err := client.Exchange(msg, addr)
+	addr, err := sc.dialAddr(ctx, upstream)
+	if err != nil {
+		return nil, err
+	}
+	response, _, err := client.ExchangeContext(ctx, msg, addr)
+	if err != nil {
+		sc.bootstrap.Invalidate(upstream.Address)
+	}
 	return response, err
 }
 
-// queryDoT handles DNS-over-TLS queries with connection pooling
-func (sc *SecureClient) queryDoT(msg *dns.Msg, upstream config.UpstreamServer) (*dns.Msg, error) {
+// queryDoT handles DNS-over-TLS queries with connection pooling. The TLS
+// ServerName stays the configured hostname (for certificate verification)
+// even when the dial address below has been resolved to an IP.
+func (sc *SecureClient) queryDoT(ctx context.Context, msg *dns.Msg, upstream config.UpstreamServer) (*dns.Msg, error) {
 	client := sc.getOrCreateClient(upstream)
-	addr := fmt.Sprintf("%s:%d", upstream.Address, upstream.Port)
-	response, _, err := client.Exchange(msg, addr)
+	addr, err := sc.dialAddr(ctx, upstream)
+	if err != nil {
+		return nil, err
+	}
+	response, _, err := client.ExchangeContext(ctx, msg, addr)
+	if err != nil {
+		// The cached IP may have gone stale (e.g. an anycast reassignment)
+		// before its TTL expired; re-resolve on the next attempt instead of
+		// waiting it out.
+		sc.bootstrap.Invalidate(upstream.Address)
+	}
 	return response, err
 }
 
+// dialAddr resolves upstream.Address (via the bootstrap resolver, if it is
+// a hostname) and returns the "ip:port" to dial. The returned error wraps
+// bootstrap.ErrResolution so callers can tell a failure to bootstrap-resolve
+// apart from a failure querying the upstream once reached.
+func (sc *SecureClient) dialAddr(ctx context.Context, upstream config.UpstreamServer) (string, error) {
+	ip, err := sc.bootstrap.Resolve(ctx, upstream.Address)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve upstream %s: %w", upstream.Address, err)
+	}
+	return net.JoinHostPort(ip, strconv.Itoa(upstream.Port)), nil
+}
+
+// getOrCreateQUICConn returns a cached QUIC connection for upstream,
+// re-dialing when none exists yet or the cached one has gone idle/closed
+// (MaxIdleTimeout below, or a server-initiated close). Callers that then
+// fail to open a stream on the returned connection should drop it via
+// dropQUICConn so the next query re-dials instead of retrying a dead one.
+func (sc *SecureClient) getOrCreateQUICConn(ctx context.Context, upstream config.UpstreamServer) (*quic.Conn, error) {
+	key := net.JoinHostPort(upstream.Address, strconv.Itoa(upstream.Port))
+
+	sc.quicConnsMutex.RLock()
+	if conn, exists := sc.quicConns[key]; exists && conn.Context().Err() == nil {
+		sc.quicConnsMutex.RUnlock()
+		return conn, nil
+	}
+	sc.quicConnsMutex.RUnlock()
+
+	sc.quicConnsMutex.Lock()
+	defer sc.quicConnsMutex.Unlock()
+
+	// Double-check after acquiring write lock
+	if conn, exists := sc.quicConns[key]; exists && conn.Context().Err() == nil {
+		return conn, nil
+	}
+
+	addr, err := sc.dialAddr(ctx, upstream)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := quic.DialAddr(ctx, addr, &tls.Config{
+		ServerName:         upstream.Address,
+		InsecureSkipVerify: !upstream.Verify,
+		MinVersion:         tls.VersionTLS13,
+		NextProtos:         []string{dnsOverQUICALPN},
+	}, &quic.Config{MaxIdleTimeout: 30 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial DoQ upstream: %w", err)
+	}
+
+	sc.quicConns[key] = conn
+	return conn, nil
+}
+
+// dropQUICConn evicts upstream's cached QUIC connection so the next query
+// dials a fresh one, e.g. after a stream open fails on a stale connection.
+func (sc *SecureClient) dropQUICConn(upstream config.UpstreamServer) {
+	key := net.JoinHostPort(upstream.Address, strconv.Itoa(upstream.Port))
+
+	sc.quicConnsMutex.Lock()
+	delete(sc.quicConns, key)
+	sc.quicConnsMutex.Unlock()
+}
+
+// queryDoQ handles DNS-over-QUIC (RFC 9250) queries: a fresh bidirectional
+// stream per query over a cached, reused connection. Per RFC 9250 section
+// 4.2.1, the message ID on the wire must be 0; the reply is re-stamped with
+// the caller's original ID before it's returned.
+func (sc *SecureClient) queryDoQ(ctx context.Context, msg *dns.Msg, upstream config.UpstreamServer) (*dns.Msg, error) {
+	conn, err := sc.getOrCreateQUICConn(ctx, upstream)
+	if err != nil {
+		sc.bootstrap.Invalidate(upstream.Address)
+		return nil, err
+	}
+
+	query := msg.Copy()
+	query.Id = 0
+	packed, err := query.Pack()
+	if err != nil {
+		return nil, fmt.Errorf("failed to pack DNS message: %w", err)
+	}
+
+	stream, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		sc.dropQUICConn(upstream)
+		sc.bootstrap.Invalidate(upstream.Address)
+		return nil, fmt.Errorf("failed to open DoQ stream: %w", err)
+	}
+	prefixed := make([]byte, 2+len(packed))
+	binary.BigEndian.PutUint16(prefixed, uint16(len(packed)))
+	copy(prefixed[2:], packed)
+
+	if _, err := stream.Write(prefixed); err != nil {
+		return nil, fmt.Errorf("failed to write DoQ query: %w", err)
+	}
+	if err := stream.Close(); err != nil {
+		return nil, fmt.Errorf("failed to close DoQ stream for writing: %w", err)
+	}
+
+	lengthBuf := make([]byte, 2)
+	if _, err := io.ReadFull(stream, lengthBuf); err != nil {
+		return nil, fmt.Errorf("failed to read DoQ response length: %w", err)
+	}
+
+	body := make([]byte, binary.BigEndian.Uint16(lengthBuf))
+	if _, err := io.ReadFull(stream, body); err != nil {
+		return nil, fmt.Errorf("failed to read DoQ response: %w", err)
+	}
+
+	response := &dns.Msg{}
+	if err := response.Unpack(body); err != nil {
+		return nil, fmt.Errorf("failed to unpack DoQ response: %w", err)
+	}
+	response.Id = msg.Id
+
+	return response, nil
+}
+
 // queryDoH handles DNS-over-HTTPS queries
-func (sc *SecureClient) queryDoH(msg *dns.Msg, upstream config.UpstreamServer) (*dns.Msg, error) {
+func (sc *SecureClient) queryDoH(ctx context.Context, msg *dns.Msg, upstream config.UpstreamServer) (*dns.Msg, error) {
 	// Pack DNS message to wire format
 	packed, err := msg.Pack()
 	if err != nil {
@@ -141,8 +305,8 @@ func (sc *SecureClient) queryDoH(msg *dns.Msg, upstream config.UpstreamServer) (
 	}
 
 	// Create HTTPS request
-	url := fmt.Sprintf("https://%s:%d%s", upstream.Address, upstream.Port, upstream.Path)
-	req, err := http.NewRequest("POST", url, bytes.NewReader(packed))
+	url := fmt.Sprintf("https://%s%s", net.JoinHostPort(upstream.Address, strconv.Itoa(upstream.Port)), upstream.Path)
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(packed))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create HTTP request: %w", err)
 	}
@@ -155,6 +319,7 @@ func (sc *SecureClient) queryDoH(msg *dns.Msg, upstream config.UpstreamServer) (
 	// Send request
 	resp, err := sc.httpClient.Do(req)
 	if err != nil {
+		sc.bootstrap.Invalidate(upstream.Address)
 		return nil, fmt.Errorf("HTTP request failed: %w", err)
 	}
 	defer resp.Body.Close()
@@ -1,10 +1,16 @@
 package dns
 
 import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/miekg/dns"
+	"github.com/risadams/Pocket-Concierge/internal/bootstrap"
 	"github.com/risadams/Pocket-Concierge/internal/config"
 )
 
@@ -92,6 +98,17 @@ func TestSecureClientQuery(t *testing.T) {
 			skipTest: true, // Skip DoH test as it requires network access
 			reason:   "DoH requires network access",
 		},
+		{
+			name: "DoQ upstream",
+			upstream: config.UpstreamServer{
+				Address:  "1.1.1.1",
+				Protocol: "quic",
+				Port:     853,
+				Verify:   false,
+			},
+			skipTest: true, // Skip DoQ test as it requires network access
+			reason:   "DoQ requires network access and working QUIC",
+		},
 	}
 
 	for _, tt := range tests {
@@ -105,7 +122,7 @@ func TestSecureClientQuery(t *testing.T) {
 				t.Skip("Cannot reach external DNS servers")
 			}
 
-			response, err := client.Query(query, tt.upstream)
+			response, err := client.Query(context.Background(), query, tt.upstream)
 
 			// We expect either a successful response or a timeout/network error
 			// Don't fail the test for network issues in CI environments
@@ -131,6 +148,78 @@ func TestSecureClientQuery(t *testing.T) {
 	}
 }
 
+func TestSecureClientQueryDoQ(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping DoQ test in short mode")
+	}
+	if !canReachExternalDNS() {
+		t.Skip("Cannot reach external DNS servers")
+	}
+
+	client := NewSecureClient()
+	upstream := config.UpstreamServer{
+		Address:  "1.1.1.1",
+		Protocol: "quic",
+		Port:     853,
+		Verify:   true,
+	}
+
+	query := &dns.Msg{}
+	query.SetQuestion("google.com.", dns.TypeA)
+
+	response, err := client.Query(context.Background(), query, upstream)
+	if err != nil {
+		t.Logf("DoQ query failed (expected in some environments): %v", err)
+		return
+	}
+
+	if response.Id != query.Id {
+		t.Errorf("Response ID mismatch: expected %d, got %d", query.Id, response.Id)
+	}
+}
+
+func TestSecureClientQueryDoQReusesConnection(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping DoQ test in short mode")
+	}
+	if !canReachExternalDNS() {
+		t.Skip("Cannot reach external DNS servers")
+	}
+
+	client := NewSecureClient()
+	upstream := config.UpstreamServer{
+		Address:  "1.1.1.1",
+		Protocol: "quic",
+		Port:     853,
+		Verify:   true,
+	}
+
+	query := &dns.Msg{}
+	query.SetQuestion("google.com.", dns.TypeA)
+
+	if _, err := client.Query(context.Background(), query, upstream); err != nil {
+		t.Skipf("DoQ query failed (expected in some environments): %v", err)
+	}
+
+	client.quicConnsMutex.RLock()
+	conn, exists := client.quicConns[fmt.Sprintf("%s:%d", upstream.Address, upstream.Port)]
+	client.quicConnsMutex.RUnlock()
+	if !exists {
+		t.Fatal("expected the QUIC connection to be cached after a successful query")
+	}
+
+	if _, err := client.Query(context.Background(), query, upstream); err != nil {
+		t.Fatalf("second DoQ query failed: %v", err)
+	}
+
+	client.quicConnsMutex.RLock()
+	reused := client.quicConns[fmt.Sprintf("%s:%d", upstream.Address, upstream.Port)]
+	client.quicConnsMutex.RUnlock()
+	if reused != conn {
+		t.Error("expected the second query to reuse the cached QUIC connection")
+	}
+}
+
 func TestSecureClientInvalidUpstream(t *testing.T) {
 	client := NewSecureClient()
 
@@ -161,7 +250,7 @@ func TestSecureClientInvalidUpstream(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			response, err := client.Query(query, tt.upstream)
+			response, err := client.Query(context.Background(), query, tt.upstream)
 
 			// Should either return an error or nil response
 			if err == nil && response != nil {
@@ -201,7 +290,7 @@ func TestSecureClientConcurrency(t *testing.T) {
 				query := &dns.Msg{}
 				query.SetQuestion("google.com.", dns.TypeA)
 
-				_, err := client.Query(query, upstream)
+				_, err := client.Query(context.Background(), query, upstream)
 				if err != nil {
 					resultChan <- err
 					return
@@ -327,6 +416,125 @@ func TestSecureClientTLSConfig(t *testing.T) {
 	}
 }
 
+func TestSecureClientQueryIPUpstreamBootstrapUnused(t *testing.T) {
+	fake := newFakeUpstream(t, 0, false)
+
+	client := NewSecureClient()
+	query := &dns.Msg{}
+	query.SetQuestion("example.com.", dns.TypeA)
+
+	response, err := client.Query(context.Background(), query, fake.upstream())
+	if err != nil {
+		t.Fatalf("Query failed for IP upstream: %v", err)
+	}
+	if len(response.Answer) != 1 {
+		t.Fatalf("expected 1 answer, got %d", len(response.Answer))
+	}
+}
+
+func TestSecureClientQueryHostnameUpstreamUsesBootstrap(t *testing.T) {
+	fake := newFakeUpstream(t, 0, false)
+	fakeUpstreamServer := fake.upstream()
+
+	client := NewSecureClientWithBootstrap(bootstrap.NewResolver(config.BootstrapConfig{
+		StaticHosts: map[string]string{"fake.internal": "127.0.0.1"},
+	}))
+
+	hostnameUpstream := config.UpstreamServer{
+		Address:  "fake.internal",
+		Protocol: "udp",
+		Port:     fakeUpstreamServer.Port,
+	}
+
+	query := &dns.Msg{}
+	query.SetQuestion("example.com.", dns.TypeA)
+
+	response, err := client.Query(context.Background(), query, hostnameUpstream)
+	if err != nil {
+		t.Fatalf("Query failed for hostname upstream: %v", err)
+	}
+	if len(response.Answer) != 1 {
+		t.Fatalf("expected 1 answer, got %d", len(response.Answer))
+	}
+}
+
+func TestSecureClientQueryFailureInvalidatesBootstrapCache(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer conn.Close()
+
+	var mu sync.Mutex
+	resolveCount := 0
+	server := &dns.Server{PacketConn: conn, Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		mu.Lock()
+		resolveCount++
+		mu.Unlock()
+
+		msg := &dns.Msg{}
+		msg.SetReply(r)
+		rr, _ := dns.NewRR(r.Question[0].Name + " 300 IN A 127.0.0.1")
+		msg.Answer = append(msg.Answer, rr)
+		w.WriteMsg(msg)
+	})}
+	go server.ActivateAndServe()
+	t.Cleanup(func() { server.Shutdown() })
+
+	_, bootstrapPortStr, _ := net.SplitHostPort(conn.LocalAddr().String())
+	bootstrapPort, _ := strconv.Atoi(bootstrapPortStr)
+
+	client := NewSecureClientWithBootstrap(bootstrap.NewResolver(config.BootstrapConfig{
+		Resolvers: []string{fmt.Sprintf("127.0.0.1:%d", bootstrapPort)},
+		CacheTTL:  3600,
+	}))
+
+	upstream := config.UpstreamServer{
+		Address:  "stale.internal",
+		Protocol: "tcp",
+		Port:     findClosedTCPPort(t), // refuses the connection immediately
+	}
+
+	query := &dns.Msg{}
+	query.SetQuestion("example.com.", dns.TypeA)
+
+	if _, err := client.Query(context.Background(), query, upstream); err == nil {
+		t.Fatal("expected query against a closed port to fail")
+	}
+	mu.Lock()
+	firstCount := resolveCount
+	mu.Unlock()
+	// LookupHost resolves both families per call, so a single bootstrap
+	// resolution reaches the server twice (A and AAAA).
+	if firstCount != 2 {
+		t.Fatalf("expected exactly 2 bootstrap queries (A+AAAA) before the failed query, got %d", firstCount)
+	}
+
+	if _, err := client.Query(context.Background(), query, upstream); err == nil {
+		t.Fatal("expected second query against a closed port to fail")
+	}
+	mu.Lock()
+	secondCount := resolveCount
+	mu.Unlock()
+	if secondCount != 4 {
+		t.Errorf("expected the failed connection to invalidate the bootstrap cache, forcing re-resolution; got %d resolutions", secondCount)
+	}
+}
+
+func findClosedTCPPort(t *testing.T) int {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to find a free port: %v", err)
+	}
+	_, portStr, _ := net.SplitHostPort(l.Addr().String())
+	port, _ := strconv.Atoi(portStr)
+	l.Close()
+
+	return port
+}
+
 func BenchmarkSecureClientQuery(b *testing.B) {
 	if !canReachExternalDNS() {
 		b.Skip("Cannot reach external DNS servers")
@@ -346,7 +554,7 @@ func BenchmarkSecureClientQuery(b *testing.B) {
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		_, err := client.Query(query, upstream)
+		_, err := client.Query(context.Background(), query, upstream)
 		if err != nil {
 			b.Logf("Query failed: %v", err)
 		}
@@ -372,7 +580,7 @@ func BenchmarkSecureClientConcurrent(b *testing.B) {
 			query := &dns.Msg{}
 			query.SetQuestion("google.com.", dns.TypeA)
 
-			_, err := client.Query(query, upstream)
+			_, err := client.Query(context.Background(), query, upstream)
 			if err != nil {
 				// Don't fail benchmark for network errors
 			}
@@ -0,0 +1,125 @@
+package dns
+
+import (
+	"math/rand"
+	"sync"
+	"sync/atomic"
+
+	"github.com/miekg/dns"
+)
+
+// roundRobinCounters tracks a per-qname rotation counter for the
+// "round-robin" shuffle mode, so repeated queries for the same name cycle
+// through the available records instead of always returning the same order.
+type roundRobinCounters struct {
+	mu       sync.Mutex
+	counters map[string]*uint64
+}
+
+func newRoundRobinCounters() *roundRobinCounters {
+	return &roundRobinCounters{counters: make(map[string]*uint64)}
+}
+
+func (c *roundRobinCounters) next(qname string) uint64 {
+	c.mu.Lock()
+	counter, ok := c.counters[qname]
+	if !ok {
+		counter = new(uint64)
+		c.counters[qname] = counter
+	}
+	c.mu.Unlock()
+
+	return atomic.AddUint64(counter, 1)
+}
+
+// shuffleAnswers reorders answers per cfg.DNS.Shuffle ("random" or
+// "round-robin"; anything else, including "off", is a no-op). Reordering
+// happens within each same-type RR group independently, so an A group and a
+// CNAME group each keep their own relative order of groups. A qname with
+// more than one CNAME is never reordered: glibc stub resolvers require
+// CNAME chains to stay in the order the server sent them.
+func shuffleAnswers(mode, qname string, answers []dns.RR, rr *roundRobinCounters) []dns.RR {
+	if len(answers) < 2 || (mode != "random" && mode != "round-robin") {
+		return answers
+	}
+	if countCNAMEs(answers) > 1 {
+		return answers
+	}
+
+	result := make([]dns.RR, 0, len(answers))
+	for _, group := range groupRRsByType(answers) {
+		records := group.records
+		switch mode {
+		case "random":
+			records = shuffleRandom(records)
+		case "round-robin":
+			records = rotate(records, int(rr.next(qname)))
+		}
+		result = append(result, records...)
+	}
+	return result
+}
+
+func countCNAMEs(answers []dns.RR) int {
+	count := 0
+	for _, answer := range answers {
+		if answer.Header().Rrtype == dns.TypeCNAME {
+			count++
+		}
+	}
+	return count
+}
+
+// rrGroup is one contiguous-by-type run of answers, in first-seen order.
+type rrGroup struct {
+	rrtype  uint16
+	records []dns.RR
+}
+
+func groupRRsByType(answers []dns.RR) []rrGroup {
+	var groups []rrGroup
+	index := make(map[uint16]int, len(answers))
+
+	for _, answer := range answers {
+		rrtype := answer.Header().Rrtype
+		if i, ok := index[rrtype]; ok {
+			groups[i].records = append(groups[i].records, answer)
+			continue
+		}
+		index[rrtype] = len(groups)
+		groups = append(groups, rrGroup{rrtype: rrtype, records: []dns.RR{answer}})
+	}
+
+	return groups
+}
+
+// shuffleRandom returns a copy of group in dns.Id()-seeded random order.
+func shuffleRandom(group []dns.RR) []dns.RR {
+	if len(group) < 2 {
+		return group
+	}
+
+	shuffled := make([]dns.RR, len(group))
+	copy(shuffled, group)
+
+	rng := rand.New(rand.NewSource(int64(dns.Id())))
+	rng.Shuffle(len(shuffled), func(i, j int) {
+		shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+	})
+
+	return shuffled
+}
+
+// rotate returns a copy of group rotated left by n positions.
+func rotate(group []dns.RR, n int) []dns.RR {
+	if len(group) < 2 {
+		return group
+	}
+
+	offset := n % len(group)
+	rotated := make([]dns.RR, len(group))
+	copy(rotated, group[offset:])
+	copy(rotated[len(group)-offset:], group[:offset])
+
+	return rotated
+}
@@ -0,0 +1,64 @@
+package dns
+
+import (
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func aRecord(ip string) dns.RR {
+	return &dns.A{
+		Hdr: dns.RR_Header{Name: "test.home.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 300},
+		A:   net.ParseIP(ip).To4(),
+	}
+}
+
+func TestShuffleAnswersOffIsNoop(t *testing.T) {
+	answers := []dns.RR{aRecord("192.168.1.1"), aRecord("192.168.1.2")}
+	result := shuffleAnswers("", "test.home.", answers, newRoundRobinCounters())
+
+	for i, rr := range result {
+		if rr != answers[i] {
+			t.Errorf("expected no reordering with shuffle off, got a different order at index %d", i)
+		}
+	}
+}
+
+func TestShuffleAnswersRoundRobinRotates(t *testing.T) {
+	answers := []dns.RR{aRecord("192.168.1.1"), aRecord("192.168.1.2"), aRecord("192.168.1.3")}
+	counters := newRoundRobinCounters()
+
+	first := shuffleAnswers("round-robin", "test.home.", answers, counters)
+	second := shuffleAnswers("round-robin", "test.home.", answers, counters)
+
+	if first[0] == second[0] {
+		t.Error("expected round-robin to rotate the leading record across successive calls")
+	}
+}
+
+func TestShuffleAnswersSkipsMultiCNAME(t *testing.T) {
+	cname1 := &dns.CNAME{Hdr: dns.RR_Header{Name: "a.home.", Rrtype: dns.TypeCNAME}, Target: "b.home."}
+	cname2 := &dns.CNAME{Hdr: dns.RR_Header{Name: "b.home.", Rrtype: dns.TypeCNAME}, Target: "c.home."}
+	answers := []dns.RR{cname1, cname2}
+
+	result := shuffleAnswers("round-robin", "a.home.", answers, newRoundRobinCounters())
+
+	if result[0] != cname1 || result[1] != cname2 {
+		t.Error("expected a CNAME chain with more than one CNAME to be left in order")
+	}
+}
+
+func TestShuffleAnswersPreservesGroupOrder(t *testing.T) {
+	cname := &dns.CNAME{Hdr: dns.RR_Header{Name: "a.home.", Rrtype: dns.TypeCNAME}, Target: "b.home."}
+	answers := []dns.RR{cname, aRecord("192.168.1.1"), aRecord("192.168.1.2")}
+
+	result := shuffleAnswers("round-robin", "a.home.", answers, newRoundRobinCounters())
+
+	if result[0] != cname {
+		t.Error("expected the CNAME to stay first regardless of A-group rotation")
+	}
+	if len(result) != 3 {
+		t.Fatalf("expected 3 answers, got %d", len(result))
+	}
+}
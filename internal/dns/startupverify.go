@@ -0,0 +1,82 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/risadams/Pocket-Concierge/internal/config"
+)
+
+// canaryName is the query name VerifyUpstreams sends an SOA query for: the
+// root zone, cheap for any recursive resolver to answer and independent of
+// any zone Pocket-Concierge itself serves.
+const canaryName = "."
+
+// VerifyUpstreams sends a canary query to every configured upstream,
+// grouped the same way dispatch groups them (the default h.config.Upstream
+// set, plus one group per h.config.Conditional suffix), and reports an
+// error only if an entire group has no reachable upstream — mirroring the
+// dispatch-time rule that a single healthy upstream in a group is enough
+// to serve it. Every outcome is also recorded against the shared
+// HealthTracker via RecordProbe, the same path the background
+// HealthChecker probes use.
+func (h *Handler) VerifyUpstreams() error {
+	var unreachable []string
+	for name, upstreams := range upstreamGroups(h.config) {
+		if !h.verifyGroup(upstreams) {
+			unreachable = append(unreachable, name)
+		}
+	}
+
+	if len(unreachable) > 0 {
+		sort.Strings(unreachable)
+		return fmt.Errorf("no reachable upstream in group(s): %s", strings.Join(unreachable, ", "))
+	}
+	return nil
+}
+
+// upstreamGroups returns the distinct sets of upstreams dispatch chooses
+// from independently: the default group (h.config.Upstream) and one group
+// per conditional suffix (h.config.Conditional).
+func upstreamGroups(cfg *config.Config) map[string][]config.UpstreamServer {
+	groups := make(map[string][]config.UpstreamServer, len(cfg.Conditional)+1)
+	if len(cfg.Upstream) > 0 {
+		groups["default"] = cfg.Upstream
+	}
+	for suffix, upstreams := range cfg.Conditional {
+		groups[suffix] = upstreams
+	}
+	return groups
+}
+
+// verifyGroup probes every upstream in upstreams concurrently with a
+// canary query and reports whether at least one answered successfully.
+func (h *Handler) verifyGroup(upstreams []config.UpstreamServer) bool {
+	results := make(chan bool, len(upstreams))
+
+	for _, u := range upstreams {
+		u := u
+		go func() {
+			query := &dns.Msg{}
+			query.SetQuestion(canaryName, dns.TypeSOA)
+			query.RecursionDesired = true
+
+			start := time.Now()
+			_, err := h.secureClient.Query(context.Background(), query, u)
+			h.resolver.Health().RecordProbe(u, err, time.Since(start), h.config.UpstreamHealthcheck)
+			results <- err == nil
+		}()
+	}
+
+	healthy := false
+	for range upstreams {
+		if <-results {
+			healthy = true
+		}
+	}
+	return healthy
+}
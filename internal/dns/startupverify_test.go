@@ -0,0 +1,44 @@
+package dns
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/risadams/Pocket-Concierge/internal/config"
+)
+
+func TestVerifyUpstreamsSucceedsWhenOneUpstreamPerGroupAnswers(t *testing.T) {
+	healthy := newFakeUpstream(t, 0, false)
+	broken := config.UpstreamServer{Protocol: "invalid"}
+
+	cfg := &config.Config{
+		HomeDNSDomain: "home",
+		DNS:           config.DNSConfig{TTL: 300},
+		Upstream:      []config.UpstreamServer{broken, healthy.upstream()},
+		Conditional:   map[string][]config.UpstreamServer{"example.com": {healthy.upstream()}},
+	}
+
+	handler := NewHandler(cfg)
+	if err := handler.VerifyUpstreams(); err != nil {
+		t.Errorf("expected verification to pass with one reachable upstream per group, got: %v", err)
+	}
+}
+
+func TestVerifyUpstreamsFailsWhenAGroupHasNoReachableUpstream(t *testing.T) {
+	broken := config.UpstreamServer{Protocol: "invalid"}
+
+	cfg := &config.Config{
+		HomeDNSDomain: "home",
+		DNS:           config.DNSConfig{TTL: 300},
+		Upstream:      []config.UpstreamServer{broken},
+	}
+
+	handler := NewHandler(cfg)
+	err := handler.VerifyUpstreams()
+	if err == nil {
+		t.Fatal("expected verification to fail when the only upstream in a group is unreachable")
+	}
+	if !strings.Contains(err.Error(), "default") {
+		t.Errorf("expected the failing group name in the error, got: %v", err)
+	}
+}
@@ -0,0 +1,140 @@
+package dns
+
+import (
+	"context"
+	"net"
+
+	"github.com/miekg/dns"
+	"github.com/risadams/Pocket-Concierge/internal/config"
+)
+
+// Strategy picks among a set of upstreams and queries them on behalf of
+// Handler.forwardUpstream, selected by DNSConfig.UpstreamStrategy. Having
+// sequential/parallelBest/fastest share this interface mirrors how the
+// server package handles its listener types uniformly. ctx is forwarded
+// into every queryUpstream call so a racing strategy can cancel the
+// upstreams it doesn't end up using.
+type Strategy interface {
+	Forward(ctx context.Context, h *Handler, client net.Addr, original *dns.Msg, question dns.Question, query *dns.Msg, upstreams []config.UpstreamServer) []dns.RR
+}
+
+// strategyFor resolves a DNSConfig.UpstreamStrategy value to a Strategy,
+// defaulting to sequentialStrategy for "", "sequential", "strict", or any
+// unrecognized value.
+func strategyFor(name string) Strategy {
+	switch name {
+	case "parallel_best":
+		return parallelBestStrategy{}
+	case "fastest":
+		return fastestStrategy{}
+	case "failover":
+		return failoverStrategy{}
+	default:
+		return sequentialStrategy{}
+	}
+}
+
+// sequentialStrategy tries each upstream in order (the "sequential"/
+// "strict" and default strategy), returning the first non-error answer.
+type sequentialStrategy struct{}
+
+func (sequentialStrategy) Forward(ctx context.Context, h *Handler, client net.Addr, original *dns.Msg, question dns.Question, query *dns.Msg, upstreams []config.UpstreamServer) []dns.RR {
+	for _, upstream := range upstreams {
+		response, err := h.queryUpstream(ctx, client, original, question, query, upstream)
+		if err != nil {
+			continue
+		}
+
+		if len(response.Answer) > 0 {
+			return response.Answer
+		}
+	}
+
+	return nil
+}
+
+// parallelBestStrategy dispatches to two health-weighted upstreams
+// concurrently and returns the first non-error, non-SERVFAIL answer,
+// discarding whichever response arrives second. Mirrors Blocky's
+// ParallelBestResolver.
+type parallelBestStrategy struct{}
+
+func (parallelBestStrategy) Forward(ctx context.Context, h *Handler, client net.Addr, original *dns.Msg, question dns.Question, query *dns.Msg, upstreams []config.UpstreamServer) []dns.RR {
+	candidates := h.resolver.Health().WeightedPick(upstreams, 2)
+	return raceUpstreams(ctx, h, client, original, question, query, candidates)
+}
+
+// fastestStrategy fans out to every configured upstream concurrently and
+// returns the first non-error, non-SERVFAIL answer, discarding the rest.
+// Unlike parallelBestStrategy it races all upstreams rather than a
+// health-weighted pair of two, trading load for latency.
+type fastestStrategy struct{}
+
+func (fastestStrategy) Forward(ctx context.Context, h *Handler, client net.Addr, original *dns.Msg, question dns.Question, query *dns.Msg, upstreams []config.UpstreamServer) []dns.RR {
+	return raceUpstreams(ctx, h, client, original, question, query, upstreams)
+}
+
+// failoverStrategy tries upstreams in order like sequentialStrategy, but
+// first skips any currently quarantined by the active background
+// HealthChecker (HealthTracker.Quarantined), falling back to the full,
+// unfiltered list only if every upstream is quarantined, so a total outage
+// never leaves a question with nowhere to go.
+type failoverStrategy struct{}
+
+func (failoverStrategy) Forward(ctx context.Context, h *Handler, client net.Addr, original *dns.Msg, question dns.Question, query *dns.Msg, upstreams []config.UpstreamServer) []dns.RR {
+	health := h.resolver.Health()
+
+	healthy := make([]config.UpstreamServer, 0, len(upstreams))
+	for _, upstream := range upstreams {
+		if !health.Quarantined(upstream) {
+			healthy = append(healthy, upstream)
+		}
+	}
+
+	ordered := upstreams
+	if len(healthy) > 0 {
+		ordered = healthy
+	}
+
+	return sequentialStrategy{}.Forward(ctx, h, client, original, question, query, ordered)
+}
+
+// raceUpstreams queries every upstream in candidates concurrently and
+// returns the first non-error, non-SERVFAIL answer. It derives a cancellable
+// context from ctx shared by every candidate goroutine, and cancels it as
+// soon as a winner is chosen (or no candidate answers), so a losing query
+// is aborted instead of being left to run to completion in the background.
+func raceUpstreams(ctx context.Context, h *Handler, client net.Addr, original *dns.Msg, question dns.Question, query *dns.Msg, candidates []config.UpstreamServer) []dns.RR {
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	raceCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		answer []dns.RR
+		ok     bool
+	}
+
+	results := make(chan result, len(candidates))
+	for _, upstream := range candidates {
+		upstream := upstream
+		go func() {
+			response, err := h.queryUpstream(raceCtx, client, original, question, query, upstream)
+			if err != nil || response.Rcode == dns.RcodeServerFailure {
+				results <- result{}
+				return
+			}
+			results <- result{answer: response.Answer, ok: true}
+		}()
+	}
+
+	for range candidates {
+		if r := <-results; r.ok {
+			return r.answer
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,270 @@
+// Package filter implements the response-filtering resolver stage: rule
+// lists loaded from files or URLs, matched against both the query name and
+// the answer section of upstream responses (so a CNAME chain to a blocked
+// domain is caught, not just a directly-queried one).
+package filter
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/risadams/Pocket-Concierge/internal/config"
+)
+
+// Action describes how a matched query should be answered.
+type Action int
+
+const (
+	// ActionNXDOMAIN answers matched queries with NXDOMAIN.
+	ActionNXDOMAIN Action = iota
+	// ActionSinkhole answers A/AAAA queries with a configured sinkhole IP.
+	ActionSinkhole
+)
+
+// kind identifies how a rule is matched against a name.
+type kind int
+
+const (
+	kindExact kind = iota
+	kindWildcard
+	kindRegex
+)
+
+// rule is one compiled filter entry, in exact, AdBlock-style wildcard
+// (`||example.com^`), or regex (`/pattern/`) form.
+type rule struct {
+	kind  kind
+	value string // domain for kindExact/kindWildcard, source pattern for kindRegex (for stats labeling)
+	re    *regexp.Regexp
+}
+
+// Filter is the response-filtering resolver stage. It is reload-safe: Reload
+// compiles a fresh rule set and swaps it in under mu, mirroring how
+// dns.HostCache.Rebuild guards concurrent lookups during a hot reload.
+type Filter struct {
+	cfg    config.FilterConfig
+	client *http.Client
+
+	mu    sync.RWMutex
+	rules []rule
+
+	queries       int64
+	matched       int64
+	matchedByRule sync.Map // rule label -> *int64
+}
+
+// New creates a Filter and performs an initial load of every enabled list.
+func New(cfg config.FilterConfig) (*Filter, error) {
+	f := &Filter{
+		cfg:    cfg,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+
+	if err := f.Reload(); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// Action returns the configured response type for a match.
+func (f *Filter) Action() Action {
+	if f.cfg.Action == "sinkhole" {
+		return ActionSinkhole
+	}
+	return ActionNXDOMAIN
+}
+
+// SinkholeIPv4 returns the configured sinkhole IPv4 address, if any.
+func (f *Filter) SinkholeIPv4() string { return f.cfg.SinkholeIPv4 }
+
+// SinkholeIPv6 returns the configured sinkhole IPv6 address, if any.
+func (f *Filter) SinkholeIPv6() string { return f.cfg.SinkholeIPv6 }
+
+// Reload fetches and parses every enabled list and atomically swaps in the
+// freshly compiled rule set.
+func (f *Filter) Reload() error {
+	var rules []rule
+
+	for _, list := range f.cfg.Lists {
+		if !list.Enabled {
+			continue
+		}
+
+		data, err := f.fetch(list.URL)
+		if err != nil {
+			return fmt.Errorf("filter list %q: %w", list.Name, err)
+		}
+
+		parsed, err := parseRules(data)
+		if err != nil {
+			return fmt.Errorf("filter list %q: %w", list.Name, err)
+		}
+		rules = append(rules, parsed...)
+	}
+
+	f.mu.Lock()
+	f.rules = rules
+	f.mu.Unlock()
+
+	return nil
+}
+
+// fetch reads a source, which may be an http(s) URL or a local file path.
+func (f *Filter) fetch(source string) ([]byte, error) {
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		resp, err := f.client.Get(source)
+		if err != nil {
+			return nil, fmt.Errorf("fetch %s: %w", source, err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("fetch %s: HTTP %d", source, resp.StatusCode)
+		}
+
+		return io.ReadAll(resp.Body)
+	}
+
+	return os.ReadFile(source)
+}
+
+// parseRules parses raw rule list data, one rule per line: "||domain^" for a
+// domain plus its subdomains, "/pattern/" for a regex, "!" or "#" for
+// comments, and anything else as an exact-match domain.
+func parseRules(data []byte) ([]rule, error) {
+	var rules []rule
+
+	scanner := bufio.NewScanner(strings.NewReader(string(data)))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "!") || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(line, "||") && strings.HasSuffix(line, "^"):
+			domain := strings.ToLower(strings.TrimSuffix(strings.TrimPrefix(line, "||"), "^"))
+			rules = append(rules, rule{kind: kindWildcard, value: domain})
+
+		case strings.HasPrefix(line, "/") && strings.HasSuffix(line, "/") && len(line) > 1:
+			pattern := line[1 : len(line)-1]
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("invalid regex rule %q: %w", line, err)
+			}
+			rules = append(rules, rule{kind: kindRegex, value: line, re: re})
+
+		default:
+			rules = append(rules, rule{kind: kindExact, value: strings.ToLower(strings.TrimSuffix(line, "."))})
+		}
+	}
+
+	return rules, nil
+}
+
+// match reports whether name matches any compiled rule, and the rule's
+// label for stats purposes.
+func (f *Filter) match(name string) (bool, string) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	for _, r := range f.rules {
+		switch r.kind {
+		case kindExact:
+			if name == r.value {
+				return true, r.value
+			}
+		case kindWildcard:
+			if name == r.value || strings.HasSuffix(name, "."+r.value) {
+				return true, r.value
+			}
+		case kindRegex:
+			if r.re.MatchString(name) {
+				return true, r.value
+			}
+		}
+	}
+
+	return false, ""
+}
+
+// CheckQuestion reports whether the query name matches a filter rule,
+// recording stats either way.
+func (f *Filter) CheckQuestion(name string) bool {
+	atomic.AddInt64(&f.queries, 1)
+
+	normalized := strings.ToLower(strings.TrimSuffix(name, "."))
+	if normalized == "" {
+		return false
+	}
+
+	matched, label := f.match(normalized)
+	if matched {
+		f.recordMatch(label)
+	}
+	return matched
+}
+
+// rrTarget normalizes a name taken from an answer record (an A/AAAA owner
+// name or a CNAME/HTTPS alias target) for matching: lowercased, with the
+// trailing dot removed.
+func rrTarget(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
+
+// MatchAnswers reports whether any name carried by answers (A/AAAA owner
+// names, CNAME and HTTPS targets) matches a filter rule. This is what lets a
+// CNAME chain to a blocked domain be caught even when the originally
+// queried name doesn't match anything itself.
+func (f *Filter) MatchAnswers(names []string) bool {
+	for _, name := range names {
+		normalized := rrTarget(name)
+		if normalized == "" {
+			continue
+		}
+		if matched, label := f.match(normalized); matched {
+			f.recordMatch(label)
+			return true
+		}
+	}
+	return false
+}
+
+func (f *Filter) recordMatch(label string) {
+	atomic.AddInt64(&f.matched, 1)
+
+	counter, _ := f.matchedByRule.LoadOrStore(label, new(int64))
+	atomic.AddInt64(counter.(*int64), 1)
+}
+
+// Stats summarizes the filter's activity for server.GetStats().
+type Stats struct {
+	Queries       int64            `json:"queries"`
+	Matched       int64            `json:"matched"`
+	MatchedByRule map[string]int64 `json:"matched_by_rule,omitempty"`
+}
+
+// Stats returns a snapshot of the filter's query/match counters.
+func (f *Filter) Stats() Stats {
+	stats := Stats{
+		Queries:       atomic.LoadInt64(&f.queries),
+		Matched:       atomic.LoadInt64(&f.matched),
+		MatchedByRule: make(map[string]int64),
+	}
+
+	f.matchedByRule.Range(func(key, value interface{}) bool {
+		stats.MatchedByRule[key.(string)] = atomic.LoadInt64(value.(*int64))
+		return true
+	})
+
+	return stats
+}
@@ -0,0 +1,142 @@
+package filter
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/risadams/Pocket-Concierge/internal/config"
+)
+
+func writeRules(t *testing.T, content string) string {
+	t.Helper()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "rules.txt")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write rules file: %v", err)
+	}
+	return path
+}
+
+func newFilter(t *testing.T, content string) *Filter {
+	t.Helper()
+	path := writeRules(t, content)
+	f, err := New(config.FilterConfig{
+		Lists: []config.FilterListSource{{Name: "test", URL: path, Enabled: true}},
+	})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	return f
+}
+
+func TestFilterExactMatch(t *testing.T) {
+	f := newFilter(t, "ads.example.com\n")
+
+	if !f.CheckQuestion("ads.example.com") {
+		t.Error("expected exact match to be blocked")
+	}
+	if f.CheckQuestion("sub.ads.example.com") {
+		t.Error("exact rule should not match subdomains")
+	}
+}
+
+func TestFilterWildcardMatch(t *testing.T) {
+	f := newFilter(t, "||tracker.example.com^\n")
+
+	if !f.CheckQuestion("tracker.example.com") {
+		t.Error("expected wildcard rule to match its own domain")
+	}
+	if !f.CheckQuestion("beacon.tracker.example.com") {
+		t.Error("expected wildcard rule to match a subdomain")
+	}
+	if f.CheckQuestion("example.com") {
+		t.Error("wildcard rule should not match the parent domain")
+	}
+}
+
+func TestFilterRegexMatch(t *testing.T) {
+	f := newFilter(t, `/^ads?-[0-9]+\.example\.com$/`+"\n")
+
+	if !f.CheckQuestion("ad-1.example.com") {
+		t.Error("expected regex rule to match")
+	}
+	if f.CheckQuestion("ads-abc.example.com") {
+		t.Error("regex rule should not match non-numeric suffix")
+	}
+}
+
+func TestFilterIgnoresCommentsAndBlankLines(t *testing.T) {
+	f := newFilter(t, "! this is a comment\n# so is this\n\nblocked.example.com\n")
+
+	if !f.CheckQuestion("blocked.example.com") {
+		t.Error("expected non-comment rule to still load")
+	}
+}
+
+func TestFilterMatchAnswersCatchesCNAMEChain(t *testing.T) {
+	f := newFilter(t, "||tracker.example.com^\n")
+
+	if !f.MatchAnswers([]string{"cdn.example.net.", "cname.tracker.example.com."}) {
+		t.Error("expected MatchAnswers to catch a CNAME target matching a filter rule")
+	}
+	if f.MatchAnswers([]string{"cdn.example.net."}) {
+		t.Error("expected no match for unrelated answer names")
+	}
+}
+
+func TestFilterDisabledListIsNotLoaded(t *testing.T) {
+	path := writeRules(t, "blocked.example.com\n")
+	f, err := New(config.FilterConfig{
+		Lists: []config.FilterListSource{{Name: "test", URL: path, Enabled: false}},
+	})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	if f.CheckQuestion("blocked.example.com") {
+		t.Error("expected a disabled list to not be loaded")
+	}
+}
+
+func TestFilterStatsTracksQueriesAndMatches(t *testing.T) {
+	f := newFilter(t, "blocked.example.com\n")
+
+	f.CheckQuestion("blocked.example.com")
+	f.CheckQuestion("safe.example.com")
+
+	stats := f.Stats()
+	if stats.Queries != 2 {
+		t.Errorf("expected 2 queries, got %d", stats.Queries)
+	}
+	if stats.Matched != 1 {
+		t.Errorf("expected 1 match, got %d", stats.Matched)
+	}
+	if stats.MatchedByRule["blocked.example.com"] != 1 {
+		t.Errorf("expected matched_by_rule to credit the matching rule, got %+v", stats.MatchedByRule)
+	}
+}
+
+func TestFilterReloadPicksUpChanges(t *testing.T) {
+	path := writeRules(t, "old.example.com\n")
+	f, err := New(config.FilterConfig{
+		Lists: []config.FilterListSource{{Name: "test", URL: path, Enabled: true}},
+	})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	if err := os.WriteFile(path, []byte("new.example.com\n"), 0644); err != nil {
+		t.Fatalf("failed to rewrite rules file: %v", err)
+	}
+	if err := f.Reload(); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+
+	if f.CheckQuestion("old.example.com") {
+		t.Error("expected old rule to be gone after reload")
+	}
+	if !f.CheckQuestion("new.example.com") {
+		t.Error("expected new rule to be active after reload")
+	}
+}
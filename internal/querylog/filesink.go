@@ -0,0 +1,256 @@
+package querylog
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	defaultMaxSizeMB = 100
+
+	// defaultQueueSize bounds the number of pending entries FileSink will
+	// buffer for its background writer, matching the ring buffer default in
+	// memorysink.go. A query arriving once the queue is full is dropped
+	// rather than blocking the resolver's hot path.
+	defaultQueueSize = 1000
+)
+
+// fileEntry is the JSON-lines record written by FileSink.
+type fileEntry struct {
+	Time      time.Time `json:"time"`
+	Client    string    `json:"client"`
+	Name      string    `json:"name"`
+	Qtype     string    `json:"qtype"`
+	Rcode     string    `json:"rcode"`
+	Upstream  string    `json:"upstream,omitempty"`
+	Source    string    `json:"source"`
+	LatencyMs float64   `json:"latency_ms"`
+}
+
+// FileSink appends each query to path as a JSON-lines record, rotating the
+// file once it reaches maxSizeMB or (if maxAge is non-zero) once the
+// current file has been open that long, and pruning rotated files older
+// than retentionDays (0 disables pruning).
+//
+// OnQuery never touches the file itself: it enqueues the entry onto a
+// bounded channel and returns immediately, so a slow disk never stalls the
+// resolver. A single background goroutine (run) owns the file, size, and
+// openedAt fields and does the actual marshaling, rotation, and writing.
+type FileSink struct {
+	path          string
+	maxSizeBytes  int64
+	maxAge        time.Duration
+	retentionDays int
+
+	file     *os.File
+	size     int64
+	openedAt time.Time
+
+	entries   chan fileEntry
+	done      chan struct{}
+	closeOnce sync.Once
+
+	// mu guards against OnQuery sending on entries after Close has closed
+	// it: Close takes the write lock before closing the channel, so it
+	// never races with a send still in flight under the read lock.
+	mu     sync.RWMutex
+	closed bool
+}
+
+// NewFileSink opens (creating if necessary) the JSON-lines log at path.
+// maxAgeHours rotates the file once it has been open that long, regardless
+// of size; 0 disables age-based rotation.
+func NewFileSink(path string, maxSizeMB, maxAgeHours, retentionDays int) (*FileSink, error) {
+	if path == "" {
+		return nil, fmt.Errorf("querylog: file sink requires a file_path")
+	}
+	if maxSizeMB <= 0 {
+		maxSizeMB = defaultMaxSizeMB
+	}
+
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, fmt.Errorf("querylog: failed to create log directory: %w", err)
+		}
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("querylog: failed to open log file: %w", err)
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("querylog: failed to stat log file: %w", err)
+	}
+
+	fs := &FileSink{
+		path:          path,
+		maxSizeBytes:  int64(maxSizeMB) * 1024 * 1024,
+		maxAge:        time.Duration(maxAgeHours) * time.Hour,
+		retentionDays: retentionDays,
+		file:          f,
+		size:          info.Size(),
+		openedAt:      time.Now(),
+		entries:       make(chan fileEntry, defaultQueueSize),
+		done:          make(chan struct{}),
+	}
+
+	fs.pruneOld()
+
+	go fs.run()
+
+	return fs, nil
+}
+
+// run consumes queued entries and writes them to disk until entries is
+// closed (by Close), then signals done. It is the only goroutine that
+// touches fs.file, fs.size, and fs.openedAt.
+func (fs *FileSink) run() {
+	defer close(fs.done)
+	for entry := range fs.entries {
+		fs.writeEntry(entry)
+	}
+}
+
+// OnQuery implements Logger. It builds the log entry and hands it to the
+// background writer via a bounded channel; if the channel is full the
+// entry is dropped so a slow disk can't block query resolution.
+func (fs *FileSink) OnQuery(client net.Addr, q dns.Question, resp *dns.Msg, upstream, source string, latency time.Duration) {
+	entry := fileEntry{
+		Time:      time.Now(),
+		Client:    clientString(client),
+		Name:      trimName(q.Name),
+		Qtype:     qtypeName(q),
+		Rcode:     rcodeName(resp),
+		Upstream:  upstream,
+		Source:    source,
+		LatencyMs: float64(latency) / float64(time.Millisecond),
+	}
+
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	if fs.closed {
+		return
+	}
+
+	select {
+	case fs.entries <- entry:
+	default:
+		// Queue full: drop rather than block the caller.
+	}
+}
+
+// writeEntry marshals and appends entry to the current file, rotating
+// first if entry would push the file past its size or age limit. Only
+// run calls this.
+func (fs *FileSink) writeEntry(entry fileEntry) {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	line = append(line, '\n')
+
+	if fs.size+int64(len(line)) > fs.maxSizeBytes || (fs.maxAge > 0 && time.Since(fs.openedAt) > fs.maxAge) {
+		if err := fs.rotate(); err != nil {
+			return
+		}
+	}
+
+	n, err := fs.file.Write(line)
+	if err != nil {
+		return
+	}
+	fs.size += int64(n)
+}
+
+// OnUpstreamError implements Logger. The file sink only records completed
+// queries, so a failed upstream attempt (which never produced a response)
+// is not written.
+func (fs *FileSink) OnUpstreamError(upstream string) {}
+
+// rotate renames the current log file aside with a timestamp suffix and
+// opens a fresh one in its place. Only run (via writeEntry) calls this.
+func (fs *FileSink) rotate() error {
+	if err := fs.file.Close(); err != nil {
+		return err
+	}
+
+	rotated := fmt.Sprintf("%s.%s", fs.path, time.Now().Format("20060102-150405.000000000"))
+	if err := os.Rename(fs.path, rotated); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(fs.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	fs.file = f
+	fs.size = 0
+	fs.openedAt = time.Now()
+	return nil
+}
+
+// pruneOld removes rotated files under retentionDays. Callers must not hold
+// fs.mu (it is only called from NewFileSink, before concurrent use begins).
+func (fs *FileSink) pruneOld() {
+	if fs.retentionDays <= 0 {
+		return
+	}
+
+	matches, err := filepath.Glob(fs.path + ".*")
+	if err != nil {
+		return
+	}
+
+	cutoff := time.Now().AddDate(0, 0, -fs.retentionDays)
+	for _, match := range matches {
+		info, err := os.Stat(match)
+		if err != nil {
+			continue
+		}
+		if info.ModTime().Before(cutoff) {
+			os.Remove(match)
+		}
+	}
+}
+
+// RotatedFiles returns the rotated log files for path, oldest first. It
+// exists mainly so tests can assert on rotation without reaching into
+// unexported fields.
+func RotatedFiles(path string) ([]string, error) {
+	matches, err := filepath.Glob(path + ".*")
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(matches)
+	return matches, nil
+}
+
+// Close stops accepting new entries, drains any already queued, and closes
+// the underlying log file. It blocks until the background writer has
+// finished, so every entry enqueued before Close was called is guaranteed
+// to be on disk once it returns.
+func (fs *FileSink) Close() error {
+	var err error
+	fs.closeOnce.Do(func() {
+		fs.mu.Lock()
+		fs.closed = true
+		close(fs.entries)
+		fs.mu.Unlock()
+
+		<-fs.done
+		err = fs.file.Close()
+	})
+	return err
+}
@@ -0,0 +1,94 @@
+package querylog
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const defaultRingBufferSize = 1000
+
+// Entry is the record exposed by MemorySink's HTTP endpoint.
+type Entry struct {
+	Time      time.Time `json:"time"`
+	Client    string    `json:"client"`
+	Name      string    `json:"name"`
+	Qtype     string    `json:"qtype"`
+	Rcode     string    `json:"rcode"`
+	Upstream  string    `json:"upstream,omitempty"`
+	Source    string    `json:"source"`
+	LatencyMs float64   `json:"latency_ms"`
+}
+
+// MemorySink keeps the most recent queries in a fixed-size ring buffer and
+// serves them as JSON over HTTP, so operators can inspect recent traffic
+// without standing up a file sink.
+type MemorySink struct {
+	mu      sync.Mutex
+	entries []Entry
+	next    int
+	full    bool
+}
+
+// NewMemorySink creates a MemorySink holding up to size recent entries. A
+// non-positive size falls back to defaultRingBufferSize.
+func NewMemorySink(size int) *MemorySink {
+	if size <= 0 {
+		size = defaultRingBufferSize
+	}
+	return &MemorySink{entries: make([]Entry, size)}
+}
+
+// OnQuery implements Logger.
+func (m *MemorySink) OnQuery(client net.Addr, q dns.Question, resp *dns.Msg, upstream, source string, latency time.Duration) {
+	entry := Entry{
+		Time:      time.Now(),
+		Client:    clientString(client),
+		Name:      trimName(q.Name),
+		Qtype:     qtypeName(q),
+		Rcode:     rcodeName(resp),
+		Upstream:  upstream,
+		Source:    source,
+		LatencyMs: float64(latency) / float64(time.Millisecond),
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.entries[m.next] = entry
+	m.next = (m.next + 1) % len(m.entries)
+	if m.next == 0 {
+		m.full = true
+	}
+}
+
+// OnUpstreamError implements Logger. The memory sink only records completed
+// queries, so a failed upstream attempt is not written.
+func (m *MemorySink) OnUpstreamError(upstream string) {}
+
+// Recent returns the buffered entries, most recent last.
+func (m *MemorySink) Recent() []Entry {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.full {
+		out := make([]Entry, m.next)
+		copy(out, m.entries[:m.next])
+		return out
+	}
+
+	out := make([]Entry, len(m.entries))
+	copy(out, m.entries[m.next:])
+	copy(out[len(m.entries)-m.next:], m.entries[:m.next])
+	return out
+}
+
+// ServeHTTP serves the buffered entries as a JSON array, most recent last.
+func (m *MemorySink) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(m.Recent())
+}
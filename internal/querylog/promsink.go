@@ -0,0 +1,180 @@
+package querylog
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// latencyBucketsSeconds are the upper bounds (inclusive, seconds) of the
+// query duration histogram buckets, mirroring typical Prometheus DNS
+// latency histograms.
+var latencyBucketsSeconds = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1}
+
+// queryCounterKey identifies one dns_queries_total series.
+type queryCounterKey struct {
+	source string
+	qtype  string
+	rcode  string
+}
+
+// latencyHistogram accumulates a Prometheus-style cumulative histogram
+// over latencyBucketsSeconds.
+type latencyHistogram struct {
+	buckets []int64 // parallel to latencyBucketsSeconds, cumulative counts
+	count   int64
+	sum     float64
+}
+
+func newLatencyHistogram() *latencyHistogram {
+	return &latencyHistogram{buckets: make([]int64, len(latencyBucketsSeconds))}
+}
+
+func (h *latencyHistogram) observe(seconds float64) {
+	h.count++
+	h.sum += seconds
+	for i, bound := range latencyBucketsSeconds {
+		if seconds <= bound {
+			h.buckets[i]++
+		}
+	}
+}
+
+// PrometheusSink accumulates query counters and a query duration histogram
+// in Prometheus's text exposition format, without depending on the official
+// client library.
+type PrometheusSink struct {
+	mu sync.Mutex
+
+	queriesTotal      map[queryCounterKey]int64
+	upstreamErrors    map[string]int64
+	cacheHits         int64
+	cacheMisses       int64
+	latency           *latencyHistogram
+	latencyByUpstream map[string]*latencyHistogram
+}
+
+// NewPrometheusSink creates an empty PrometheusSink.
+func NewPrometheusSink() *PrometheusSink {
+	return &PrometheusSink{
+		queriesTotal:      make(map[queryCounterKey]int64),
+		upstreamErrors:    make(map[string]int64),
+		latency:           newLatencyHistogram(),
+		latencyByUpstream: make(map[string]*latencyHistogram),
+	}
+}
+
+// OnQuery implements Logger.
+func (p *PrometheusSink) OnQuery(client net.Addr, q dns.Question, resp *dns.Msg, upstream, source string, latency time.Duration) {
+	latencySeconds := latency.Seconds()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.queriesTotal[queryCounterKey{source: source, qtype: qtypeName(q), rcode: rcodeName(resp)}]++
+
+	if source == SourceCache {
+		p.cacheHits++
+	} else {
+		p.cacheMisses++
+	}
+
+	p.latency.observe(latencySeconds)
+
+	if source == SourceUpstream && upstream != "" {
+		h, ok := p.latencyByUpstream[upstream]
+		if !ok {
+			h = newLatencyHistogram()
+			p.latencyByUpstream[upstream] = h
+		}
+		h.observe(latencySeconds)
+	}
+}
+
+// OnUpstreamError implements Logger.
+func (p *PrometheusSink) OnUpstreamError(upstream string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.upstreamErrors[upstream]++
+}
+
+// CacheHitRatio returns the fraction of queries answered from cache.
+func (p *PrometheusSink) CacheHitRatio() float64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	total := p.cacheHits + p.cacheMisses
+	if total == 0 {
+		return 0
+	}
+	return float64(p.cacheHits) / float64(total)
+}
+
+// ServeHTTP renders the accumulated counters in Prometheus text exposition
+// format.
+func (p *PrometheusSink) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	fmt.Fprintln(w, "# HELP dns_queries_total Total DNS queries handled, by resolution source, query type, and response code.")
+	fmt.Fprintln(w, "# TYPE dns_queries_total counter")
+	for key, count := range p.queriesTotal {
+		fmt.Fprintf(w, "dns_queries_total{source=%q,qtype=%q,rcode=%q} %d\n", key.source, key.qtype, key.rcode, count)
+	}
+
+	fmt.Fprintln(w, "# HELP dns_upstream_errors_total Failed query attempts against an upstream server.")
+	fmt.Fprintln(w, "# TYPE dns_upstream_errors_total counter")
+	for server, count := range p.upstreamErrors {
+		fmt.Fprintf(w, "dns_upstream_errors_total{server=%q} %d\n", server, count)
+	}
+
+	fmt.Fprintln(w, "# HELP dns_query_duration_seconds Query duration in seconds.")
+	fmt.Fprintln(w, "# TYPE dns_query_duration_seconds histogram")
+	writeHistogram(w, "dns_query_duration_seconds", "", p.latency)
+
+	fmt.Fprintln(w, "# HELP dns_upstream_query_duration_seconds Query duration in seconds, by upstream server.")
+	fmt.Fprintln(w, "# TYPE dns_upstream_query_duration_seconds histogram")
+	for server, h := range p.latencyByUpstream {
+		writeHistogram(w, "dns_upstream_query_duration_seconds", fmt.Sprintf("server=%q", server), h)
+	}
+
+	total := p.cacheHits + p.cacheMisses
+	var ratio float64
+	if total > 0 {
+		ratio = float64(p.cacheHits) / float64(total)
+	}
+	fmt.Fprintln(w, "# HELP dns_cache_hit_ratio Fraction of queries answered from cache.")
+	fmt.Fprintln(w, "# TYPE dns_cache_hit_ratio gauge")
+	fmt.Fprintf(w, "dns_cache_hit_ratio %g\n", ratio)
+}
+
+// writeHistogram renders one cumulative histogram series named name, with
+// labels (already formatted as e.g. `server="1.1.1.1"`, or "" for none)
+// applied to every bucket/sum/count line.
+func writeHistogram(w http.ResponseWriter, name, labels string, h *latencyHistogram) {
+	labelFor := func(extra string) string {
+		switch {
+		case labels == "" && extra == "":
+			return ""
+		case labels == "":
+			return "{" + extra + "}"
+		case extra == "":
+			return "{" + labels + "}"
+		default:
+			return "{" + labels + "," + extra + "}"
+		}
+	}
+
+	for i, bound := range latencyBucketsSeconds {
+		fmt.Fprintf(w, "%s_bucket%s %d\n", name, labelFor(fmt.Sprintf("le=%q", fmt.Sprintf("%g", bound))), h.buckets[i])
+	}
+	fmt.Fprintf(w, "%s_bucket%s %d\n", name, labelFor(`le="+Inf"`), h.count)
+	fmt.Fprintf(w, "%s_sum%s %g\n", name, labelFor(""), h.sum)
+	fmt.Fprintf(w, "%s_count%s %d\n", name, labelFor(""), h.count)
+}
@@ -0,0 +1,218 @@
+// Package querylog records completed DNS queries to one or more pluggable
+// sinks (a JSON-lines file, an in-memory ring buffer, or a Prometheus-style
+// exporter), so operators can audit or monitor traffic without coupling the
+// resolver to any particular backend.
+package querylog
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/risadams/Pocket-Concierge/internal/config"
+)
+
+// Source identifies which resolver-chain stage answered a query, for the
+// "source" label on sinks that break queries down that way.
+const (
+	SourceBlocked  = "blocked"
+	SourceLocal    = "local"
+	SourceCache    = "cache"
+	SourceUpstream = "upstream"
+)
+
+// Logger records a single completed query, tagged with the source that
+// answered it (one of the Source* constants), and a failed upstream
+// attempt that never produced an answer at all.
+type Logger interface {
+	OnQuery(client net.Addr, q dns.Question, resp *dns.Msg, upstream, source string, latency time.Duration)
+	OnUpstreamError(upstream string)
+}
+
+// MetricsHandlerer is implemented by a Logger that can serve its metrics
+// over HTTP (currently the "prometheus" sink's fan-out). Callers that need
+// to expose a /metrics endpoint type-assert a Logger against this interface
+// rather than depending on the unexported multiLogger type.
+type MetricsHandlerer interface {
+	MetricsHandler() http.Handler
+}
+
+// StatsHandlerer is implemented by a Logger that can serve top-N query
+// statistics over HTTP (currently the "memory" sink's fan-out). Callers
+// that need to expose a /stats endpoint type-assert a Logger against this
+// interface rather than depending on the unexported multiLogger type.
+type StatsHandlerer interface {
+	StatsHandler() http.Handler
+}
+
+// Closer is implemented by a Logger that holds resources needing cleanup
+// on shutdown (currently the "file" sink's background writer and open
+// file handle). Callers type-assert a Logger against this interface the
+// same way they do for MetricsHandlerer/StatsHandlerer.
+type Closer interface {
+	Close() error
+}
+
+// New builds a Logger that fans out to every sink listed in cfg.Sinks. It
+// returns a nil Logger (and a nil error) when no sinks are configured, so
+// callers can treat a nil Logger as "logging disabled".
+func New(cfg config.QueryLogConfig) (Logger, error) {
+	if len(cfg.Sinks) == 0 {
+		return nil, nil
+	}
+
+	var loggers []Logger
+	for _, sink := range cfg.Sinks {
+		switch sink {
+		case "file":
+			fs, err := NewFileSink(cfg.FilePath, cfg.MaxSizeMB, cfg.MaxAgeHours, cfg.RetentionDays)
+			if err != nil {
+				return nil, err
+			}
+			loggers = append(loggers, fs)
+		case "memory":
+			loggers = append(loggers, NewMemorySink(cfg.RingBufferSize))
+		case "prometheus":
+			loggers = append(loggers, NewPrometheusSink())
+		}
+	}
+
+	return &multiLogger{loggers: loggers, anonymize: cfg.AnonymizeClients}, nil
+}
+
+// multiLogger dispatches OnQuery to every configured sink, anonymizing the
+// client address first if the operator asked for it.
+type multiLogger struct {
+	loggers   []Logger
+	anonymize bool
+}
+
+func (m *multiLogger) OnQuery(client net.Addr, q dns.Question, resp *dns.Msg, upstream, source string, latency time.Duration) {
+	if m.anonymize {
+		client = anonymizeAddr(client)
+	}
+	for _, l := range m.loggers {
+		l.OnQuery(client, q, resp, upstream, source, latency)
+	}
+}
+
+func (m *multiLogger) OnUpstreamError(upstream string) {
+	for _, l := range m.loggers {
+		l.OnUpstreamError(upstream)
+	}
+}
+
+// MetricsHandler implements MetricsHandlerer, returning the "prometheus"
+// sink's handler if one is configured, or nil otherwise.
+func (m *multiLogger) MetricsHandler() http.Handler {
+	for _, l := range m.loggers {
+		if p, ok := l.(*PrometheusSink); ok {
+			return p
+		}
+	}
+	return nil
+}
+
+// StatsHandler implements StatsHandlerer, returning the "memory" sink's
+// top-N stats handler if one is configured, or nil otherwise.
+func (m *multiLogger) StatsHandler() http.Handler {
+	for _, l := range m.loggers {
+		if ms, ok := l.(*MemorySink); ok {
+			return ms.StatsHandler()
+		}
+	}
+	return nil
+}
+
+// Close implements Closer, closing every configured sink that holds
+// closeable resources (currently just the "file" sink).
+func (m *multiLogger) Close() error {
+	var errs []string
+	for _, l := range m.loggers {
+		if c, ok := l.(Closer); ok {
+			if err := c.Close(); err != nil {
+				errs = append(errs, err.Error())
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("querylog: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// anonymizeAddr drops the last octet of an IPv4 client address or the last
+// 80 bits (10 bytes) of an IPv6 one, satisfying privacy-conscious users who
+// don't want individual client IPs retained in the query log.
+func anonymizeAddr(addr net.Addr) net.Addr {
+	if addr == nil {
+		return nil
+	}
+
+	host, port, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		host = addr.String()
+		port = ""
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return addr
+	}
+
+	if v4 := ip.To4(); v4 != nil {
+		v4[3] = 0
+		return &net.UDPAddr{IP: v4, Port: atoiOrZero(port)}
+	}
+
+	v6 := ip.To16()
+	for i := 6; i < 16; i++ {
+		v6[i] = 0
+	}
+	return &net.UDPAddr{IP: v6, Port: atoiOrZero(port)}
+}
+
+func atoiOrZero(s string) int {
+	n := 0
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return 0
+		}
+		n = n*10 + int(c-'0')
+	}
+	return n
+}
+
+// rcodeName formats resp's Rcode for display/export, defaulting to
+// "NOERROR" for a nil response.
+func rcodeName(resp *dns.Msg) string {
+	if resp == nil {
+		return dns.RcodeToString[dns.RcodeSuccess]
+	}
+	if name, ok := dns.RcodeToString[resp.Rcode]; ok {
+		return name
+	}
+	return "UNKNOWN"
+}
+
+// qtypeName formats q's type for display/export, e.g. "A", "AAAA", "HTTPS".
+func qtypeName(q dns.Question) string {
+	if name, ok := dns.TypeToString[q.Qtype]; ok {
+		return name
+	}
+	return "UNKNOWN"
+}
+
+func clientString(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	return addr.String()
+}
+
+func trimName(name string) string {
+	return strings.TrimSuffix(name, ".")
+}
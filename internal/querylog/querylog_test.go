@@ -0,0 +1,263 @@
+package querylog
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/risadams/Pocket-Concierge/internal/config"
+)
+
+func TestNewReturnsNilLoggerWithoutSinks(t *testing.T) {
+	logger, err := New(config.QueryLogConfig{})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if logger != nil {
+		t.Fatal("expected a nil Logger when no sinks are configured")
+	}
+}
+
+func TestFileSinkRotatesOnSizeLimit(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "queries.jsonl")
+
+	fs, err := NewFileSink(path, 0, 0, 0) // 0 -> defaultMaxSizeMB, far too big to hit naturally
+	if err != nil {
+		t.Fatalf("NewFileSink failed: %v", err)
+	}
+	fs.maxSizeBytes = 64 // force rotation almost immediately for the test
+
+	client := &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 5353}
+	q := dns.Question{Name: "example.com.", Qtype: dns.TypeA}
+
+	for i := 0; i < 10; i++ {
+		fs.OnQuery(client, q, &dns.Msg{}, "1.1.1.1", SourceUpstream, time.Millisecond)
+	}
+
+	if err := fs.Close(); err != nil { // drains the background writer before we inspect the files
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	rotated, err := RotatedFiles(path)
+	if err != nil {
+		t.Fatalf("RotatedFiles failed: %v", err)
+	}
+	if len(rotated) == 0 {
+		t.Fatal("expected at least one rotated file after exceeding the size limit")
+	}
+
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("expected current log file to still exist: %v", err)
+	}
+}
+
+func TestFileSinkRequiresFilePath(t *testing.T) {
+	if _, err := NewFileSink("", 10, 0, 0); err == nil {
+		t.Fatal("expected an error when file_path is empty")
+	}
+}
+
+func TestFileSinkRotatesOnAgeLimit(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "queries.jsonl")
+
+	fs, err := NewFileSink(path, 0, 1, 0) // maxAgeHours: 1
+	if err != nil {
+		t.Fatalf("NewFileSink failed: %v", err)
+	}
+	fs.openedAt = time.Now().Add(-2 * time.Hour) // force the current file to look stale
+
+	q := dns.Question{Name: "example.com.", Qtype: dns.TypeA}
+	fs.OnQuery(nil, q, &dns.Msg{}, "1.1.1.1", SourceUpstream, time.Millisecond)
+
+	if err := fs.Close(); err != nil { // drains the background writer before we inspect the files
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	rotated, err := RotatedFiles(path)
+	if err != nil {
+		t.Fatalf("RotatedFiles failed: %v", err)
+	}
+	if len(rotated) == 0 {
+		t.Fatal("expected at least one rotated file after exceeding max_age_hours")
+	}
+}
+
+func TestFileSinkCloseDrainsQueuedEntries(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "queries.jsonl")
+
+	fs, err := NewFileSink(path, 0, 0, 0)
+	if err != nil {
+		t.Fatalf("NewFileSink failed: %v", err)
+	}
+
+	q := dns.Question{Name: "example.com.", Qtype: dns.TypeA}
+	for i := 0; i < 50; i++ {
+		fs.OnQuery(nil, q, &dns.Msg{}, "1.1.1.1", SourceUpstream, time.Millisecond)
+	}
+
+	if err := fs.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile failed: %v", err)
+	}
+	if got := strings.Count(string(data), "\n"); got != 50 {
+		t.Fatalf("expected all 50 queued entries flushed before Close returned, got %d lines", got)
+	}
+
+	fs.OnQuery(nil, q, &dns.Msg{}, "1.1.1.1", SourceUpstream, time.Millisecond) // must not panic after Close
+}
+
+func TestMultiLoggerCloseClosesFileSink(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "queries.jsonl")
+
+	logger, err := New(config.QueryLogConfig{
+		Sinks:    []string{"file", "memory"},
+		FilePath: path,
+	})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	closer, ok := logger.(Closer)
+	if !ok {
+		t.Fatal("expected the returned Logger to implement Closer")
+	}
+	if err := closer.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("expected log file to exist after Close: %v", err)
+	}
+}
+
+func TestMultiLoggerAnonymizesClientIPv4(t *testing.T) {
+	logger, err := New(config.QueryLogConfig{
+		Sinks:            []string{"memory"},
+		RingBufferSize:   10,
+		AnonymizeClients: true,
+	})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	client := &net.UDPAddr{IP: net.ParseIP("192.0.2.42"), Port: 5353}
+	q := dns.Question{Name: "example.com.", Qtype: dns.TypeA}
+	logger.(*multiLogger).OnQuery(client, q, &dns.Msg{}, "", SourceCache, time.Millisecond)
+
+	entries := logger.(*multiLogger).loggers[0].(*MemorySink).Recent()
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 entry, got %d", len(entries))
+	}
+	if entries[0].Client != "192.0.2.0:5353" {
+		t.Errorf("expected anonymized client 192.0.2.0:5353, got %s", entries[0].Client)
+	}
+}
+
+func TestMemorySinkRingBufferWrapsAround(t *testing.T) {
+	mem := NewMemorySink(3)
+	q := dns.Question{Name: "example.com.", Qtype: dns.TypeA}
+
+	for i := 0; i < 5; i++ {
+		mem.OnQuery(nil, q, &dns.Msg{}, "", SourceUpstream, time.Millisecond)
+	}
+
+	entries := mem.Recent()
+	if len(entries) != 3 {
+		t.Fatalf("expected ring buffer capped at 3, got %d", len(entries))
+	}
+}
+
+func TestPrometheusSinkTracksCacheHitRatio(t *testing.T) {
+	p := NewPrometheusSink()
+	q := dns.Question{Name: "example.com.", Qtype: dns.TypeA}
+
+	p.OnQuery(nil, q, &dns.Msg{}, "", SourceCache, time.Millisecond)
+	p.OnQuery(nil, q, &dns.Msg{}, "1.1.1.1", SourceUpstream, time.Millisecond)
+	p.OnQuery(nil, q, &dns.Msg{}, "1.1.1.1", SourceUpstream, time.Millisecond)
+
+	if ratio := p.CacheHitRatio(); ratio != 1.0/3.0 {
+		t.Errorf("expected cache hit ratio 1/3, got %f", ratio)
+	}
+}
+
+func TestPrometheusSinkTracksUpstreamErrors(t *testing.T) {
+	p := NewPrometheusSink()
+
+	p.OnUpstreamError("1.1.1.1")
+	p.OnUpstreamError("1.1.1.1")
+	p.OnUpstreamError("8.8.8.8")
+
+	if got := p.upstreamErrors["1.1.1.1"]; got != 2 {
+		t.Errorf("expected 2 errors recorded for 1.1.1.1, got %d", got)
+	}
+	if got := p.upstreamErrors["8.8.8.8"]; got != 1 {
+		t.Errorf("expected 1 error recorded for 8.8.8.8, got %d", got)
+	}
+}
+
+func TestPrometheusSinkServesPerUpstreamHistogramAndCacheRatio(t *testing.T) {
+	p := NewPrometheusSink()
+	q := dns.Question{Name: "example.com.", Qtype: dns.TypeA}
+
+	p.OnQuery(nil, q, &dns.Msg{}, "", SourceCache, time.Millisecond)
+	p.OnQuery(nil, q, &dns.Msg{}, "1.1.1.1", SourceUpstream, 5*time.Millisecond)
+	p.OnQuery(nil, q, &dns.Msg{}, "8.8.8.8", SourceUpstream, 5*time.Millisecond)
+
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := rec.Body.String()
+
+	if !strings.Contains(body, `dns_upstream_query_duration_seconds_count{server="1.1.1.1"} 1`) {
+		t.Errorf("expected a per-upstream histogram count for 1.1.1.1, got:\n%s", body)
+	}
+	if !strings.Contains(body, `dns_upstream_query_duration_seconds_count{server="8.8.8.8"} 1`) {
+		t.Errorf("expected a per-upstream histogram count for 8.8.8.8, got:\n%s", body)
+	}
+	if !strings.Contains(body, "dns_cache_hit_ratio 0.3333333333333333") {
+		t.Errorf("expected a rendered cache hit ratio gauge, got:\n%s", body)
+	}
+}
+
+func TestMultiLoggerMetricsHandlerFindsPrometheusSink(t *testing.T) {
+	logger, err := New(config.QueryLogConfig{Sinks: []string{"memory", "prometheus"}, RingBufferSize: 10})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	mh, ok := logger.(MetricsHandlerer)
+	if !ok {
+		t.Fatal("expected the returned Logger to implement MetricsHandlerer")
+	}
+	if mh.MetricsHandler() == nil {
+		t.Fatal("expected a non-nil metrics handler when a prometheus sink is configured")
+	}
+}
+
+func TestMultiLoggerMetricsHandlerNilWithoutPrometheusSink(t *testing.T) {
+	logger, err := New(config.QueryLogConfig{Sinks: []string{"memory"}, RingBufferSize: 10})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	mh, ok := logger.(MetricsHandlerer)
+	if !ok {
+		t.Fatal("expected the returned Logger to implement MetricsHandlerer")
+	}
+	if mh.MetricsHandler() != nil {
+		t.Fatal("expected a nil metrics handler without a prometheus sink")
+	}
+}
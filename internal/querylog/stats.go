@@ -0,0 +1,146 @@
+package querylog
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+)
+
+const defaultStatsTopN = 10
+
+// CountStat is one entry in a top-N ranking: a client or domain and how
+// many queries it accounted for in the window.
+type CountStat struct {
+	Key   string `json:"key"`
+	Count int    `json:"count"`
+}
+
+// UpstreamLatency reports latency percentiles for one upstream server,
+// computed over every query attributed to it in the window.
+type UpstreamLatency struct {
+	Upstream string  `json:"upstream"`
+	Count    int     `json:"count"`
+	P50Ms    float64 `json:"p50_ms"`
+	P90Ms    float64 `json:"p90_ms"`
+	P99Ms    float64 `json:"p99_ms"`
+}
+
+// Stats is the top-N summary MemorySink.Stats computes over its current
+// ring buffer.
+type Stats struct {
+	TopClients      []CountStat       `json:"top_clients"`
+	TopDomains      []CountStat       `json:"top_domains"`
+	TopBlocked      []CountStat       `json:"top_blocked"`
+	UpstreamLatency []UpstreamLatency `json:"upstream_latency"`
+}
+
+// Stats computes top-N client, domain, and blocked-domain counts, and
+// per-upstream latency percentiles, over the entries currently buffered.
+// The ring buffer itself is the sliding window: it naturally ages out the
+// oldest entries as new ones arrive, so no separate time-based windowing
+// is needed on top of it.
+func (m *MemorySink) Stats(topN int) Stats {
+	if topN <= 0 {
+		topN = defaultStatsTopN
+	}
+
+	entries := m.Recent()
+
+	clientCounts := make(map[string]int)
+	domainCounts := make(map[string]int)
+	blockedCounts := make(map[string]int)
+	upstreamLatencies := make(map[string][]float64)
+
+	for _, e := range entries {
+		if e.Client != "" {
+			clientCounts[e.Client]++
+		}
+		if e.Name != "" {
+			domainCounts[e.Name]++
+			if e.Source == SourceBlocked {
+				blockedCounts[e.Name]++
+			}
+		}
+		if e.Upstream != "" {
+			upstreamLatencies[e.Upstream] = append(upstreamLatencies[e.Upstream], e.LatencyMs)
+		}
+	}
+
+	return Stats{
+		TopClients:      topCounts(clientCounts, topN),
+		TopDomains:      topCounts(domainCounts, topN),
+		TopBlocked:      topCounts(blockedCounts, topN),
+		UpstreamLatency: upstreamLatencyStats(upstreamLatencies),
+	}
+}
+
+// topCounts sorts counts descending (ties broken by key, for a stable
+// order) and returns at most topN of them.
+func topCounts(counts map[string]int, topN int) []CountStat {
+	stats := make([]CountStat, 0, len(counts))
+	for key, count := range counts {
+		stats = append(stats, CountStat{Key: key, Count: count})
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Count != stats[j].Count {
+			return stats[i].Count > stats[j].Count
+		}
+		return stats[i].Key < stats[j].Key
+	})
+	if len(stats) > topN {
+		stats = stats[:topN]
+	}
+	return stats
+}
+
+// upstreamLatencyStats computes p50/p90/p99 latency for each upstream,
+// sorted by query count descending.
+func upstreamLatencyStats(latencies map[string][]float64) []UpstreamLatency {
+	stats := make([]UpstreamLatency, 0, len(latencies))
+	for upstream, samples := range latencies {
+		sort.Float64s(samples)
+		stats = append(stats, UpstreamLatency{
+			Upstream: upstream,
+			Count:    len(samples),
+			P50Ms:    percentile(samples, 0.50),
+			P90Ms:    percentile(samples, 0.90),
+			P99Ms:    percentile(samples, 0.99),
+		})
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Count != stats[j].Count {
+			return stats[i].Count > stats[j].Count
+		}
+		return stats[i].Upstream < stats[j].Upstream
+	})
+	return stats
+}
+
+// percentile returns the p-th percentile (0 <= p <= 1) of sorted, using a
+// nearest-rank estimate that needs no interpolation.
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	idx := int(p * float64(len(sorted)))
+	if idx >= len(sorted) {
+		idx = len(sorted) - 1
+	}
+	return sorted[idx]
+}
+
+// StatsHandler serves the current Stats as JSON, computing top-N rankings
+// fresh on every request. The "n" query parameter overrides topN (default
+// defaultStatsTopN).
+func (m *MemorySink) StatsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		topN := defaultStatsTopN
+		if n, err := strconv.Atoi(r.URL.Query().Get("n")); err == nil && n > 0 {
+			topN = n
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(m.Stats(topN))
+	})
+}
@@ -0,0 +1,148 @@
+package querylog
+
+import (
+	"encoding/json"
+	"net"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/risadams/Pocket-Concierge/internal/config"
+)
+
+func TestMemorySinkStatsTopClientsAndDomains(t *testing.T) {
+	mem := NewMemorySink(100)
+
+	client1 := &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 5353}
+	client2 := &net.UDPAddr{IP: net.ParseIP("192.0.2.2"), Port: 5353}
+
+	mem.OnQuery(client1, dns.Question{Name: "a.com.", Qtype: dns.TypeA}, &dns.Msg{}, "1.1.1.1", SourceUpstream, time.Millisecond)
+	mem.OnQuery(client1, dns.Question{Name: "a.com.", Qtype: dns.TypeA}, &dns.Msg{}, "1.1.1.1", SourceUpstream, time.Millisecond)
+	mem.OnQuery(client2, dns.Question{Name: "b.com.", Qtype: dns.TypeA}, &dns.Msg{}, "1.1.1.1", SourceUpstream, time.Millisecond)
+
+	stats := mem.Stats(10)
+
+	if len(stats.TopClients) != 2 || stats.TopClients[0].Key != client1.String() || stats.TopClients[0].Count != 2 {
+		t.Fatalf("expected client1 to lead with count 2, got %+v", stats.TopClients)
+	}
+	if len(stats.TopDomains) != 2 || stats.TopDomains[0].Key != "a.com" || stats.TopDomains[0].Count != 2 {
+		t.Fatalf("expected a.com to lead with count 2, got %+v", stats.TopDomains)
+	}
+}
+
+func TestMemorySinkStatsTopBlocked(t *testing.T) {
+	mem := NewMemorySink(100)
+
+	mem.OnQuery(nil, dns.Question{Name: "ads.example.", Qtype: dns.TypeA}, &dns.Msg{}, "", SourceBlocked, 0)
+	mem.OnQuery(nil, dns.Question{Name: "ads.example.", Qtype: dns.TypeA}, &dns.Msg{}, "", SourceBlocked, 0)
+	mem.OnQuery(nil, dns.Question{Name: "good.example.", Qtype: dns.TypeA}, &dns.Msg{}, "1.1.1.1", SourceUpstream, time.Millisecond)
+
+	stats := mem.Stats(10)
+
+	if len(stats.TopBlocked) != 1 || stats.TopBlocked[0].Key != "ads.example" || stats.TopBlocked[0].Count != 2 {
+		t.Fatalf("expected ads.example blocked twice, got %+v", stats.TopBlocked)
+	}
+}
+
+func TestMemorySinkStatsRespectsTopN(t *testing.T) {
+	mem := NewMemorySink(100)
+	q := dns.Question{Name: "x.com.", Qtype: dns.TypeA}
+
+	for i := 0; i < 5; i++ {
+		client := &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 5000 + i}
+		mem.OnQuery(client, q, &dns.Msg{}, "1.1.1.1", SourceUpstream, time.Millisecond)
+	}
+
+	stats := mem.Stats(2)
+	if len(stats.TopClients) != 2 {
+		t.Fatalf("expected topN=2 to cap results, got %d", len(stats.TopClients))
+	}
+}
+
+func TestMemorySinkStatsUpstreamLatencyPercentiles(t *testing.T) {
+	mem := NewMemorySink(100)
+	q := dns.Question{Name: "x.com.", Qtype: dns.TypeA}
+
+	for i := 1; i <= 100; i++ {
+		mem.OnQuery(nil, q, &dns.Msg{}, "1.1.1.1", SourceUpstream, time.Duration(i)*time.Millisecond)
+	}
+
+	stats := mem.Stats(10)
+	if len(stats.UpstreamLatency) != 1 {
+		t.Fatalf("expected stats for 1 upstream, got %d", len(stats.UpstreamLatency))
+	}
+
+	lat := stats.UpstreamLatency[0]
+	if lat.Upstream != "1.1.1.1" || lat.Count != 100 {
+		t.Fatalf("expected 100 samples for 1.1.1.1, got %+v", lat)
+	}
+	if lat.P50Ms < 45 || lat.P50Ms > 55 {
+		t.Errorf("expected p50 near 50ms, got %f", lat.P50Ms)
+	}
+	if lat.P99Ms < 95 {
+		t.Errorf("expected p99 near 99-100ms, got %f", lat.P99Ms)
+	}
+}
+
+func TestMemorySinkStatsHandlerServesJSON(t *testing.T) {
+	mem := NewMemorySink(100)
+	mem.OnQuery(nil, dns.Question{Name: "x.com.", Qtype: dns.TypeA}, &dns.Msg{}, "1.1.1.1", SourceUpstream, time.Millisecond)
+
+	req := httptest.NewRequest("GET", "/stats?n=5", nil)
+	rec := httptest.NewRecorder()
+	mem.StatsHandler().ServeHTTP(rec, req)
+
+	var stats Stats
+	if err := json.Unmarshal(rec.Body.Bytes(), &stats); err != nil {
+		t.Fatalf("failed to decode stats JSON: %v", err)
+	}
+	if len(stats.TopDomains) != 1 || stats.TopDomains[0].Key != "x.com" {
+		t.Fatalf("expected x.com in top domains, got %+v", stats.TopDomains)
+	}
+}
+
+func TestMultiLoggerStatsHandlerFindsMemorySink(t *testing.T) {
+	logger, err := New(config.QueryLogConfig{Sinks: []string{"memory", "prometheus"}, RingBufferSize: 10})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	sh, ok := logger.(StatsHandlerer)
+	if !ok {
+		t.Fatal("expected the returned Logger to implement StatsHandlerer")
+	}
+	if sh.StatsHandler() == nil {
+		t.Fatal("expected a non-nil stats handler when a memory sink is configured")
+	}
+}
+
+func TestMultiLoggerStatsHandlerNilWithoutMemorySink(t *testing.T) {
+	logger, err := New(config.QueryLogConfig{Sinks: []string{"prometheus"}})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	sh, ok := logger.(StatsHandlerer)
+	if !ok {
+		t.Fatal("expected the returned Logger to implement StatsHandlerer")
+	}
+	if sh.StatsHandler() != nil {
+		t.Fatal("expected a nil stats handler without a memory sink")
+	}
+}
+
+// BenchmarkMemorySinkOnQuery measures the per-query overhead of recording
+// to the ring buffer, which should stay well under 5µs so query logging
+// never becomes a meaningful fraction of overall query latency.
+func BenchmarkMemorySinkOnQuery(b *testing.B) {
+	mem := NewMemorySink(1000)
+	client := &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 5353}
+	q := dns.Question{Name: "example.com.", Qtype: dns.TypeA}
+	resp := &dns.Msg{}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		mem.OnQuery(client, q, resp, "1.1.1.1", SourceUpstream, time.Millisecond)
+	}
+}
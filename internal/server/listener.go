@@ -0,0 +1,295 @@
+package server
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// listener abstracts one network transport the server serves DNS over:
+// plain UDP/TCP, DoT (TLS-wrapped dns.Server), or DoH (HTTP).
+type listener interface {
+	Start() error
+	Shutdown() error
+	Status() ListenerStatus
+}
+
+// ListenerStatus reports one listener's transport, address, and activity
+// for Server.GetStats.
+type ListenerStatus struct {
+	Net     string `json:"net"`
+	Address string `json:"address"`
+	Running bool   `json:"running"`
+	Queries int64  `json:"queries"`
+}
+
+// countingHandler wraps a dns.Handler to count the queries it serves,
+// without the handler itself needing to know which listener it's behind.
+type countingHandler struct {
+	handler dns.Handler
+	queries int64
+}
+
+func (c *countingHandler) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+	atomic.AddInt64(&c.queries, 1)
+	c.handler.ServeDNS(w, r)
+}
+
+func (c *countingHandler) count() int64 {
+	return atomic.LoadInt64(&c.queries)
+}
+
+// dnsListener serves plain UDP/TCP or DoT (TLS-wrapped TCP) using the
+// standard *dns.Server. TCP and DoT share the handler's full message-size
+// handling; only the UDP listener carries the UDPSize cap.
+type dnsListener struct {
+	netProto string
+	addr     string
+	server   *dns.Server
+	counter  *countingHandler
+
+	mu      sync.Mutex
+	running bool
+}
+
+// newDNSListener builds a udp or tcp listener. Pass a non-nil tlsConfig to
+// serve DoT instead of plain TCP.
+func newDNSListener(netProto, addr string, handler dns.Handler, tlsConfig *tls.Config) *dnsListener {
+	counter := &countingHandler{handler: handler}
+
+	srv := &dns.Server{
+		Addr:         addr,
+		Net:          netProto,
+		Handler:      counter,
+		ReadTimeout:  3 * time.Second,
+		WriteTimeout: 3 * time.Second,
+	}
+	if netProto == "udp" {
+		srv.UDPSize = 65535
+	}
+	if tlsConfig != nil {
+		srv.Net = "tcp-tls"
+		srv.TLSConfig = tlsConfig
+	}
+
+	return &dnsListener{netProto: netProto, addr: addr, server: srv, counter: counter}
+}
+
+func (l *dnsListener) Start() error {
+	l.mu.Lock()
+	l.running = true
+	l.mu.Unlock()
+
+	err := l.server.ListenAndServe()
+
+	l.mu.Lock()
+	l.running = false
+	l.mu.Unlock()
+
+	return err
+}
+
+func (l *dnsListener) Shutdown() error {
+	return l.server.Shutdown()
+}
+
+func (l *dnsListener) Status() ListenerStatus {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return ListenerStatus{
+		Net:     l.netProto,
+		Address: l.addr,
+		Running: l.running,
+		Queries: l.counter.count(),
+	}
+}
+
+// dohHandler is the subset of *dnshandler.Handler that dohListener needs:
+// resolving a decoded query into a response, synchronously, through the
+// same resolution path every other listener uses.
+type dohHandler interface {
+	Handle(client net.Addr, r *dns.Msg) *dns.Msg
+}
+
+// dohListener serves DNS-over-HTTPS per RFC 8484: POST with an
+// application/dns-message body, or GET with a base64url "dns" query
+// parameter. Both are decoded to a *dns.Msg, dispatched through the same
+// Handler as every other listener, and packed back as
+// application/dns-message.
+type dohListener struct {
+	addr     string
+	certFile string
+	keyFile  string
+	handler  dohHandler
+	server   *http.Server
+	queries  int64
+
+	mu      sync.Mutex
+	running bool
+}
+
+func newDoHListener(addr, path, certFile, keyFile string, handler dohHandler) *dohListener {
+	l := &dohListener{addr: addr, certFile: certFile, keyFile: keyFile, handler: handler}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(path, l.serveDNSQuery)
+
+	l.server = &http.Server{
+		Addr:    addr,
+		Handler: mux,
+	}
+
+	return l
+}
+
+func (l *dohListener) serveDNSQuery(w http.ResponseWriter, r *http.Request) {
+	atomic.AddInt64(&l.queries, 1)
+
+	var wire []byte
+	var err error
+
+	switch r.Method {
+	case http.MethodGet:
+		param := r.URL.Query().Get("dns")
+		if param == "" {
+			http.Error(w, "missing dns query parameter", http.StatusBadRequest)
+			return
+		}
+		wire, err = base64.RawURLEncoding.DecodeString(param)
+	case http.MethodPost:
+		if r.Header.Get("Content-Type") != "application/dns-message" {
+			http.Error(w, "content-type must be application/dns-message", http.StatusUnsupportedMediaType)
+			return
+		}
+		wire, err = io.ReadAll(r.Body)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		http.Error(w, "malformed dns query", http.StatusBadRequest)
+		return
+	}
+
+	query := &dns.Msg{}
+	if err := query.Unpack(wire); err != nil {
+		http.Error(w, "malformed dns query", http.StatusBadRequest)
+		return
+	}
+
+	response := l.handler.Handle(httpRemoteAddr(r.RemoteAddr), query)
+
+	packed, err := response.Pack()
+	if err != nil {
+		http.Error(w, "failed to encode response", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/dns-message")
+	w.Write(packed)
+}
+
+func (l *dohListener) Start() error {
+	l.mu.Lock()
+	l.running = true
+	l.mu.Unlock()
+
+	err := l.server.ListenAndServeTLS(l.certFile, l.keyFile)
+
+	l.mu.Lock()
+	l.running = false
+	l.mu.Unlock()
+
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+func (l *dohListener) Shutdown() error {
+	return l.server.Close()
+}
+
+func (l *dohListener) Status() ListenerStatus {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return ListenerStatus{
+		Net:     "https",
+		Address: l.addr,
+		Running: l.running,
+		Queries: atomic.LoadInt64(&l.queries),
+	}
+}
+
+// httpRemoteAddr adapts an http.Request.RemoteAddr string to net.Addr so
+// DoH queries can be logged the same way UDP/TCP/DoT queries are.
+type httpRemoteAddr string
+
+func (a httpRemoteAddr) Network() string { return "tcp" }
+func (a httpRemoteAddr) String() string  { return string(a) }
+
+// metricsListener serves one or more query log / health handlers on their
+// own port, separate from the DNS-serving listeners. It backs the
+// Prometheus sink's /metrics endpoint, the memory sink's /stats endpoint,
+// and (sharing the /metrics port) a /healthz liveness endpoint.
+type metricsListener struct {
+	addr   string
+	server *http.Server
+
+	mu      sync.Mutex
+	running bool
+}
+
+func newMetricsListener(addr string, routes map[string]http.Handler) *metricsListener {
+	l := &metricsListener{addr: addr}
+
+	mux := http.NewServeMux()
+	for path, handler := range routes {
+		mux.Handle(path, handler)
+	}
+
+	l.server = &http.Server{
+		Addr:    addr,
+		Handler: mux,
+	}
+
+	return l
+}
+
+func (l *metricsListener) Start() error {
+	l.mu.Lock()
+	l.running = true
+	l.mu.Unlock()
+
+	err := l.server.ListenAndServe()
+
+	l.mu.Lock()
+	l.running = false
+	l.mu.Unlock()
+
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+func (l *metricsListener) Shutdown() error {
+	return l.server.Close()
+}
+
+func (l *metricsListener) Status() ListenerStatus {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return ListenerStatus{
+		Net:     "http",
+		Address: l.addr,
+		Running: l.running,
+	}
+}
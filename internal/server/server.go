@@ -1,92 +1,248 @@
 package server
 
 import (
+	"crypto/tls"
 	"fmt"
 	"log"
 	"net"
+	"net/http"
 	"time"
 
-	"github.com/miekg/dns"
+	"golang.org/x/sync/errgroup"
+
 	"github.com/risadams/Pocket-Concierge/internal/config"
 	dnshandler "github.com/risadams/Pocket-Concierge/internal/dns"
 )
 
+// defaultHealthzWindow is how recently an upstream must have answered
+// successfully for /healthz to report healthy, when
+// cfg.QueryLog.HealthzWindowSeconds is left at its zero value.
+const defaultHealthzWindow = 60 * time.Second
+
+// healthzWindow returns the configured /healthz freshness window, falling
+// back to defaultHealthzWindow.
+func healthzWindow(cfg config.QueryLogConfig) time.Duration {
+	if cfg.HealthzWindowSeconds <= 0 {
+		return defaultHealthzWindow
+	}
+	return time.Duration(cfg.HealthzWindowSeconds) * time.Second
+}
+
+// Default ports for the encrypted transports Pocket-Concierge can serve,
+// matching the ports it advertises via DDR (internal/dns/ddr.go).
+const (
+	dotPort = 853
+	dohPort = 443
+)
+
 // Server represents the PocketConcierge DNS server
 type Server struct {
 	config     *config.Config
 	dnsHandler *dnshandler.Handler
-	server     *dns.Server
+	listeners  []listener
 }
 
-// NewServer creates a new PocketConcierge server with optimized settings
+// NewServer creates a new PocketConcierge server with optimized settings.
+// It serves plain UDP and TCP on cfg.Server.Address/Port unless disabled via
+// cfg.Server.DisableUDP/DisableTCP, and adds a DoT and/or DoH listener per
+// address in cfg.Encrypted when the corresponding protocol is enabled there.
 func NewServer(cfg *config.Config) *Server {
 	handler := dnshandler.NewHandler(cfg)
+	primaryAddr := fmt.Sprintf("%s:%d", cfg.Server.Address, cfg.Server.Port)
+
+	var listeners []listener
+	if !cfg.Server.DisableUDP {
+		listeners = append(listeners, newDNSListener("udp", primaryAddr, handler, nil))
+	}
+	if !cfg.Server.DisableTCP {
+		listeners = append(listeners, newDNSListener("tcp", primaryAddr, handler, nil))
+	}
+
+	if cfg.Encrypted.Enabled("dot") {
+		if tlsConfig, err := loadTLSConfig(cfg.Encrypted); err != nil {
+			log.Printf("⚠️  DoT enabled but TLS config could not be loaded: %v", err)
+		} else {
+			for _, addr := range encryptedAddresses(cfg) {
+				listeners = append(listeners, newDNSListener("tcp", fmt.Sprintf("%s:%d", addr, dotPort), handler, tlsConfig))
+			}
+		}
+	}
 
-	server := &dns.Server{
-		Addr:         fmt.Sprintf("%s:%d", cfg.Server.Address, cfg.Server.Port),
-		Net:          "udp",
-		Handler:      handler,
-		ReadTimeout:  3 * time.Second, // Reduced timeout
-		WriteTimeout: 3 * time.Second, // Reduced timeout
-		UDPSize:      65535,           // Maximum UDP packet size
+	if cfg.Encrypted.Enabled("doh") {
+		path := cfg.Encrypted.DoHPath
+		if path == "" {
+			path = "/dns-query"
+		}
+		for _, addr := range encryptedAddresses(cfg) {
+			listeners = append(listeners, newDoHListener(fmt.Sprintf("%s:%d", addr, dohPort), path, cfg.Encrypted.CertFile, cfg.Encrypted.KeyFile, handler))
+		}
+	}
+
+	if cfg.QueryLog.MetricsPort != 0 {
+		routes := map[string]http.Handler{
+			"/healthz": handler.HealthzHandler(healthzWindow(cfg.QueryLog)),
+		}
+		if mh := handler.MetricsHandler(); mh != nil {
+			routes["/metrics"] = mh
+		} else {
+			log.Printf("⚠️  query_log.metrics_port is set but no prometheus sink is configured")
+		}
+		metricsAddr := fmt.Sprintf("%s:%d", cfg.Server.Address, cfg.QueryLog.MetricsPort)
+		listeners = append(listeners, newMetricsListener(metricsAddr, routes))
+	}
+
+	if cfg.QueryLog.StatsPort != 0 {
+		if sh := handler.StatsHandler(); sh != nil {
+			statsAddr := fmt.Sprintf("%s:%d", cfg.Server.Address, cfg.QueryLog.StatsPort)
+			listeners = append(listeners, newMetricsListener(statsAddr, map[string]http.Handler{"/stats": sh}))
+		} else {
+			log.Printf("⚠️  query_log.stats_port is set but no memory sink is configured")
+		}
 	}
 
 	return &Server{
 		config:     cfg,
 		dnsHandler: handler,
-		server:     server,
+		listeners:  listeners,
+	}
+}
+
+// loadTLSConfig builds the TLS config a DoT listener serves with, from the
+// certificate/key pair configured for Pocket-Concierge's encrypted listeners.
+func loadTLSConfig(enc config.EncryptedListeners) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(enc.CertFile, enc.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
 	}
+	return &tls.Config{Certificates: []tls.Certificate{cert}}, nil
 }
 
-// Start begins serving DNS requests
+// encryptedAddresses returns the addresses encrypted listeners should bind
+// to, falling back to the plain server's address when none are configured.
+func encryptedAddresses(cfg *config.Config) []string {
+	if len(cfg.Encrypted.Addresses) > 0 {
+		return cfg.Encrypted.Addresses
+	}
+	return []string{cfg.Server.Address}
+}
+
+// Start begins serving DNS requests on every configured listener
+// concurrently, supervised by an errgroup. It blocks until all listeners
+// have stopped, returning the first error any of them reported (if any).
 func (s *Server) Start() error {
-	log.Printf("🚀 Starting DNS server on %s", s.server.Addr)
+	log.Printf("🚀 Starting DNS server with %d listener(s) on %s", len(s.listeners), s.listeners[0].Status().Address)
 
-	// Check if we can bind to the port
+	if s.config.DNS.StartVerifyUpstream {
+		if err := s.dnsHandler.VerifyUpstreams(); err != nil {
+			return fmt.Errorf("upstream verification failed: %w", err)
+		}
+	}
+
+	// Check if we can bind to the primary port(s)
 	if err := s.checkPort(); err != nil {
 		return fmt.Errorf("port check failed: %w", err)
 	}
 
-	// Start the server
-	if err := s.server.ListenAndServe(); err != nil {
-		return fmt.Errorf("failed to start DNS server: %w", err)
+	var g errgroup.Group
+	for _, l := range s.listeners {
+		l := l
+		g.Go(l.Start)
 	}
 
+	if err := g.Wait(); err != nil {
+		return fmt.Errorf("listener errors: %w", err)
+	}
 	return nil
 }
 
-// Stop gracefully shuts down the server
+// Stop gracefully shuts down every listener, supervised by an errgroup,
+// then closes the DNS handler so buffered query log entries are drained
+// before the process exits.
 func (s *Server) Stop() error {
 	log.Println("🛑 Stopping DNS server...")
-	return s.server.Shutdown()
+
+	var g errgroup.Group
+	for _, l := range s.listeners {
+		l := l
+		g.Go(l.Shutdown)
+	}
+	shutdownErr := g.Wait()
+
+	closeErr := s.dnsHandler.Close()
+
+	switch {
+	case shutdownErr != nil && closeErr != nil:
+		return fmt.Errorf("listener shutdown errors: %w (query log close error: %v)", shutdownErr, closeErr)
+	case shutdownErr != nil:
+		return fmt.Errorf("listener shutdown errors: %w", shutdownErr)
+	case closeErr != nil:
+		return fmt.Errorf("query log close error: %w", closeErr)
+	}
+	return nil
 }
 
-// checkPort verifies we can bind to the configured port
+// checkPort verifies we can bind to the primary configured port on every
+// plain transport that isn't disabled, before ListenAndServe is called.
 func (s *Server) checkPort() error {
 	addr := fmt.Sprintf("%s:%d", s.config.Server.Address, s.config.Server.Port)
 
-	// Try to bind to the port
-	conn, err := net.ListenPacket("udp", addr)
-	if err != nil {
-		if s.config.Server.Port == 53 {
-			return fmt.Errorf("cannot bind to port 53 (requires admin/root privileges). Try port 5353 for testing: %w", err)
+	if !s.config.Server.DisableUDP {
+		conn, err := net.ListenPacket("udp", addr)
+		if err != nil {
+			if s.config.Server.Port == 53 {
+				return fmt.Errorf("cannot bind to port 53 (requires admin/root privileges). Try port 5353 for testing: %w", err)
+			}
+			return err
+		}
+		conn.Close()
+	}
+
+	if !s.config.Server.DisableTCP {
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			if s.config.Server.Port == 53 {
+				return fmt.Errorf("cannot bind to port 53 (requires admin/root privileges). Try port 5353 for testing: %w", err)
+			}
+			return err
 		}
-		return err
+		ln.Close()
 	}
 
-	// Close immediately - we just wanted to test
-	conn.Close()
 	return nil
 }
 
-// GetStats returns basic server statistics
+// GetStats returns basic server statistics, including per-listener status.
 func (s *Server) GetStats() map[string]interface{} {
-	return map[string]interface{}{
+	listenerStats := make([]ListenerStatus, len(s.listeners))
+	for i, l := range s.listeners {
+		listenerStats[i] = l.Status()
+	}
+
+	stats := map[string]interface{}{
 		"address":      s.config.Server.Address,
 		"port":         s.config.Server.Port,
 		"upstream_dns": s.config.Upstream,
 		"local_hosts":  len(s.config.Hosts),
 		"ttl":          s.config.DNS.TTL,
 		"recursion":    s.config.DNS.EnableRecursion,
+		"listeners":    listenerStats,
 	}
+
+	if filterStats := s.dnsHandler.FilterStats(); filterStats != nil {
+		stats["filter"] = filterStats
+	}
+
+	if cacheStats := s.dnsHandler.CacheStats(); cacheStats != nil {
+		stats["cache"] = cacheStats
+	}
+
+	// Exposed whenever any upstream is configured, not just when the active
+	// background prober (UpstreamHealthcheck) is on: the query-traffic-driven
+	// health tracking that backs parallel_best/fastest upstream selection
+	// runs regardless of that setting.
+	if len(s.config.Upstream) > 0 {
+		stats["upstream_health"] = s.dnsHandler.UpstreamHealth()
+	}
+
+	return stats
 }
@@ -35,30 +35,78 @@ func TestNewServer(t *testing.T) {
 		t.Error("DNS handler not initialized")
 	}
 
-	if server.server == nil {
-		t.Error("DNS server not initialized")
+	expectedAddr := fmt.Sprintf("%s:%d", cfg.Server.Address, cfg.Server.Port)
+
+	if len(server.listeners) != 2 {
+		t.Fatalf("Expected 2 listeners (udp + tcp) with no encrypted transports configured, got %d", len(server.listeners))
 	}
 
-	expectedAddr := fmt.Sprintf("%s:%d", cfg.Server.Address, cfg.Server.Port)
-	if server.server.Addr != expectedAddr {
-		t.Errorf("Expected server address %s, got %s", expectedAddr, server.server.Addr)
+	nets := map[string]ListenerStatus{}
+	for _, l := range server.listeners {
+		status := l.Status()
+		nets[status.Net] = status
 	}
 
-	if server.server.Net != "udp" {
-		t.Errorf("Expected UDP protocol, got %s", server.server.Net)
+	udpStatus, ok := nets["udp"]
+	if !ok {
+		t.Fatal("Expected a udp listener")
+	}
+	if udpStatus.Address != expectedAddr {
+		t.Errorf("Expected udp listener address %s, got %s", expectedAddr, udpStatus.Address)
 	}
 
-	// Check timeouts are set
-	if server.server.ReadTimeout != 3*time.Second {
-		t.Errorf("Expected read timeout 3s, got %v", server.server.ReadTimeout)
+	tcpStatus, ok := nets["tcp"]
+	if !ok {
+		t.Fatal("Expected a tcp listener")
+	}
+	if tcpStatus.Address != expectedAddr {
+		t.Errorf("Expected tcp listener address %s, got %s", expectedAddr, tcpStatus.Address)
 	}
+}
 
-	if server.server.WriteTimeout != 3*time.Second {
-		t.Errorf("Expected write timeout 3s, got %v", server.server.WriteTimeout)
+func TestNewServerDisableTransport(t *testing.T) {
+	tests := []struct {
+		name       string
+		disableUDP bool
+		disableTCP bool
+		expectNets []string
+	}{
+		{name: "disable udp", disableUDP: true, expectNets: []string{"tcp"}},
+		{name: "disable tcp", disableTCP: true, expectNets: []string{"udp"}},
 	}
 
-	if server.server.UDPSize != 65535 {
-		t.Errorf("Expected UDP size 65535, got %d", server.server.UDPSize)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{
+				Server: config.ServerConfig{
+					Address:    "127.0.0.1",
+					Port:       8053,
+					DisableUDP: tt.disableUDP,
+					DisableTCP: tt.disableTCP,
+				},
+				DNS: config.DNSConfig{
+					TTL:             300,
+					EnableRecursion: true,
+				},
+				HomeDNSDomain: "home",
+			}
+
+			server := NewServer(cfg)
+			if len(server.listeners) != len(tt.expectNets) {
+				t.Fatalf("expected %d listener(s), got %d", len(tt.expectNets), len(server.listeners))
+			}
+			for _, proto := range tt.expectNets {
+				found := false
+				for _, l := range server.listeners {
+					if l.Status().Net == proto {
+						found = true
+					}
+				}
+				if !found {
+					t.Errorf("expected a %s listener", proto)
+				}
+			}
+		})
 	}
 }
 
@@ -123,6 +171,61 @@ func TestServerCheckPort(t *testing.T) {
 	}
 }
 
+func TestServerCheckPortDetectsTCPConflict(t *testing.T) {
+	port := findFreePort()
+
+	ln, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		t.Fatalf("could not bind test TCP port: %v", err)
+	}
+	defer ln.Close()
+
+	cfg := &config.Config{
+		Server: config.ServerConfig{
+			Address: "127.0.0.1",
+			Port:    port,
+		},
+		DNS: config.DNSConfig{
+			TTL:             300,
+			EnableRecursion: true,
+		},
+		HomeDNSDomain: "home",
+	}
+
+	server := NewServer(cfg)
+	if err := server.checkPort(); err == nil {
+		t.Error("expected checkPort to fail when the TCP port is already bound")
+	}
+}
+
+func TestServerCheckPortSkipsDisabledTransport(t *testing.T) {
+	port := findFreePort()
+
+	ln, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		t.Fatalf("could not bind test TCP port: %v", err)
+	}
+	defer ln.Close()
+
+	cfg := &config.Config{
+		Server: config.ServerConfig{
+			Address:    "127.0.0.1",
+			Port:       port,
+			DisableTCP: true,
+		},
+		DNS: config.DNSConfig{
+			TTL:             300,
+			EnableRecursion: true,
+		},
+		HomeDNSDomain: "home",
+	}
+
+	server := NewServer(cfg)
+	if err := server.checkPort(); err != nil {
+		t.Errorf("expected checkPort to skip the disabled TCP transport, got: %v", err)
+	}
+}
+
 func TestServerGetStats(t *testing.T) {
 	cfg := &config.Config{
 		Server: config.ServerConfig{
@@ -178,6 +281,31 @@ func TestServerGetStats(t *testing.T) {
 	if stats["recursion"] != cfg.DNS.EnableRecursion {
 		t.Errorf("Expected recursion %v, got %v", cfg.DNS.EnableRecursion, stats["recursion"])
 	}
+
+	if _, exists := stats["upstream_health"]; !exists {
+		t.Error("Expected upstream_health to be reported even with UpstreamHealthcheck disabled, since it tracks real query traffic")
+	}
+}
+
+func TestServerGetStatsOmitsUpstreamHealthWithoutUpstreams(t *testing.T) {
+	cfg := &config.Config{
+		Server: config.ServerConfig{
+			Address: "127.0.0.1",
+			Port:    8053,
+		},
+		DNS: config.DNSConfig{
+			TTL:             300,
+			EnableRecursion: true,
+		},
+		HomeDNSDomain: "home",
+	}
+
+	server := NewServer(cfg)
+	stats := server.GetStats()
+
+	if _, exists := stats["upstream_health"]; exists {
+		t.Error("Expected upstream_health to be omitted with no configured upstreams")
+	}
 }
 
 func TestServerStartStop(t *testing.T) {